@@ -1,38 +1,21 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
 	"github.com/duplocloud/duplo-jit/duplocloud"
 	"github.com/duplocloud/duplo-jit/internal"
+	"github.com/duplocloud/duplo-jit/jit"
 )
 
-func mustDuploClient(host, token string, interactive, admin bool, port int) *duplocloud.Client {
-	otp := ""
-
-	// Possibly get a token from an interactive process.
-	if token == "" {
-		if !interactive {
-			log.Fatalf("%s: --token not specified and --interactive mode is disabled", os.Args[0])
-		}
-
-		tokenResult := internal.MustTokenInteractive(host, admin, "duplo-aws-credential-process", port)
-		token = tokenResult.Token
-		otp = tokenResult.OTP
-	}
-
-	// Create the client.
-	client, err := duplocloud.NewClientWithOtp(host, token, otp)
-	internal.DieIf(err, "invalid arguments")
-
-	return client
-}
-
 var commit string
 var version string
 
@@ -40,19 +23,47 @@ func main() {
 	// Make sure we log to stderr - so we don't disturb the output to be collected by the AWS CLI
 	log.SetOutput(os.Stderr)
 
+	// Cancel API calls and interactive sessions on Ctrl-C or termination.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer stop()
+
 	// Parse command-line arguments.
 	host := flag.String("host", "", "Duplo API base URL")
 	token := flag.String("token", "", "Duplo API token")
 	admin := flag.Bool("admin", false, "Get admin credentials")
 	duploOps := flag.Bool("duplo-ops", false, "Get Duplo operations credentials")
 	tenantID := flag.String("tenant", "", "Get credentials for the given tenant")
+	yes := flag.Bool("yes", false, "Issue credentials for production hosts and tenants without asking for confirmation")
+	reason := flag.String("reason", "", "Reason for the credentials, such as \"INC-1234: ...\", sent to the portal and recorded in the audit log")
 	debug := flag.Bool("debug", false, "Turn on verbose (debugging) output")
+	logLevel := flag.String("log-level", "info", "Log level: trace, debug, info, warn or error")
+	logFormat := flag.String("log-format", "text", "Log format: text or json")
+	logFile := flag.String("log-file", "", "Append logs to the given file instead of stderr")
+	timeout := flag.Duration("timeout", duplocloud.DefaultTimeout, "Timeout for each Duplo API call")
+	retries := flag.Int("retries", duplocloud.DefaultRetryPolicy().MaxAttempts-1, "Number of times to retry a Duplo API call after a transient failure")
+	retryMaxTime := flag.Duration("retry-max-time", duplocloud.DefaultRetryPolicy().MaxElapsed, "Total time allowed for a Duplo API call, including retries")
+	proxy := flag.String("proxy", "", "Proxy URL for outgoing connections (defaults to HTTPS_PROXY, honoring NO_PROXY)")
+	caBundle := flag.String("ca-bundle", "", "PEM file of additional CA certificates to trust")
+	clientCert := flag.String("client-cert", "", "PEM file of a client certificate for mutual TLS")
+	clientKey := flag.String("client-key", "", "PEM file of the private key for --client-cert")
+	tlsMinVersion := flag.String("tls-min-version", "1.2", "Minimum TLS version: 1.2 or 1.3")
 	noCache := flag.Bool("no-cache", false, "Disable caching (not recommended)")
 	interactive := flag.Bool("interactive", false, "Allow getting Duplo credentials via an interactive browser session")
 	showVersion := flag.Bool("version", false, "Output version information and exit")
 	port := flag.Int("port", 0, "Port to use for the local web server")
+	noBrowser := flag.Bool("no-browser", false, "Print the login URL instead of opening a browser (the default when there is no display)")
+	browser := flag.String("browser", "", "Command used to open the login URL (defaults to the BROWSER environment variable)")
+	otp := flag.String("otp", "", "OTP code, for portals that require MFA for admin access")
+	otpSecretFile := flag.String("otp-secret-file", "", "File holding a TOTP secret or otpauth:// URI, used to generate OTP codes")
+	allowCallbackWithoutState := flag.Bool("allow-callback-without-state", false, "Accept interactive logins from older portals that do not return the login state (less secure)")
 	flag.Parse()
 
+	// Configure logging, before anything is logged.
+	if *debug {
+		*logLevel = "trace"
+	}
+	internal.MustInitLogging(*logLevel, *logFormat, *logFile)
+
 	// Output version information
 	if *showVersion {
 		if version == "" {
@@ -68,88 +79,63 @@ func main() {
 	// Refuse to call APIs over anything but https://
 	// Trim a trailing slash.
 	if host == nil || !strings.HasPrefix(*host, "https://") {
-		log.Fatalf("%s: %s", os.Args[0], "--host must be present and start with https://")
+		internal.Fatal("--host must be present and start with https://", nil)
 	}
 	*host = strings.TrimSuffix(*host, "/")
 
-	// Possibly enable debugging
-	if *debug {
-		duplocloud.LogLevel = duplocloud.TRACE
-	}
-
-	// Prepare the cache directory
-	internal.MustInitCache("duplo-aws-credential-process", *noCache)
-
-	// Get AWS credentials and output them
-	var creds *internal.AwsConfigOutput
-	var cacheKey string
-	if *admin {
-
-		// Build the cache key
-		cacheKey = strings.Join([]string{strings.TrimPrefix(*host, "https://"), "admin"}, ",")
+	// Configure timeouts and retries for the Duplo API.
+	internal.ConfigureDuploClients(*timeout, internal.RetryPolicyFromFlags(*retries, *retryMaxTime))
 
-		// Try to find credentials from the cache.
-		creds = internal.CacheGetAwsConfigOutput(cacheKey)
+	// Configure proxies and TLS.
+	err := internal.ConfigureTransport(duplocloud.TransportOptions{
+		Proxy:         *proxy,
+		CABundle:      *caBundle,
+		ClientCert:    *clientCert,
+		ClientKey:     *clientKey,
+		MinTLSVersion: *tlsMinVersion,
+	})
+	internal.DieIf(err, "invalid proxy or TLS options")
 
-		// Otherwise, get the credentials from Duplo.
-		if creds == nil {
-			client := mustDuploClient(*host, *token, *interactive, true, *port)
-			result, err := client.AdminGetJitAwsCredentials()
-			internal.DieIf(err, "failed to get credentials")
-			creds = internal.ConvertAwsCreds(result)
-		}
+	// Configure interactive sessions.
+	internal.ConfigureBrowser(*noBrowser, *browser)
+	internal.ConfigureCallback(*allowCallbackWithoutState)
+	internal.ConfigureOtp(*otp, *otpSecretFile)
+	internal.DieIf(internal.ConfigureReason(*reason), "invalid --reason")
 
-	} else if *duploOps {
+	// Load the configuration file, which holds the policy and the retention policy.
+	internal.MustLoadConfig()
+	internal.ConfigurePolicy(*yes)
 
-		// Build the cache key
-		cacheKey = strings.Join([]string{strings.TrimPrefix(*host, "https://"), "duplo-ops"}, ",")
+	// Refuse hosts that the policy does not allow.
+	internal.MustAllowHost(*host)
 
-		// Try to find credentials from the cache.
-		creds = internal.CacheGetAwsConfigOutput(cacheKey)
+	// Prepare the cache directory
+	internal.MustInitCache("duplo-aws-credential-process", *noCache)
 
-		// Otherwise, get the credentials from Duplo.
-		if creds == nil {
-			client := mustDuploClient(*host, *token, *interactive, true, *port)
-			result, err := client.AdminAwsGetJitAccess("duplo-ops")
-			internal.DieIf(err, "failed to get credentials")
-			creds = internal.ConvertAwsCreds(result)
-		}
+	// Prepare the session.  Duplo tokens are not cached, and OTP codes only come from the flags.
+	opts := internal.SessionOptions(*host, *host, *token, *interactive, "duplo-aws-credential-process", *port)
+	opts.TokenCache = nil
+	opts.CacheKey = strings.TrimPrefix(*host, "https://")
+	opts.OTP = func(context.Context) (string, error) {
+		return internal.OtpFromFlags(), nil
+	}
+	session := internal.MustSession(opts)
 
+	// Get AWS credentials and output them
+	role := jit.RoleTenant
+	if *admin {
+		role = jit.RoleAdmin
+	} else if *duploOps {
+		role = jit.RoleDuploOps
 	} else if tenantID == nil || *tenantID == "" {
 
 		// Tenant credentials require an additional argument.
 		internal.DieIf(errors.New("must specify --admin or --tenant=NAME or --tenant=ID"), "invalid arguments")
-
-	} else {
-
-		// Build the cache key.
-		cacheKey = strings.Join([]string{strings.TrimPrefix(*host, "https://"), "tenant", *tenantID}, ",")
-
-		// Try to find credentials from the cache.
-		creds = internal.CacheGetAwsConfigOutput(cacheKey)
-
-		// Otherwise, get the credentials from Duplo.
-		if creds == nil {
-			client := mustDuploClient(*host, *token, *interactive, false, *port)
-
-			// If it doesn't look like a UUID, get the tenant ID from the name.
-			if len(*tenantID) < 32 {
-				var err error
-				tenant, err := client.GetTenantByNameForUser(*tenantID)
-				if tenant == nil || err != nil {
-					internal.Fatal(fmt.Sprintf("%s: tenant missing or not allowed", *tenantID), err)
-				} else {
-					tenantID = &tenant.TenantID
-				}
-			}
-
-			// Tenant: Get the JIT AWS credentials
-			result, err := client.TenantGetJitAwsCredentials(*tenantID)
-			internal.DieIf(err, "failed to get credentials")
-			creds = internal.ConvertAwsCreds(result)
-		}
 	}
+	ctx = internal.MustAuthorize(ctx, session, role, *tenantID)
+	result, err := session.GetAwsCredentials(ctx, role, *tenantID)
+	internal.DieIf(err, "failed to get credentials")
 
 	// Finally, we can output credentials.
-	internal.OutputAwsCreds(creds, cacheKey)
+	internal.OutputAwsCreds(result)
 }
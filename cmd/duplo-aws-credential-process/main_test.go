@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/duplocloud/duplo-jit/duplocloud"
+	"github.com/duplocloud/duplo-jit/duplocloud/duplotest"
+	"github.com/duplocloud/duplo-jit/internal"
+)
+
+// runMainEnv makes the test binary run duplo-aws-credential-process, so that tests can run it as a command.
+const runMainEnv = "DUPLO_JIT_TEST_RUN_MAIN"
+
+func TestMain(m *testing.M) {
+	duplotest.RunBrowser()
+	if os.Getenv(runMainEnv) == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runCredentialProcess runs the command against a fake portal, returning its output and its error.
+func runCredentialProcess(t *testing.T, s *duplotest.Server, args ...string) (string, string, error) {
+	t.Helper()
+	return runCredentialProcessWithConfig(t, s, "", args...)
+}
+
+// runCredentialProcessWithConfig runs the command with the given configuration file, if any.
+func runCredentialProcessWithConfig(t *testing.T, s *duplotest.Server, config string, args ...string) (string, string, error) {
+	t.Helper()
+	home := t.TempDir()
+	if config != "" {
+		dir := filepath.Join(home, ".config", "duplo-jit")
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	args = append([]string{"--host", s.URL, "--ca-bundle", s.WriteCABundle(t)}, args...)
+	cmd := exec.Command(os.Args[0], args...)
+
+	cmd.Env = []string{runMainEnv + "=1", "HOME=" + home, "PATH=" + os.Getenv("PATH"),
+		"XDG_CACHE_HOME=" + filepath.Join(home, ".cache"), "XDG_CONFIG_HOME=" + filepath.Join(home, ".config")}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	return stdout.String(), stderr.String(), err
+}
+
+func mustAwsCreds(t *testing.T, s *duplotest.Server, args ...string) *internal.AwsConfigOutput {
+	t.Helper()
+	stdout, stderr, err := runCredentialProcess(t, s, args...)
+	if err != nil {
+		t.Fatalf("duplo-aws-credential-process: %v\n%s", err, stderr)
+	}
+	creds := &internal.AwsConfigOutput{}
+	if err := json.Unmarshal([]byte(stdout), creds); err != nil {
+		t.Fatalf("invalid output %q: %v", stdout, err)
+	}
+	return creds
+}
+
+func TestCredentialProcess_Tenant(t *testing.T) {
+	s := duplotest.NewTLSServer(duplotest.Config{Tenants: []duplocloud.UserTenant{
+		{TenantID: "11111111-1111-1111-1111-111111111111", AccountName: "dev"},
+	}})
+	defer s.Close()
+
+	creds := mustAwsCreds(t, s, "--token", duplotest.DefaultToken, "--tenant", "dev")
+	if creds.Version != 1 || creds.AccessKeyId != "ASIADUPLOTESTDEV" || creds.Expiration == "" {
+		t.Errorf("unexpected credentials: %+v", creds)
+	}
+}
+
+func TestCredentialProcess_AdminInteractiveWithOTP(t *testing.T) {
+	s := duplotest.NewTLSServer(duplotest.Config{Admin: true, OTP: "123456"})
+	defer s.Close()
+
+	creds := mustAwsCreds(t, s, "--admin", "--interactive", "--browser", s.BrowserCommand(t))
+	if creds.AccessKeyId != "ASIADUPLOTESTADMIN" {
+		t.Errorf("unexpected credentials: %+v", creds)
+	}
+	if s.Logins() != 1 {
+		t.Errorf("expected 1 login, got %d", s.Logins())
+	}
+}
+
+func TestCredentialProcess_RequiresHttps(t *testing.T) {
+	s := duplotest.NewServer(duplotest.Config{})
+	defer s.Close()
+
+	cmd := exec.Command(os.Args[0], "--host", s.URL, "--admin", "--token", duplotest.DefaultToken)
+	cmd.Env = []string{runMainEnv + "=1", "HOME=" + t.TempDir()}
+	out, err := cmd.CombinedOutput()
+	if err == nil || !strings.Contains(string(out), "must be present and start with https://") {
+		t.Errorf("expected an error, got %v: %s", err, out)
+	}
+	if s.TotalCalls() != 0 {
+		t.Errorf("expected no calls, got %d", s.TotalCalls())
+	}
+}
+
+func TestCredentialProcess_Policy(t *testing.T) {
+	s := duplotest.NewTLSServer(duplotest.Config{Admin: true, Tenants: []duplocloud.UserTenant{
+		{TenantID: "11111111-1111-1111-1111-111111111111", AccountName: "dev"},
+	}})
+	defer s.Close()
+
+	// Admin credentials are refused on a ForbidAdmin host, before any JIT call.
+	_, stderr, err := runCredentialProcessWithConfig(t, s, `{"Policy": {"Hosts": {"127.0.0.1": {"ForbidAdmin": true}}}}`,
+		"--admin", "--token", duplotest.DefaultToken)
+	if err == nil || !strings.Contains(stderr, "credentials not allowed") {
+		t.Errorf("expected admin credentials to be refused, got %v: %s", err, stderr)
+	}
+	if got := s.Calls("v3/admin/aws/jitAccess/admin"); got != 0 {
+		t.Errorf("expected no JIT calls, got %d", got)
+	}
+
+	// So are hosts that are not allowed.
+	_, stderr, err = runCredentialProcessWithConfig(t, s, `{"Policy": {"AllowedHosts": ["*.duplocloud.net"]}}`,
+		"--tenant", "dev", "--token", duplotest.DefaultToken)
+	if err == nil || !strings.Contains(stderr, "host not allowed") {
+		t.Errorf("expected the host to be refused, got %v: %s", err, stderr)
+	}
+
+	// Production credentials need --yes without a terminal.
+	config := `{"Policy": {"Hosts": {"127.0.0.1": {"Production": true}}}}`
+	if _, stderr, err = runCredentialProcessWithConfig(t, s, config, "--admin", "--token", duplotest.DefaultToken); err == nil {
+		t.Errorf("expected a confirmation to be required: %s", stderr)
+	}
+	if _, stderr, err = runCredentialProcessWithConfig(t, s, config, "--admin", "--yes", "--token", duplotest.DefaultToken); err != nil {
+		t.Errorf("duplo-aws-credential-process --yes: %v\n%s", err, stderr)
+	}
+}
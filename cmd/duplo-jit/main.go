@@ -1,16 +1,20 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/duplocloud/duplo-jit/duplocloud"
 	"github.com/duplocloud/duplo-jit/internal"
-	clientauthv1beta1 "k8s.io/client-go/pkg/apis/clientauthentication/v1beta1"
+	"github.com/duplocloud/duplo-jit/jit"
 )
 
 var commit string
@@ -21,25 +25,54 @@ func main() {
 	var duploOps *bool
 	var tenantID *string
 	var planID *string
+	var output *string
+	var allHosts *bool
+	var renew *bool
+	var since *time.Duration
+	var yes *bool
+	var reason *string
+	var window *time.Duration
 
 	// Make sure we log to stderr - so we don't disturb the output to be collected by the AWS CLI
 	log.SetOutput(os.Stderr)
 
+	// Cancel API calls and interactive sessions on Ctrl-C or termination.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer stop()
+
 	// Common command-line arguments.
 	host := flag.String("host", "", "DuploCloud base URL")
 	token := flag.String("token", "", "DuploCloud API token")
 	debug := flag.Bool("debug", false, "Turn on verbose (debugging) output")
+	logLevel := flag.String("log-level", "info", "Log level: trace, debug, info, warn or error")
+	logFormat := flag.String("log-format", "text", "Log format: text or json")
+	logFile := flag.String("log-file", "", "Append logs to the given file instead of stderr")
+	timeout := flag.Duration("timeout", duplocloud.DefaultTimeout, "Timeout for each Duplo API call")
+	retries := flag.Int("retries", duplocloud.DefaultRetryPolicy().MaxAttempts-1, "Number of times to retry a Duplo API call after a transient failure")
+	retryMaxTime := flag.Duration("retry-max-time", duplocloud.DefaultRetryPolicy().MaxElapsed, "Total time allowed for a Duplo API call, including retries")
+	proxy := flag.String("proxy", "", "Proxy URL for outgoing connections (defaults to HTTPS_PROXY, honoring NO_PROXY)")
+	caBundle := flag.String("ca-bundle", "", "PEM file of additional CA certificates to trust")
+	clientCert := flag.String("client-cert", "", "PEM file of a client certificate for mutual TLS")
+	clientKey := flag.String("client-key", "", "PEM file of the private key for --client-cert")
+	tlsMinVersion := flag.String("tls-min-version", "1.2", "Minimum TLS version: 1.2 or 1.3")
 	noCache := flag.Bool("no-cache", false, "Disable caching (not recommended)")
 	interactive := flag.Bool("interactive", false, "Allow getting Duplo credentials via an interactive browser session")
 	port := flag.Int("port", 0, "Port to use for the local web server")
+	noBrowser := flag.Bool("no-browser", false, "Print the login URL instead of opening a browser (the default when there is no display)")
+	browser := flag.String("browser", "", "Command used to open the login URL (defaults to the BROWSER environment variable)")
+	otp := flag.String("otp", "", "OTP code, for portals that require MFA for admin access")
+	otpSecretFile := flag.String("otp-secret-file", "", "File holding a TOTP secret or otpauth:// URI, used to generate OTP codes")
+	allowCallbackWithoutState := flag.Bool("allow-callback-without-state", false, "Accept interactive logins from older portals that do not return the login state (less secure)")
 	showVersion := flag.Bool("version", false, "Output version information and exit")
 	apiHost := flag.String("api-host", "", "Specify an alternate DuploCloud API base URL if it differs from the UI host (defaults to the value of --host if omitted)")
 	admin = new(bool)
 	duploOps = new(bool)
+	yes = new(bool)
+	reason = new(string)
 
 	// Parse the subcommand
 	if len(os.Args) < 2 {
-		fmt.Printf("%s: expected 'aws', 'duplo', 'k8s' or 'clear-cache' subcommands\n", os.Args[0])
+		fmt.Printf("%s: expected 'aws', 'elevate', 'duplo', 'k8s', 'plans', 'whoami', 'login', 'logout', 'audit' or 'clear-cache' subcommands\n", os.Args[0])
 		os.Exit(1)
 	}
 	cmd := os.Args[1]
@@ -58,7 +91,7 @@ func main() {
 	} else if cmd == "clear-cache" {
 		internal.ClearAllCaches()
 		os.Exit(0)
-	} else if cmd != "aws" && cmd != "duplo" && cmd != "k8s" {
+	} else if cmd != "aws" && cmd != "elevate" && cmd != "duplo" && cmd != "k8s" && cmd != "plans" && cmd != "whoami" && cmd != "login" && cmd != "logout" && cmd != "audit" {
 		fmt.Printf("%s: %s: subcommand not implemented\n", os.Args[0], cmd)
 		os.Exit(1)
 	} else {
@@ -66,12 +99,43 @@ func main() {
 			admin = flag.Bool("admin", false, "Get admin credentials")
 			duploOps = flag.Bool("duplo-ops", false, "Get Duplo operations credentials")
 		}
+		if cmd == "elevate" {
+			admin = flag.Bool("admin", false, "Elevate to admin credentials")
+			duploOps = flag.Bool("duplo-ops", false, "Elevate to Duplo operations credentials")
+			window = flag.Duration("for", 30*time.Minute, "How long to hold the credentials in the cache")
+		}
+		if cmd == "whoami" {
+			admin = flag.Bool("admin", false, "Also show the AWS identity of admin credentials")
+			duploOps = flag.Bool("duplo-ops", false, "Also show the AWS identity of Duplo operations credentials")
+			tenantID = flag.String("tenant", "", "Also show the AWS identity of credentials for the given tenant")
+		}
 		if cmd == "k8s" {
 			planID = flag.String("plan", "", "Get credentials for the given plan")
 		}
 		if cmd == "k8s" || cmd == "aws" {
 			tenantID = flag.String("tenant", "", "Get credentials for the given tenant")
 		}
+		if cmd == "k8s" || cmd == "aws" || cmd == "elevate" || cmd == "whoami" || cmd == "duplo" || cmd == "plans" || cmd == "login" {
+			yes = flag.Bool("yes", false, "Issue credentials for production hosts and tenants without asking for confirmation")
+			reason = flag.String("reason", "", "Reason for the credentials, such as \"INC-1234: ...\", sent to the portal and recorded in the audit log")
+		}
+		if cmd == "plans" {
+			output = flag.String("output", "table", "Output format: table, json or kubeconfig")
+		}
+		if cmd == "whoami" {
+			output = flag.String("output", "table", "Output format: table or json")
+		}
+		if cmd == "login" {
+			admin = flag.Bool("admin", false, "Log in with admin access")
+			renew = flag.Bool("renew", false, "Log in again, even if the cached token is still valid")
+		}
+		if cmd == "logout" {
+			allHosts = flag.Bool("all", false, "Log out of all hosts")
+		}
+		if cmd == "audit" {
+			since = flag.Duration("since", 24*time.Hour, "Show credentials issued within the given duration")
+			output = flag.String("output", "table", "Output format: table or json")
+		}
 	}
 
 	// Parse command-line arguments.
@@ -80,15 +144,34 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Configure logging, before anything is logged.
+	if *debug {
+		*logLevel = "trace"
+	}
+	internal.MustInitLogging(*logLevel, *logFormat, *logFile)
+
+	// Load the configuration file, which holds the policy.
+	internal.MustLoadConfig()
+	internal.ConfigurePolicy(*yes)
+	internal.DieIf(internal.ConfigureReason(*reason), "invalid --reason")
+
 	// Validate the host.
-	const fatalFmt = "%s: %s"
-	if *host == "" {
-		log.Fatalf(fatalFmt, os.Args[0], "--host must be present")
+	logoutAll := allHosts != nil && *allHosts
+	if logoutAll {
+		if *host != "" {
+			internal.Fatal("--host and --all cannot be used together", nil)
+		}
+	} else if *host == "" && cmd == "audit" {
+		// Without a host, the audit log covers all hosts.
+	} else if *host == "" && cmd == "logout" {
+		internal.Fatal("--host or --all must be present", nil)
+	} else if *host == "" {
+		internal.Fatal("--host must be present", nil)
 	} else if strings.HasPrefix(*host, "http://localhost") {
 		fmt.Fprintf(os.Stderr, "Using developer host %s\n", *host)
 	} else if !strings.HasPrefix(*host, "https://") {
 		// Refuse to call APIs over anything but https://
-		log.Fatalf(fatalFmt, os.Args[0], "--host must start with https://")
+		internal.Fatal("--host must start with https://", nil)
 	}
 
 	// Trim a trailing slash.
@@ -100,7 +183,7 @@ func main() {
 			fmt.Printf("Using developer api-host %s\n", *apiHost)
 		} else if !strings.HasPrefix(*apiHost, "https://") {
 			// Refuse to call APIs over anything but https://
-			log.Fatalf(fatalFmt, os.Args[0], "--api-host must start with https://")
+			internal.Fatal("--api-host must start with https://", nil)
 		}
 		// Trim a trailing slash.
 		*apiHost = strings.TrimSuffix(*apiHost, "/")
@@ -109,163 +192,158 @@ func main() {
 		apiHost = host
 	}
 
-	// Possibly enable debugging
-	if *debug {
-		duplocloud.LogLevel = duplocloud.TRACE
+	// Configure timeouts and retries for the Duplo API.
+	internal.ConfigureDuploClients(*timeout, internal.RetryPolicyFromFlags(*retries, *retryMaxTime))
+
+	// Configure proxies and TLS.
+	err := internal.ConfigureTransport(duplocloud.TransportOptions{
+		Proxy:         *proxy,
+		CABundle:      *caBundle,
+		ClientCert:    *clientCert,
+		ClientKey:     *clientKey,
+		MinTLSVersion: *tlsMinVersion,
+	})
+	internal.DieIf(err, "invalid proxy or TLS options")
+
+	// Configure interactive sessions.
+	internal.ConfigureBrowser(*noBrowser, *browser)
+	internal.ConfigureCallback(*allowCallbackWithoutState)
+	internal.ConfigureOtp(*otp, *otpSecretFile)
+
+	// Log out of all hosts, which needs no host.
+	if logoutAll {
+		internal.OutputLogout(internal.LogoutAll(ctx))
+		os.Exit(0)
+	}
+
+	// Query the audit log, which needs no Duplo API.
+	if cmd == "audit" {
+		var hostKey string
+		if *host != "" {
+			hostKey = internal.GetHostCacheKey(*host)
+		}
+		entries, err := internal.ReadAuditLog(time.Now().Add(-*since), hostKey)
+		internal.DieIf(err, "cannot read audit log")
+		internal.OutputAudit(entries, *output)
+		os.Exit(0)
+	}
+
+	// Refuse hosts that the policy does not allow, except to log out of them.
+	if cmd != "logout" {
+		internal.MustAllowHost(*host)
 	}
 
 	// Prepare the cache directory
 	internal.MustInitCache("duplo-jit", *noCache)
 
-	// Get AWS credentials and output them
-	cacheKey := internal.GetHostCacheKey(*host)
+	// Prepare the session, which gets Duplo clients and credentials.  Logins are always interactive.
+	session := internal.MustSession(internal.SessionOptions(*host, *apiHost, *token, *interactive || cmd == "login", "duplo-jit", *port))
 
+	// Get AWS credentials and output them
 	switch cmd {
 	case "aws":
-		var creds *internal.AwsConfigOutput
-		if *admin {
-
-			// Build the cache key
-			cacheKey = strings.Join([]string{cacheKey, "admin"}, ",")
-
-			// Try to find credentials from the cache.
-			creds = internal.CacheGetAwsConfigOutput(cacheKey)
-
-			// Otherwise, get the credentials from Duplo.
-			if creds == nil {
-				client, _ := internal.MustDuploClient(*host, *apiHost, *token, *interactive, true, *port)
-				result, err := client.AdminGetJitAwsCredentials()
-				internal.DieIf(err, "failed to get credentials")
-				creds = internal.ConvertAwsCreds(result)
-			}
-
-		} else if *duploOps {
-
-			// Build the cache key
-			cacheKey = strings.Join([]string{cacheKey, "duplo-ops"}, ",")
-
-			// Try to find credentials from the cache.
-			creds = internal.CacheGetAwsConfigOutput(cacheKey)
-
-			// Otherwise, get the credentials from Duplo.
-			if creds == nil {
-				client, _ := internal.MustDuploClient(*host, *apiHost, *token, *interactive, true, *port)
-				result, err := client.AdminAwsGetJitAccess("duplo-ops")
-				internal.DieIf(err, "failed to get credentials")
-				creds = internal.ConvertAwsCreds(result)
-			}
+		result := mustAwsCreds(ctx, session, *admin, *duploOps, *tenantID)
 
-		} else if tenantID == nil || *tenantID == "" {
-
-			// Tenant credentials require an additional argument.
-			internal.DieIf(errors.New("must specify --admin or --tenant=NAME or --tenant=ID"), "invalid arguments")
-
-		} else {
-
-			// Identify the tenant name to use for the cache key.
-			var tenantName string
-			client, _ := internal.MustDuploClient(*host, *apiHost, *token, *interactive, false, *port)
-			*tenantID, tenantName = getTenantIDAndName(*tenantID, client)
-
-			// Build the cache key.
-			cacheKey = strings.Join([]string{cacheKey, "tenant", tenantName}, ",")
-
-			// Try to find credentials from the cache.
-			creds = internal.CacheGetAwsConfigOutput(cacheKey)
-
-			// Otherwise, get the credentials from Duplo.
-			if creds == nil {
-				// Tenant: Get the JIT AWS credentials
-				result, err := client.TenantGetJitAwsCredentials(*tenantID)
-				internal.DieIf(err, "failed to get credentials")
-				creds = internal.ConvertAwsCreds(result)
-			}
+		// Finally, we can output credentials.
+		internal.OutputAwsCreds(result)
+
+	case "elevate":
+		role := jit.RoleAdmin
+		if *duploOps && !*admin {
+			role = jit.RoleDuploOps
+		} else if !*admin {
+			internal.DieIf(errors.New("must specify --admin or --duplo-ops"), "invalid arguments")
+		}
+		if *window <= 0 {
+			internal.Fatal("--for must be positive", nil)
+		} else if *noCache {
+			internal.Fatal("--no-cache cannot be used with elevate, which holds credentials in the cache", nil)
 		}
 
-		// Finally, we can output credentials.
-		internal.OutputAwsCreds(creds, cacheKey)
+		result, err := session.ElevateAws(internal.MustAuthorize(ctx, session, role, ""), role, *window)
+		internal.DieIf(err, "failed to elevate")
+
+		// Hold the credentials until the end of the window.
+		internal.HoldElevation(ctx, session, result)
 
 	case "duplo":
-		_, creds := internal.MustDuploClient(*host, *apiHost, *token, *interactive, true, *port)
-		internal.OutputDuploCreds(creds)
+		_, creds := internal.MustDuploClient(ctx, session, true)
+		internal.OutputDuploCreds(creds, internal.GetHostCacheKey(*host), true)
 
 	case "k8s":
-		var creds *clientauthv1beta1.ExecCredential
+		var result *jit.K8sResult
 		if planID != nil && *planID != "" {
-
-			// Build the cache key
-			cacheKey = strings.Join([]string{cacheKey, "plan", *planID}, ",")
-
-			// Try to find credentials from the cache.
-			creds = internal.CacheGetK8sConfigOutput(cacheKey, "")
-
-			// Otherwise, get the credentials from Duplo.
-			if creds == nil {
-				client, _ := internal.MustDuploClient(*host, *apiHost, *token, *interactive, true, *port)
-				result, err := client.AdminGetK8sJitAccess(*planID)
-				internal.DieIf(err, "failed to get credentials")
-				creds = internal.ConvertK8sCreds(result)
-			}
-
+			ctx = internal.MustAuthorize(ctx, session, internal.RolePlan, *planID)
+			result, err = session.GetPlanK8sCredentials(ctx, *planID)
 		} else if tenantID == nil || *tenantID == "" {
 
 			// Tenant credentials require an additional argument.
 			internal.DieIf(errors.New("must specify --plan=ID or --tenant=NAME or --tenant=ID"), "invalid arguments")
 
 		} else {
+			ctx = internal.MustAuthorize(ctx, session, jit.RoleTenant, *tenantID)
+			result, err = session.GetTenantK8sCredentials(ctx, *tenantID)
+		}
+		internal.DieIf(err, "failed to get credentials")
 
-			// Identify the tenant name to use for the cache key.
-			var tenantName string
-			client, _ := internal.MustDuploClient(*host, *apiHost, *token, *interactive, false, *port)
-			*tenantID, tenantName = getTenantIDAndName(*tenantID, client)
-
-			// Build the cache key.
-			cacheKey = strings.Join([]string{cacheKey, "tenant", tenantName}, ",")
+		// Finally, we can output credentials.
+		internal.OutputK8sCreds(result)
+
+	case "plans":
+		client, _ := internal.MustDuploClient(ctx, session, true)
+		result, err := client.ListPlansContext(ctx)
+		internal.DieIf(err, "failed to list plans")
+		internal.OutputPlans(internal.ConvertPlans(result), *output, *host)
+
+	case "login":
+		var creds *internal.DuploCredsOutput
+		if *renew {
+			if *token != "" {
+				internal.Fatal("--renew cannot be used with --token", nil)
+			}
+			if *admin || internal.CacheGetDuploTokenUnchecked(*host, true) != "" {
+				internal.MustAuthorize(ctx, session, jit.RoleAdmin, "") // the new token keeps the admin scope
+			}
+			creds, err = session.Renew(ctx, *admin)
+			internal.DieIf(err, "cannot get Duplo credentials")
+		} else {
+			_, creds = internal.MustDuploClient(ctx, session, *admin)
+		}
+		internal.OutputLogin(*host, creds)
 
-			// Try to find credentials from the cache.
-			creds = internal.CacheGetK8sConfigOutput(cacheKey, tenantName)
+	case "logout":
+		internal.OutputLogout([]*internal.LogoutResult{internal.Logout(ctx, *host, *apiHost, *token)})
 
-			// Otherwise, get the credentials from Duplo.
-			if creds == nil {
-				// Tenant: Get the JIT AWS credentials
-				result, err := client.TenantGetK8sJitAccess(*tenantID)
-				internal.DieIf(err, "failed to get credentials")
-				creds = internal.ConvertK8sCreds(result)
-			}
+	case "whoami":
+		client, _ := internal.MustDuploClient(ctx, session, *admin || *duploOps)
+		out := internal.MustWhoami(ctx, client, *host)
 
+		// Possibly identify the AWS principal, caching the credentials for later use.
+		if *admin || *duploOps || *tenantID != "" {
+			result := mustAwsCreds(ctx, session, *admin, *duploOps, *tenantID)
+			out.Aws = internal.MustWhoamiAws(ctx, result.Credentials, result.Role, result.TenantName, result.FromCache)
 		}
 
-		// Finally, we can output credentials.
-		internal.OutputK8sCreds(creds, cacheKey)
+		internal.OutputWhoami(out, *output)
 
 	}
 }
 
-func getTenantIDAndName(tenantIDorName string, client *duplocloud.Client) (string, string) {
-	var tenantID string
-	var tenantName string
-
-	// If it doesn't look like a UUID, assume it is a name and get the tenant ID using its name.
-	if len(tenantIDorName) < 32 {
-		var err error
-		tenantName = tenantIDorName
-		tenant, err := client.GetTenantByNameForUser(tenantName)
-		if tenant == nil || err != nil {
-			internal.Fatal(fmt.Sprintf("tenant '%s' missing or not allowed", tenantName), err)
-		} else {
-			tenantID = tenant.TenantID
-		}
-	} else {
-		// It looks like a UUID, assume it is one and get the tenant name using its ID.
-		var err error
-		tenantID = tenantIDorName
-		tenant, err := client.GetTenantForUser(tenantIDorName)
-		if tenant == nil || err != nil {
-			internal.Fatal(fmt.Sprintf("tenant '%s' missing or not allowed", tenantID), err)
-		} else {
-			tenantName = tenant.AccountName
-		}
+// mustAwsCreds gets admin, duplo-ops or tenant AWS credentials, from the cache or from Duplo.
+func mustAwsCreds(ctx context.Context, session *jit.Session, admin, duploOps bool, tenantID string) *jit.AwsResult {
+	role := jit.RoleTenant
+	if admin {
+		role = jit.RoleAdmin
+	} else if duploOps {
+		role = jit.RoleDuploOps
+	} else if tenantID == "" {
+
+		// Tenant credentials require an additional argument.
+		internal.DieIf(errors.New("must specify --admin or --tenant=NAME or --tenant=ID"), "invalid arguments")
 	}
 
-	return tenantID, tenantName
+	ctx = internal.MustAuthorize(ctx, session, role, tenantID)
+	result, err := session.GetAwsCredentials(ctx, role, tenantID)
+	internal.DieIf(err, "failed to get credentials")
+	return result
 }
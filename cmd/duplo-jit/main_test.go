@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/duplocloud/duplo-jit/duplocloud"
+	"github.com/duplocloud/duplo-jit/duplocloud/duplotest"
+	"github.com/duplocloud/duplo-jit/internal"
+	clientauthv1beta1 "k8s.io/client-go/pkg/apis/clientauthentication/v1beta1"
+)
+
+// runMainEnv makes the test binary run duplo-jit, so that tests can run it as a command.
+const runMainEnv = "DUPLO_JIT_TEST_RUN_MAIN"
+
+func TestMain(m *testing.M) {
+	duplotest.RunBrowser()
+	if os.Getenv(runMainEnv) == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+var testTenants = []duplocloud.UserTenant{
+	{TenantID: "11111111-1111-1111-1111-111111111111", AccountName: "dev", PlanID: "nonprod"},
+	{TenantID: "22222222-2222-2222-2222-222222222222", AccountName: "prod", PlanID: "prod"},
+}
+
+// testPortal starts a fake portal, and returns it with the arguments that select it.
+func testPortal(t *testing.T, config duplotest.Config) (*duplotest.Server, []string) {
+	t.Helper()
+	s := duplotest.NewTLSServer(config)
+	t.Cleanup(s.Close)
+	return s, []string{"--host", s.URL, "--ca-bundle", s.WriteCABundle(t), "--retries", "1"}
+}
+
+// runDuploJit runs duplo-jit with the given home directory, returning its output and its error.
+func runDuploJit(t *testing.T, home string, args ...string) (string, string, error) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+
+	cmd.Env = []string{runMainEnv + "=1", "HOME=" + home, "PATH=" + os.Getenv("PATH"),
+		"XDG_CACHE_HOME=" + filepath.Join(home, ".cache"), "XDG_CONFIG_HOME=" + filepath.Join(home, ".config")}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	return stdout.String(), stderr.String(), err
+}
+
+func mustRunDuploJit(t *testing.T, home string, args ...string) string {
+	t.Helper()
+	stdout, stderr, err := runDuploJit(t, home, args...)
+	if err != nil {
+		t.Fatalf("duplo-jit %s: %v\n%s", strings.Join(args, " "), err, stderr)
+	}
+	return stdout
+}
+
+func TestAws_TenantByName(t *testing.T) {
+	s, args := testPortal(t, duplotest.Config{Tenants: testTenants})
+	home := t.TempDir()
+
+	stdout := mustRunDuploJit(t, home, append([]string{"aws", "--token", duplotest.DefaultToken, "--tenant", "DUPLOSERVICES-DEV"}, args...)...)
+	creds := internal.AwsConfigOutput{}
+	if err := json.Unmarshal([]byte(stdout), &creds); err != nil {
+		t.Fatalf("invalid output %q: %v", stdout, err)
+	}
+	if creds.Version != 1 || creds.AccessKeyId != "ASIADUPLOTESTDEV" || creds.Expiration == "" {
+		t.Errorf("unexpected credentials: %+v", creds)
+	}
+	if got := s.Calls("subscriptions/" + testTenants[0].TenantID + "/GetAwsConsoleTokenUrl"); got != 1 {
+		t.Errorf("expected 1 JIT call, got %d", got)
+	}
+
+	// The credentials are in the audit log, without secrets.
+	stdout = mustRunDuploJit(t, home, "audit", "--output", "json")
+	if strings.Contains(stdout, creds.SecretAccessKey) || strings.Contains(stdout, creds.SessionToken) {
+		t.Errorf("audit log contains secrets: %s", stdout)
+	}
+	var entries []internal.AuditEntry
+	if err := json.Unmarshal([]byte(stdout), &entries); err != nil || len(entries) != 1 {
+		t.Fatalf("unexpected audit log %q: %v", stdout, err)
+	}
+	if entry := entries[0]; entry.Kind != "aws" || entry.Role != "tenant" || entry.Tenant != "dev" || entry.Source != "api" {
+		t.Errorf("unexpected audit entry: %+v", entry)
+	}
+}
+
+func TestAws_TenantNotFound(t *testing.T) {
+	_, args := testPortal(t, duplotest.Config{Tenants: testTenants})
+
+	_, stderr, err := runDuploJit(t, t.TempDir(), append([]string{"aws", "--token", duplotest.DefaultToken, "--tenant", "dve"}, args...)...)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if !strings.Contains(stderr, "did you mean 'dev'?") {
+		t.Errorf("expected a suggestion, got %s", stderr)
+	}
+}
+
+func TestAws_AdminForbidden(t *testing.T) {
+	_, args := testPortal(t, duplotest.Config{Tenants: testTenants})
+
+	_, stderr, err := runDuploJit(t, t.TempDir(), append([]string{"aws", "--token", duplotest.DefaultToken, "--admin"}, args...)...)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if !strings.Contains(stderr, "failed to get credentials") || strings.Contains(stderr, "level=ERROR") {
+		t.Errorf("unexpected error: %s", stderr)
+	}
+}
+
+func TestAws_AdminRetriesTransientErrors(t *testing.T) {
+	s, args := testPortal(t, duplotest.Config{Admin: true})
+	s.Fail("v3/admin/aws/jitAccess/admin", http.StatusServiceUnavailable, 1)
+
+	stdout := mustRunDuploJit(t, t.TempDir(), append([]string{"aws", "--token", duplotest.DefaultToken, "--admin"}, args...)...)
+	if !strings.Contains(stdout, "ASIADUPLOTESTADMIN") {
+		t.Errorf("unexpected output: %s", stdout)
+	}
+	if got := s.Calls("v3/admin/aws/jitAccess/admin"); got != 2 {
+		t.Errorf("expected 2 calls, got %d", got)
+	}
+}
+
+func TestK8s_Plan(t *testing.T) {
+	_, args := testPortal(t, duplotest.Config{Admin: true, Tenants: testTenants})
+
+	stdout := mustRunDuploJit(t, t.TempDir(), append([]string{"k8s", "--token", duplotest.DefaultToken, "--plan", "nonprod"}, args...)...)
+	creds := clientauthv1beta1.ExecCredential{}
+	if err := json.Unmarshal([]byte(stdout), &creds); err != nil {
+		t.Fatalf("invalid output %q: %v", stdout, err)
+	}
+	if creds.Status == nil || creds.Status.Token != "duplotest-k8s-nonprod" || creds.Status.ExpirationTimestamp == nil {
+		t.Errorf("unexpected credentials: %+v", creds.Status)
+	}
+}
+
+func TestDuplo_InteractiveWithOTP(t *testing.T) {
+	s, args := testPortal(t, duplotest.Config{Admin: true, OTP: "123456", Tenants: testTenants})
+	home := t.TempDir()
+	args = append([]string{"duplo", "--interactive", "--browser", s.BrowserCommand(t), "--otp", "123456"}, args...)
+
+	// The first run logs in, and the second uses the cached token, with the OTP code of --otp.
+	for i := 0; i < 2; i++ {
+		stdout := mustRunDuploJit(t, home, args...)
+		creds := internal.DuploCredsOutput{}
+		if err := json.Unmarshal([]byte(stdout), &creds); err != nil {
+			t.Fatalf("invalid output %q: %v", stdout, err)
+		}
+		if creds.DuploToken != duplotest.DefaultToken || !creds.Admin {
+			t.Errorf("unexpected credentials: %+v", creds)
+		}
+	}
+	if s.Logins() != 1 {
+		t.Errorf("expected 1 login, got %d", s.Logins())
+	}
+}
+
+func TestDuplo_TokenAudit(t *testing.T) {
+	_, args := testPortal(t, duplotest.Config{Admin: true, Tenants: testTenants})
+	home := t.TempDir()
+
+	// A Duplo token passed with --token is recorded as an admin token, as it was used for admin requests.
+	stdout := mustRunDuploJit(t, home, append([]string{"duplo", "--token", duplotest.DefaultToken}, args...)...)
+	creds := internal.DuploCredsOutput{}
+	if err := json.Unmarshal([]byte(stdout), &creds); err != nil || !creds.Admin {
+		t.Errorf("unexpected credentials %q: %v", stdout, err)
+	}
+
+	stdout = mustRunDuploJit(t, home, "audit", "--output", "json")
+	var entries []internal.AuditEntry
+	if err := json.Unmarshal([]byte(stdout), &entries); err != nil || len(entries) != 1 {
+		t.Fatalf("unexpected audit log %q: %v", stdout, err)
+	}
+	if entry := entries[0]; entry.Kind != "duplo" || entry.Role != "admin" {
+		t.Errorf("unexpected audit entry: %+v", entry)
+	}
+}
+
+func TestWhoami(t *testing.T) {
+	_, args := testPortal(t, duplotest.Config{Admin: true, AwsAdminJITEnabled: true})
+
+	stdout := mustRunDuploJit(t, t.TempDir(), append([]string{"whoami", "--token", duplotest.DefaultToken, "--output", "json"}, args...)...)
+	out := internal.WhoamiOutput{}
+	if err := json.Unmarshal([]byte(stdout), &out); err != nil {
+		t.Fatalf("invalid output %q: %v", stdout, err)
+	}
+	if out.Username != duplotest.DefaultUsername || !out.IsAdmin || !out.IsAwsAdminJitEnabled {
+		t.Errorf("unexpected output: %+v", out)
+	}
+}
+
+// writeTestConfig writes the configuration file of the given home directory.
+func writeTestConfig(t *testing.T, home string, config string) {
+	t.Helper()
+	dir := filepath.Join(home, ".config", "duplo-jit")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPolicy_AllowedHosts(t *testing.T) {
+	s, args := testPortal(t, duplotest.Config{Tenants: testTenants})
+	home := t.TempDir()
+	writeTestConfig(t, home, `{"Policy": {"AllowedHosts": ["*.duplocloud.net"]}}`)
+
+	_, stderr, err := runDuploJit(t, home, append([]string{"aws", "--token", duplotest.DefaultToken, "--tenant", "dev"}, args...)...)
+	if err == nil || !strings.Contains(stderr, "host not allowed") {
+		t.Errorf("expected the host to be refused, got %v: %s", err, stderr)
+	}
+	if s.TotalCalls() != 0 {
+		t.Errorf("expected no calls, got %d", s.TotalCalls())
+	}
+}
+
+func TestPolicy_ForbidAdmin(t *testing.T) {
+	s, args := testPortal(t, duplotest.Config{Admin: true, Tenants: testTenants})
+	home := t.TempDir()
+	writeTestConfig(t, home, `{"Policy": {"Hosts": {"127.0.0.1": {"ForbidAdmin": true}}}}`)
+
+	for _, creds := range [][]string{{"aws", "--admin"}, {"aws", "--duplo-ops"}, {"k8s", "--plan", "nonprod"}} {
+		_, stderr, err := runDuploJit(t, home, append(append(creds, "--token", duplotest.DefaultToken, "--yes"), args...)...)
+		if err == nil || !strings.Contains(stderr, "the policy forbids") {
+			t.Errorf("%v: expected credentials to be forbidden, got %v: %s", creds, err, stderr)
+		}
+	}
+	if got := s.Calls("v3/admin/aws/jitAccess/admin") + s.Calls("v3/admin/aws/jitAccess/duplo-ops") + s.Calls("v3/admin/plans/nonprod/k8sConfig"); got != 0 {
+		t.Errorf("expected no admin JIT calls, got %d", got)
+	}
+
+	// So are admin Duplo tokens.
+	for _, cmd := range [][]string{{"duplo"}, {"plans"}, {"login", "--admin"}, {"whoami", "--admin"}} {
+		stdout, stderr, err := runDuploJit(t, home, append(append(cmd, "--token", duplotest.DefaultToken, "--yes"), args...)...)
+		if err == nil || stdout != "" || !strings.Contains(stderr, "the policy forbids") {
+			t.Errorf("%v: expected an admin token to be forbidden, got %v: %s", cmd, err, stderr)
+		}
+	}
+
+	// Tenant credentials are still allowed.
+	mustRunDuploJit(t, home, append([]string{"aws", "--token", duplotest.DefaultToken, "--tenant", "dev"}, args...)...)
+	mustRunDuploJit(t, home, append([]string{"login", "--token", duplotest.DefaultToken}, args...)...)
+}
+
+func TestPolicy_Production(t *testing.T) {
+	s, args := testPortal(t, duplotest.Config{Admin: true, Tenants: testTenants})
+	home := t.TempDir()
+	writeTestConfig(t, home, `{"Policy": {"Hosts": {"127.0.0.1": {"Production": true, "ProductionTenants": ["prod"]}}}}`)
+
+	// Without a terminal, production credentials require --yes.
+	for _, creds := range [][]string{{"aws", "--admin"}, {"k8s", "--tenant", testTenants[1].TenantID}} {
+		_, stderr, err := runDuploJit(t, home, append(append(creds, "--token", duplotest.DefaultToken), args...)...)
+		if err == nil || !strings.Contains(stderr, "confirmation required") || !strings.Contains(stderr, "confirm on a terminal, or pass --yes") {
+			t.Errorf("%v: expected a confirmation to be required, got %v: %s", creds, err, stderr)
+		}
+	}
+	if got := s.Calls("v3/admin/aws/jitAccess/admin") + s.Calls("v3/subscriptions/"+testTenants[1].TenantID+"/k8s/jitAccess"); got != 0 {
+		t.Errorf("expected no JIT calls, got %d", got)
+	}
+
+	stdout := mustRunDuploJit(t, home, append([]string{"aws", "--admin", "--yes", "--token", duplotest.DefaultToken}, args...)...)
+	if !strings.Contains(stdout, "ASIADUPLOTESTADMIN") {
+		t.Errorf("unexpected output: %s", stdout)
+	}
+
+	// Other tenants need no confirmation.
+	mustRunDuploJit(t, home, append([]string{"k8s", "--tenant", "dev", "--token", duplotest.DefaultToken}, args...)...)
+}
+
+func TestPolicy_RequireReason(t *testing.T) {
+	s, args := testPortal(t, duplotest.Config{Admin: true, Tenants: testTenants})
+	home := t.TempDir()
+	writeTestConfig(t, home, `{"Policy": {"Hosts": {"127.0.0.1": {"RequireReason": true}}}}`)
+	args = append([]string{"--token", duplotest.DefaultToken}, args...)
+
+	// Without a terminal, the reason must be passed.
+	_, stderr, err := runDuploJit(t, home, append([]string{"aws", "--admin"}, args...)...)
+	if err == nil || !strings.Contains(stderr, "pass --reason") {
+		t.Errorf("expected a reason to be required, got %v: %s", err, stderr)
+	}
+	if got := s.Calls("v3/admin/aws/jitAccess/admin"); got != 0 {
+		t.Errorf("expected no JIT calls, got %d", got)
+	}
+
+	// The reason is sent to the portal.  The fake credentials never validate, so none come from the cache.
+	mustRunDuploJit(t, home, append([]string{"aws", "--admin", "--reason", "INC-1234: first"}, args...)...)
+	mustRunDuploJit(t, home, append([]string{"k8s", "--plan", "nonprod", "--reason", "INC-5678: second"}, args...)...)
+	if reasons := s.Reasons("v3/admin/aws/jitAccess/admin"); len(reasons) != 1 || reasons[0] != "INC-1234: first" {
+		t.Errorf("unexpected reasons: %q", reasons)
+	}
+	if reasons := s.Reasons("v3/admin/plans/nonprod/k8sConfig"); len(reasons) != 1 || reasons[0] != "INC-5678: second" {
+		t.Errorf("unexpected reasons: %q", reasons)
+	}
+
+	// Tenant credentials need no reason.
+	mustRunDuploJit(t, home, append([]string{"aws", "--tenant", "dev"}, args...)...)
+
+	// The audit log has the reasons.
+	var entries []internal.AuditEntry
+	if err := json.Unmarshal([]byte(mustRunDuploJit(t, home, "audit", "--output", "json")), &entries); err != nil || len(entries) != 3 {
+		t.Fatalf("unexpected audit log: %v, %v", entries, err)
+	}
+	for i, want := range []string{"INC-1234: first", "INC-5678: second", ""} {
+		if entries[i].Reason != want {
+			t.Errorf("entry %d: got reason %q, want %q", i, entries[i].Reason, want)
+		}
+	}
+}
+
+func TestElevate(t *testing.T) {
+	s, args := testPortal(t, duplotest.Config{Admin: true, Tenants: testTenants})
+	home := t.TempDir()
+	args = append([]string{"--token", duplotest.DefaultToken}, args...)
+
+	// The elevation holds admin credentials for its window, then removes them from the cache.
+	_, stderr, err := runDuploJit(t, home, append([]string{"elevate", "--admin", "--for", "1s", "--reason", "INC-1234: hotfix"}, args...)...)
+	if err != nil || !strings.Contains(stderr, "Elevation ended") {
+		t.Fatalf("duplo-jit elevate: %v\n%s", err, stderr)
+	}
+	if reasons := s.Reasons("v3/admin/aws/jitAccess/admin"); len(reasons) != 1 || reasons[0] != "INC-1234: hotfix" {
+		t.Errorf("unexpected reasons: %q", reasons)
+	}
+	if _, err := os.Stat(filepath.Join(home, ".cache", "duplo-jit", "127.0.0.1,admin,aws-creds.json")); !os.IsNotExist(err) {
+		t.Errorf("expected the elevated credentials to be removed, got %v", err)
+	}
+
+	var entries []internal.AuditEntry
+	if err := json.Unmarshal([]byte(mustRunDuploJit(t, home, "audit", "--output", "json")), &entries); err != nil || len(entries) != 1 || entries[0].Kind != "elevate" {
+		t.Errorf("unexpected audit log: %v, %v", entries, err)
+	}
+
+	// Tenant credentials cannot be elevated.
+	if _, stderr, err := runDuploJit(t, home, append([]string{"elevate", "--tenant", "dev"}, args...)...); err == nil {
+		t.Errorf("expected an error for tenant credentials: %s", stderr)
+	}
+}
+
+func TestRetention_InvalidConfig(t *testing.T) {
+	_, args := testPortal(t, duplotest.Config{Admin: true, Tenants: testTenants})
+	home := t.TempDir()
+	writeTestConfig(t, home, `{"Retention": {"admins": {"MaxAge": "15m"}}}`)
+
+	_, stderr, err := runDuploJit(t, home, append([]string{"aws", "--admin", "--token", duplotest.DefaultToken}, args...)...)
+	if err == nil || !strings.Contains(stderr, "unknown class of credentials") {
+		t.Errorf("expected an invalid configuration, got %v: %s", err, stderr)
+	}
+}
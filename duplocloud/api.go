@@ -1,6 +1,7 @@
 package duplocloud
 
 import (
+	"context"
 	"fmt"
 	"time"
 )
@@ -49,6 +50,12 @@ type DuploPlanK8ClusterConfig struct {
 	LastTokenRefreshTime           *time.Time `json:"LastTokenRefreshTime,omitempty"`
 }
 
+// DuploPlan represents a Duplo infrastructure plan.
+type DuploPlan struct {
+	Name             string                    `json:"Name"`
+	KubernetesConfig *DuploPlanK8ClusterConfig `json:"KubernetesConfig,omitempty"`
+}
+
 // AwsJitCredentials represents just-in-time AWS credentials from Duplo
 type AwsJitCredentials struct {
 	ConsoleURL      string `json:"ConsoleUrl,omitempty"`
@@ -66,20 +73,63 @@ type UserTenant struct {
 	PlanID      string `json:"PlanID"`
 }
 
+// DuploUserProfile represents the Duplo user behind an API token.
+type DuploUserProfile struct {
+	Username string   `json:"Username"`
+	Roles    []string `json:"Roles,omitempty"`
+}
+
+// IsAdmin returns true if the user has the Duplo administrator role.
+func (p *DuploUserProfile) IsAdmin() bool {
+	for _, role := range p.Roles {
+		if role == "Administrator" {
+			return true
+		}
+	}
+	return false
+}
+
 // FeaturesSystem retrieves the configured system features.
 func (c *Client) FeaturesSystem() (*DuploSystemFeatures, ClientError) {
+	return c.FeaturesSystemContext(context.Background())
+}
+
+// FeaturesSystemContext retrieves the configured system features, using the given context.
+func (c *Client) FeaturesSystemContext(ctx context.Context) (*DuploSystemFeatures, ClientError) {
 	features := DuploSystemFeatures{}
-	err := c.getAPI("FeaturesSystem()", "v3/features/system", &features)
+	err := c.getAPI(ctx, "FeaturesSystem()", "v3/features/system", &features)
 	if err != nil {
 		return nil, err
 	}
 	return &features, nil
 }
 
+// JitReasonHeader is the request header of JIT API calls that holds the reason for the credentials.
+const JitReasonHeader = "X-Duplo-Jit-Reason"
+
+type jitReasonKey struct{}
+
+// WithJitReason returns a context whose JIT API calls send the reason for the credentials to the portal,
+// such as "INC-1234: restore the orders database".
+func WithJitReason(ctx context.Context, reason string) context.Context {
+	return context.WithValue(ctx, jitReasonKey{}, reason)
+}
+
+// JitReason returns the reason sent by the JIT API calls of the context, if any.
+func JitReason(ctx context.Context) string {
+	reason, _ := ctx.Value(jitReasonKey{}).(string)
+	return reason
+}
+
 // AdminAwsGetJitAccess retrieves just-in-time admin AWS credentials for the requested role via the Duplo API.
 func (c *Client) AdminAwsGetJitAccess(role string) (*AwsJitCredentials, ClientError) {
+	return c.AdminAwsGetJitAccessContext(context.Background(), role)
+}
+
+// AdminAwsGetJitAccessContext retrieves just-in-time admin AWS credentials for the requested role, using the given context.
+func (c *Client) AdminAwsGetJitAccessContext(ctx context.Context, role string) (*AwsJitCredentials, ClientError) {
 	creds := AwsJitCredentials{}
-	err := c.getAPI("AdminAwsGetJitAccess()", fmt.Sprintf("v3/admin/aws/jitAccess/%s", role), &creds)
+	err := c.getJitAPI(ctx, "AdminAwsGetJitAccess()", fmt.Sprintf("v3/admin/aws/jitAccess/%s", role), &creds)
 	if err != nil {
 		return nil, err
 	}
@@ -88,8 +138,14 @@ func (c *Client) AdminAwsGetJitAccess(role string) (*AwsJitCredentials, ClientEr
 
 // AdminGetK8sJitAccess retrieves just-in-time admin AWS credentials for the requested role via the Duplo API.
 func (c *Client) AdminGetK8sJitAccess(plan string) (*DuploPlanK8ClusterConfig, ClientError) {
+	return c.AdminGetK8sJitAccessContext(context.Background(), plan)
+}
+
+// AdminGetK8sJitAccessContext retrieves just-in-time admin K8s credentials for the requested plan, using the given context.
+func (c *Client) AdminGetK8sJitAccessContext(ctx context.Context, plan string) (*DuploPlanK8ClusterConfig, ClientError) {
 	creds := DuploPlanK8ClusterConfig{}
-	err := c.getAPI(
+	err := c.getJitAPI(
+		ctx,
 		fmt.Sprintf("AdminGetK8sJitAccess(%s)", plan),
 		fmt.Sprintf("v3/admin/plans/%s/k8sConfig", plan),
 		&creds,
@@ -102,13 +158,24 @@ func (c *Client) AdminGetK8sJitAccess(plan string) (*DuploPlanK8ClusterConfig, C
 
 // AdminGetJitAwsCredentials retrieves just-in-time admin AWS credentials via the Duplo API.
 func (c *Client) AdminGetJitAwsCredentials() (*AwsJitCredentials, ClientError) {
-	return c.AdminAwsGetJitAccess("admin")
+	return c.AdminGetJitAwsCredentialsContext(context.Background())
+}
+
+// AdminGetJitAwsCredentialsContext retrieves just-in-time admin AWS credentials, using the given context.
+func (c *Client) AdminGetJitAwsCredentialsContext(ctx context.Context) (*AwsJitCredentials, ClientError) {
+	return c.AdminAwsGetJitAccessContext(ctx, "admin")
 }
 
 // TenantGetJitAwsCredentials retrieves just-in-time AWS credentials for a tenant via the Duplo API.
 func (c *Client) TenantGetJitAwsCredentials(tenantID string) (*AwsJitCredentials, ClientError) {
+	return c.TenantGetJitAwsCredentialsContext(context.Background(), tenantID)
+}
+
+// TenantGetJitAwsCredentialsContext retrieves just-in-time AWS credentials for a tenant, using the given context.
+func (c *Client) TenantGetJitAwsCredentialsContext(ctx context.Context, tenantID string) (*AwsJitCredentials, ClientError) {
 	creds := AwsJitCredentials{}
-	err := c.getAPI(
+	err := c.getJitAPI(
+		ctx,
 		fmt.Sprintf("TenantGetJitAwsCredentials(%s)", tenantID),
 		fmt.Sprintf("subscriptions/%s/GetAwsConsoleTokenUrl", tenantID),
 		&creds,
@@ -121,8 +188,14 @@ func (c *Client) TenantGetJitAwsCredentials(tenantID string) (*AwsJitCredentials
 
 // TenantGetK8sJitAccess retrieves just-in-time admin AWS credentials for the requested role via the Duplo API.
 func (c *Client) TenantGetK8sJitAccess(tenantID string) (*DuploPlanK8ClusterConfig, ClientError) {
+	return c.TenantGetK8sJitAccessContext(context.Background(), tenantID)
+}
+
+// TenantGetK8sJitAccessContext retrieves just-in-time K8s credentials for a tenant, using the given context.
+func (c *Client) TenantGetK8sJitAccessContext(ctx context.Context, tenantID string) (*DuploPlanK8ClusterConfig, ClientError) {
 	creds := DuploPlanK8ClusterConfig{}
-	err := c.getAPI(
+	err := c.getJitAPI(
+		ctx,
 		fmt.Sprintf("TenantGetK8sJitAccess(%s)", tenantID),
 		fmt.Sprintf("v3/subscriptions/%s/k8s/jitAccess", tenantID),
 		&creds,
@@ -133,10 +206,68 @@ func (c *Client) TenantGetK8sJitAccess(tenantID string) (*DuploPlanK8ClusterConf
 	return &creds, nil
 }
 
+// GetUserProfile retrieves the Duplo user behind the API token.
+func (c *Client) GetUserProfile() (*DuploUserProfile, ClientError) {
+	return c.GetUserProfileContext(context.Background())
+}
+
+// GetUserProfileContext retrieves the Duplo user behind the API token, using the given context.
+func (c *Client) GetUserProfileContext(ctx context.Context) (*DuploUserProfile, ClientError) {
+	rp := DuploUserProfile{}
+	err := c.getAPI(
+		ctx,
+		"GetUserProfile()",
+		"admin/GetUserProfile",
+		&rp,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &rp, nil
+}
+
+// Logout invalidates the API token, on portals that support it.
+func (c *Client) Logout() ClientError {
+	return c.LogoutContext(context.Background())
+}
+
+// LogoutContext invalidates the API token, on portals that support it, using the given context.
+func (c *Client) LogoutContext(ctx context.Context) ClientError {
+	var rp interface{}
+	return c.doAPIWithRequestBody(
+		ctx,
+		"POST",
+		"Logout()",
+		"admin/Logout",
+		nil,
+		&rp,
+	)
+}
+
 // ListTenantsForUser retrieves a list of tenants for the current user via the Duplo API.
 func (c *Client) ListTenantsForUser() (*[]UserTenant, ClientError) {
+	return c.ListTenantsForUserContext(context.Background())
+}
+
+// ListTenantsForUserContext retrieves a list of tenants for the current user, using the given context.
+func (c *Client) ListTenantsForUserContext(ctx context.Context) (*[]UserTenant, ClientError) {
 	list := []UserTenant{}
-	err := c.getAPI("GetTenantsForUser()", "admin/GetTenantsForUser", &list)
+	err := c.getAPI(ctx, "GetTenantsForUser()", "admin/GetTenantsForUser", &list)
+	if err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// ListPlans retrieves a list of infrastructure plans via the Duplo API.
+func (c *Client) ListPlans() (*[]DuploPlan, ClientError) {
+	return c.ListPlansContext(context.Background())
+}
+
+// ListPlansContext retrieves a list of infrastructure plans, using the given context.
+func (c *Client) ListPlansContext(ctx context.Context) (*[]DuploPlan, ClientError) {
+	list := []DuploPlan{}
+	err := c.getAPI(ctx, "ListPlans()", "v3/admin/plans", &list)
 	if err != nil {
 		return nil, err
 	}
@@ -145,8 +276,14 @@ func (c *Client) ListTenantsForUser() (*[]UserTenant, ClientError) {
 
 // GetTenantFeatures retrieves a tenant's current configuration via the Duplo API.
 func (c *Client) GetTenantFeatures(tenantID string) (*DuploTenantFeatures, ClientError) {
+	return c.GetTenantFeaturesContext(context.Background(), tenantID)
+}
+
+// GetTenantFeaturesContext retrieves a tenant's current configuration, using the given context.
+func (c *Client) GetTenantFeaturesContext(ctx context.Context, tenantID string) (*DuploTenantFeatures, ClientError) {
 	features := DuploTenantFeatures{}
 	err := c.getAPI(
+		ctx,
 		fmt.Sprintf("GetTenantFeatures(%s)", tenantID),
 		fmt.Sprintf("v3/features/tenant/%s", tenantID),
 		&features,
@@ -160,8 +297,13 @@ func (c *Client) GetTenantFeatures(tenantID string) (*DuploTenantFeatures, Clien
 
 // GetTenantByNameForUser retrieves a single tenant by name for the current user via the Duplo API.
 func (c *Client) GetTenantByNameForUser(name string) (*UserTenant, ClientError) {
+	return c.GetTenantByNameForUserContext(context.Background(), name)
+}
+
+// GetTenantByNameForUserContext retrieves a single tenant by name for the current user, using the given context.
+func (c *Client) GetTenantByNameForUserContext(ctx context.Context, name string) (*UserTenant, ClientError) {
 	// Get all tenants.
-	allTenants, err := c.ListTenantsForUser()
+	allTenants, err := c.ListTenantsForUserContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -179,8 +321,13 @@ func (c *Client) GetTenantByNameForUser(name string) (*UserTenant, ClientError)
 
 // GetTenantForUser retrieves a single tenant by ID for the current user via the Duplo API.
 func (c *Client) GetTenantForUser(tenantID string) (*UserTenant, ClientError) {
+	return c.GetTenantForUserContext(context.Background(), tenantID)
+}
+
+// GetTenantForUserContext retrieves a single tenant by ID for the current user, using the given context.
+func (c *Client) GetTenantForUserContext(ctx context.Context, tenantID string) (*UserTenant, ClientError) {
 	// Get all tenants.
-	allTenants, err := c.ListTenantsForUser()
+	allTenants, err := c.ListTenantsForUserContext(ctx)
 	if err != nil {
 		return nil, err
 	}
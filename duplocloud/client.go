@@ -1,29 +1,40 @@
 package duplocloud
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"strings"
 	"time"
 )
 
+// DefaultTimeout is the timeout of a single API call made by a client created by NewClient.
+const DefaultTimeout = 20 * time.Second
+
 // Client represents a connection to the Duplo API
 type Client struct {
 	HTTPClient *http.Client
 	HostURL    string
 	Token      string
 	OTP        string
+	Retry      RetryPolicy
+	Logger     *slog.Logger // defaults to slog.Default()
 }
 
 // NewClient creates a new Duplo API client
 func NewClient(host, token string) (*Client, error) {
 	if host != "" && token != "" {
 		c := Client{
-			HTTPClient: &http.Client{Timeout: 20 * time.Second},
+			HTTPClient: &http.Client{Timeout: DefaultTimeout},
 			HostURL:    host,
 			Token:      token,
+			Retry:      DefaultRetryPolicy(),
 		}
 		return &c, nil
 	}
@@ -44,6 +55,9 @@ type clientError struct {
 	status   int
 	url      string
 	response map[string]interface{}
+	cause    error
+	otpSent  bool
+	kind     error // ErrUnreachable or ErrInvalidResponse, if the failure is of that kind
 }
 
 func (e clientError) Error() string {
@@ -66,6 +80,42 @@ func (e clientError) Response() map[string]interface{} {
 	return e.response
 }
 
+// Unwrap allows errors.Is and errors.As to match both the sentinel error for
+// the kind of failure, and the underlying cause.
+func (e clientError) Unwrap() []error {
+	var errs []error
+	if e.otpRejected() {
+		errs = append(errs, ErrInvalidOTP)
+	}
+	switch {
+	case e.status == http.StatusUnauthorized:
+		errs = append(errs, ErrNotAuthenticated)
+	case e.status == http.StatusForbidden:
+		errs = append(errs, ErrForbidden)
+	case e.status == http.StatusNotFound:
+		errs = append(errs, ErrNotFound)
+	}
+	if e.kind != nil {
+		errs = append(errs, e.kind)
+	}
+	if e.cause != nil {
+		errs = append(errs, e.cause)
+	}
+	return errs
+}
+
+// otpRejected returns true if an OTP code was sent, and the response suggests it was not accepted.
+func (e clientError) otpRejected() bool {
+	if !e.otpSent {
+		return false
+	}
+	if e.status == http.StatusUnauthorized {
+		return true
+	}
+	message := strings.ToLower(e.message)
+	return e.status == http.StatusForbidden && (strings.Contains(message, "otp") || strings.Contains(message, "mfa"))
+}
+
 // ClientError represents an error from an API call.
 type ClientError interface {
 	Error() string
@@ -75,18 +125,14 @@ type ClientError interface {
 	Response() map[string]interface{}
 }
 
-func newHttpError(req *http.Request, status int, message string) ClientError {
+// A response that is not the one expected from the API.
+func invalidResponseError(url string, message string, cause error) ClientError {
 	response := map[string]interface{}{"Message": message}
-	return clientError{status: status, url: req.URL.String(), message: message, response: response}
-}
-
-// An application logic error encountered in spite of a semantically correct response.
-func appHttpError(req *http.Request, message string) ClientError {
-	return newHttpError(req, -1, message)
+	return clientError{status: -1, url: url, message: message, response: response, cause: cause, kind: ErrInvalidResponse}
 }
 
 // An error encountered in the HTTP response.
-func responseHttpError(req *http.Request, res *http.Response) ClientError {
+func (c *Client) responseHttpError(req *http.Request, res *http.Response) ClientError {
 	status := res.StatusCode
 	url := req.URL.String()
 	response := map[string]interface{}{}
@@ -109,105 +155,241 @@ func responseHttpError(req *http.Request, res *http.Response) ClientError {
 	if mime == "application/json" {
 		err = json.Unmarshal(bytes, &response)
 		if err != nil {
-			logf(ERROR, "duplo-responseHttpError: failed to parse error response JSON: %s, %s", err, string(bytes))
+			c.log().ErrorContext(req.Context(), "duplo-responseHttpError: failed to parse error response JSON", "error", err, "body", redactBody(bytes))
 		}
 	}
 
 	// Build the final error message.
 	message = fmt.Sprintf("url: %s, status: %d, message: %s", url, status, message)
-	logf(WARN, "[WARN] duplo-responseHttpError: %s", message)
+	c.log().DebugContext(req.Context(), "duplo-responseHttpError: API call failed", "url", url, "status", status, "message", message)
 
 	// Handle responses that are missing a message - or a JSON parse failure
 	if _, ok := response["Message"]; !ok {
 		response["Message"] = message
 	}
 
-	return clientError{status: res.StatusCode, url: url, message: message, response: response}
+	return clientError{status: res.StatusCode, url: url, message: message, response: response, otpSent: req.Header.Get("otpcode") != ""}
 }
 
 // An error encountered before we could parse the response.
 func ioHttpError(req *http.Request, err error) ClientError {
-	return newHttpError(req, -1, err.Error())
+	response := map[string]interface{}{"Message": err.Error()}
+	return clientError{status: -1, url: req.URL.String(), message: err.Error(), response: response, cause: err}
+}
+
+// An error encountered sending the request, before any response.  The portal is unreachable, unless the
+// caller gave up first.  A timeout of the HTTP client, or of the retry policy, also counts as unreachable.
+func transportHttpError(req *http.Request, err error) ClientError {
+	e := ioHttpError(req, err).(clientError)
+	var netErr net.Error
+	timeout := errors.As(err, &netErr) && netErr.Timeout()
+	gaveUp := req.Context().Err() != nil || (!timeout && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)))
+	if !gaveUp {
+		e.kind = ErrUnreachable
+	}
+	return e
 }
 
 func (c *Client) doRequestWithStatus(req *http.Request, expectedStatus int) ([]byte, ClientError) {
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.Token))
 	req.Header.Set("Content-Type", "application/json; charset=utf-8")
 
-	res, err := c.HTTPClient.Do(req)
+	ctx := req.Context()
+	c.trace(ctx, "duplo-doRequestWithStatus: sending request", "method", req.Method, "url", req.URL.String(), "headers", redactHeaders(req.Header))
+	start := time.Now()
+
+	// All attempts, and reading the response, share the deadline of the retry policy.
+	orig := req
+	if c.Retry.MaxElapsed > 0 {
+		deadlineCtx, cancel := context.WithTimeout(ctx, c.Retry.MaxElapsed)
+		defer cancel()
+		req = req.WithContext(deadlineCtx)
+	}
+	res, err := c.doWithRetry(req)
 
 	// Handle I/O errors
 	if err != nil {
-		return nil, ioHttpError(req, err)
+		c.trace(ctx, "duplo-doRequestWithStatus: request failed", "method", req.Method, "url", req.URL.String(), "elapsed", time.Since(start), "error", err)
+		return nil, transportHttpError(orig, err)
 	}
+	c.trace(ctx, "duplo-doRequestWithStatus: received response", "method", req.Method, "url", req.URL.String(), "status", res.StatusCode, "elapsed", time.Since(start))
 
 	// Pass through HTTP errors, unexpected redirects, or unexpected status codes.
 	if res.StatusCode > 300 || (expectedStatus > 0 && expectedStatus != res.StatusCode) {
-		return nil, responseHttpError(req, res)
+		return nil, c.responseHttpError(req, res)
 	}
 
 	// Otherwise, we have a response that needs reading.
 	defer func() { _ = res.Body.Close() }()
 	body, err := io.ReadAll(res.Body)
 	if err != nil {
-		logf(WARN, "duplo-doRequestWithStatus: %s", err)
+		c.log().WarnContext(ctx, "duplo-doRequestWithStatus: cannot read response", "url", req.URL.String(), "error", err)
 		return nil, ioHttpError(req, err)
 	}
 
 	return body, nil
 }
 
+// doWithRetry sends the request, retrying transient failures according to the client's retry policy.
+func (c *Client) doWithRetry(req *http.Request) (*http.Response, error) {
+	policy := c.Retry
+	start := time.Now()
+
+	for attempt := 1; ; attempt++ {
+		res, err := c.HTTPClient.Do(req)
+
+		// Stop if we succeeded, or cannot retry.
+		if attempt >= policy.MaxAttempts || !policy.shouldRetry(req, res, err) {
+			return res, err
+		}
+
+		// Wait for the longer of the backoff delay or the server's requested delay.
+		now := time.Now()
+		delay := policy.backoff(attempt)
+		if after := retryAfter(res, now); after > delay {
+			delay = after
+		}
+
+		// Stop if waiting would exceed the deadline.
+		if policy.MaxElapsed > 0 && now.Add(delay).Sub(start) > policy.MaxElapsed {
+			return res, err
+		}
+
+		// Discard the failed response.
+		reason := ""
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = res.Status
+			_, _ = io.Copy(io.Discard, res.Body)
+			_ = res.Body.Close()
+		}
+		c.log().WarnContext(req.Context(), "duplo-doWithRetry: retrying after transient failure",
+			"method", req.Method, "url", req.URL.String(), "reason", reason, "delay", delay.Truncate(time.Millisecond),
+			"attempt", attempt+1, "maxAttempts", policy.MaxAttempts)
+
+		// Wait, unless the caller gives up first.
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
 func (c *Client) doRequest(req *http.Request) ([]byte, ClientError) {
 	return c.doRequestWithStatus(req, 0)
 }
 
 // Utility method to call an API without a request body, handling logging, etc.
-func (c *Client) doAPI(verb string, apiName string, apiPath string, rp interface{}) ClientError {
+func (c *Client) doAPI(ctx context.Context, verb string, apiName string, apiPath string, rp interface{}) ClientError {
+	return c.doAPIWithRequestBody(ctx, verb, apiName, apiPath, nil, rp)
+}
+
+// Utility method to call an API with a JSON request body, handling logging, etc.
+func (c *Client) doAPIWithRequestBody(ctx context.Context, verb string, apiName string, apiPath string, rq interface{}, rp interface{}) ClientError {
+	return c.doAPIWithHeaders(ctx, verb, apiName, apiPath, nil, rq, rp)
+}
+
+// Utility method to call an API with additional request headers and a JSON request body, handling logging, etc.
+func (c *Client) doAPIWithHeaders(ctx context.Context, verb string, apiName string, apiPath string, header http.Header, rq interface{}, rp interface{}) ClientError {
 	apiName = fmt.Sprintf("%sAPI %s", strings.ToLower(verb), apiName)
+	url := fmt.Sprintf("%s/%s", c.HostURL, apiPath)
+
+	// Build the request body
+	var body io.Reader
+	if rq != nil {
+		rqBody, err := json.Marshal(rq)
+		if err != nil {
+			message := fmt.Sprintf("%s: cannot marshal request to JSON: %s", apiName, err.Error())
+			c.trace(ctx, message)
+			return clientError{status: -1, url: url, message: message, response: map[string]interface{}{"Message": message}, cause: err}
+		}
+		c.trace(ctx, apiName+": request body", "body", redactBody(rqBody))
+		body = bytes.NewReader(rqBody)
+	}
 
 	// Build the request
-	url := fmt.Sprintf("%s/%s", c.HostURL, apiPath)
-	logf(TRACE, "%s: prepared request: %s", apiName, url)
-	req, err := http.NewRequest(verb, url, nil)
+	c.trace(ctx, apiName+": prepared request", "url", url)
+	req, err := http.NewRequestWithContext(ctx, verb, url, body)
 	if err != nil {
-		logf(TRACE, "%s: cannot build request: %s", apiName, err.Error())
-		return nil
+		message := fmt.Sprintf("%s: cannot build request: %s", apiName, err.Error())
+		c.trace(ctx, message)
+		return clientError{status: -1, url: url, message: message, response: map[string]interface{}{"Message": message}, cause: err}
 	}
 	if c.OTP != "" {
 		req.Header.Set("otpcode", c.OTP)
 	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
 
 	// Call the API and get the response.
-	body, httpErr := c.doRequest(req)
+	rpBody, httpErr := c.doRequest(req)
 	if httpErr != nil {
-		logf(TRACE, "%s: failed: %s", apiName, httpErr.Error())
+		c.trace(ctx, apiName+": failed", "error", httpErr.Error())
 		return httpErr
 	}
-	bodyString := string(body)
-	logf(TRACE, "%s: received response: %s", apiName, bodyString)
+	bodyString := string(rpBody)
+	c.trace(ctx, apiName+": received response", "body", redactBody(rpBody))
 
 	// Check for an expected "null" response.
 	if rp == nil {
-		logf(TRACE, "%s: expected null response", apiName)
+		c.trace(ctx, apiName+": expected null response")
 		if bodyString == "null" || bodyString == "" {
 			return nil
 		}
-		message := fmt.Sprintf("%s: received unexpected response: %s", apiName, bodyString)
-		logf(TRACE, "%s", message)
-		return appHttpError(req, message)
+		message := fmt.Sprintf("%s: received unexpected response: %s", apiName, redactBody(rpBody))
+		c.trace(ctx, message)
+		return invalidResponseError(url, message, nil)
 	}
 
 	// Otherwise, interpret it as an object.
-	err = json.Unmarshal(body, rp)
+	err = json.Unmarshal(rpBody, rp)
 	if err != nil {
 		message := fmt.Sprintf("%s: cannot unmarshal response from JSON: %s", apiName, err.Error())
-		logf(TRACE, "%s", message)
-		return newHttpError(req, -1, message)
+		c.trace(ctx, message)
+		return invalidResponseError(url, message, err)
 	}
 	return nil
 }
 
 // Utility method to call an API with a GET request, handling logging, etc.
-func (c *Client) getAPI(apiName string, apiPath string, rp interface{}) ClientError {
-	return c.doAPI("GET", apiName, apiPath, rp)
+func (c *Client) getAPI(ctx context.Context, apiName string, apiPath string, rp interface{}) ClientError {
+	return c.doAPI(ctx, "GET", apiName, apiPath, rp)
+}
+
+// Utility method to call a JIT API with a GET request, sending the reason of the context, if any.
+func (c *Client) getJitAPI(ctx context.Context, apiName string, apiPath string, rp interface{}) ClientError {
+	var header http.Header
+	if reason := JitReason(ctx); reason != "" {
+		header = http.Header{JitReasonHeader: {reason}}
+	}
+	return c.doAPIWithHeaders(ctx, "GET", apiName, apiPath, header, nil, rp)
+}
+
+// GetAPI calls any Duplo API with a GET request, unmarshaling the JSON response into rp.
+// If rp is nil, the API must return an empty or "null" response.
+func (c *Client) GetAPI(ctx context.Context, apiPath string, rp interface{}) ClientError {
+	return c.doAPI(ctx, "GET", apiPath, apiPath, rp)
+}
+
+// PostAPI calls any Duplo API with a POST request, sending rq as JSON and unmarshaling the JSON response into rp.
+// If rq is nil, no request body is sent.  If rp is nil, the API must return an empty or "null" response.
+func (c *Client) PostAPI(ctx context.Context, apiPath string, rq interface{}, rp interface{}) ClientError {
+	return c.doAPIWithRequestBody(ctx, "POST", apiPath, apiPath, rq, rp)
+}
+
+// PutAPI calls any Duplo API with a PUT request, sending rq as JSON and unmarshaling the JSON response into rp.
+// If rq is nil, no request body is sent.  If rp is nil, the API must return an empty or "null" response.
+func (c *Client) PutAPI(ctx context.Context, apiPath string, rq interface{}, rp interface{}) ClientError {
+	return c.doAPIWithRequestBody(ctx, "PUT", apiPath, apiPath, rq, rp)
+}
+
+// DeleteAPI calls any Duplo API with a DELETE request, unmarshaling the JSON response into rp.
+// If rp is nil, the API must return an empty or "null" response.
+func (c *Client) DeleteAPI(ctx context.Context, apiPath string, rp interface{}) ClientError {
+	return c.doAPI(ctx, "DELETE", apiPath, apiPath, rp)
 }
@@ -0,0 +1,91 @@
+package duplotest
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// browserArg is the first argument of a test binary started as a fake browser.
+const browserArg = "duplotest-browser"
+
+// Browse acts as the user's browser for an interactive login URL: it follows the redirects from
+// the portal to the local callback of the command, and back to the portal.
+func Browse(client *http.Client, loginURL string) error {
+	res, err := client.Get(loginURL)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = res.Body.Close() }()
+	_, _ = io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("login failed: %s", res.Status)
+	}
+	return nil
+}
+
+// WriteCABundle writes the certificate of a TLS server to a PEM file, for use with --ca-bundle.
+func (s *Server) WriteCABundle(t testing.TB) string {
+	t.Helper()
+	if s.Certificate() == nil {
+		t.Fatal("duplotest: not a TLS server")
+	}
+
+	path := filepath.Join(t.TempDir(), "duplotest-ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("duplotest: %v", err)
+	}
+	return path
+}
+
+// BrowserCommand returns a --browser command that runs the current test binary as a fake browser
+// for the portal.  The test binary must call RunBrowser at the start of TestMain, and its path
+// must not contain spaces.
+func (s *Server) BrowserCommand(t testing.TB) string {
+	t.Helper()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatalf("duplotest: %v", err)
+	}
+
+	caBundle := "-"
+	if s.Certificate() != nil {
+		caBundle = s.WriteCABundle(t)
+	}
+	return fmt.Sprintf("%s %s %s", exe, browserArg, caBundle)
+}
+
+// RunBrowser acts as a fake browser, and exits, if the test binary was started by BrowserCommand.
+// Otherwise, it does nothing.
+func RunBrowser() {
+	if len(os.Args) != 4 || os.Args[1] != browserArg {
+		return
+	}
+
+	client := &http.Client{}
+	if caBundle := os.Args[2]; caBundle != "-" {
+		data, err := os.ReadFile(caBundle)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "duplotest browser: %s\n", err)
+			os.Exit(1)
+		}
+		roots := x509.NewCertPool()
+		roots.AppendCertsFromPEM(data)
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig.RootCAs = roots
+		client.Transport = transport
+	}
+
+	if err := Browse(client, os.Args[3]); err != nil {
+		fmt.Fprintf(os.Stderr, "duplotest browser: %s\n", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,387 @@
+// Package duplotest provides a fake Duplo portal, for testing code that uses the Duplo API or the
+// duplo-jit commands without a real portal.
+package duplotest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/duplocloud/duplo-jit/duplocloud"
+)
+
+// DefaultToken is the API token accepted by a Server, unless configured otherwise.
+const DefaultToken = "duplotest-token"
+
+// DefaultUsername is the user behind the API token.
+const DefaultUsername = "duplotest@example.com"
+
+// Config configures a Server.  It can be changed at any time with Server.Update.
+type Config struct {
+	// Token is the API token accepted by the portal, and issued by interactive logins.
+	Token string
+
+	// Tenants are the tenants accessible to the user.
+	Tenants []duplocloud.UserTenant
+
+	// Admin grants the user access to the admin APIs.
+	Admin bool
+
+	// OTP, if set, is the code that the admin APIs require, unless the token comes from an admin
+	// login, which delivers the code along with the token.
+	OTP string
+
+	// AwsAdminJITEnabled and DuploOpsEnabled are reported as system features.
+	AwsAdminJITEnabled bool
+	DuploOpsEnabled    bool
+
+	// Validity is the lifetime of JIT credentials, in seconds.  It defaults to 3600.
+	Validity int
+
+	// K8sServer is the Kubernetes API server of JIT credentials.
+	K8sServer string
+
+	// K8sCAData is the PEM-encoded CA certificate of the Kubernetes API server, if any.
+	K8sCAData []byte
+}
+
+// Server is a fake Duplo portal.  It serves the system and tenant features, the tenants of the user,
+// the AWS and Kubernetes JIT APIs, and the browser page of interactive logins.
+type Server struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	config  Config
+	revoked bool
+	calls   map[string]int
+	reasons map[string][]string
+	logins  int
+	errors  map[string]*injectedError
+}
+
+type injectedError struct {
+	status int
+	times  int
+}
+
+// NewServer starts a fake Duplo portal over HTTP.  The caller must call Close when done.
+func NewServer(config Config) *Server {
+	s := newServer(config)
+	s.Server = httptest.NewServer(s.handler())
+	return s
+}
+
+// NewTLSServer starts a fake Duplo portal over HTTPS, with a self-signed certificate.
+// The caller must call Close when done.
+func NewTLSServer(config Config) *Server {
+	s := newServer(config)
+	s.Server = httptest.NewTLSServer(s.handler())
+	return s
+}
+
+func newServer(config Config) *Server {
+	s := &Server{calls: map[string]int{}, reasons: map[string][]string{}, errors: map[string]*injectedError{}}
+	s.Update(func(c *Config) { *c = config })
+	return s
+}
+
+// Update changes the configuration of the portal.
+func (s *Server) Update(update func(config *Config)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	update(&s.config)
+	if s.config.Token == "" {
+		s.config.Token = DefaultToken
+	}
+	if s.config.Validity == 0 {
+		s.config.Validity = 3600
+	}
+	if s.config.K8sServer == "" {
+		s.config.K8sServer = "https://k8s.duplotest.invalid"
+	}
+}
+
+// Fail makes the next requests to the API path (such as "v3/features/system") fail with the given
+// HTTP status.  With times <= 0, all requests fail until ClearErrors is called.
+func (s *Server) Fail(path string, status int, times int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errors[strings.TrimPrefix(path, "/")] = &injectedError{status: status, times: times}
+}
+
+// ClearErrors stops failing requests.
+func (s *Server) ClearErrors() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errors = map[string]*injectedError{}
+}
+
+// Calls returns the number of requests to the API path (such as "v3/features/system"), including failed ones.
+func (s *Server) Calls(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[strings.TrimPrefix(path, "/")]
+}
+
+// Reasons returns the reasons sent with requests to the API path, in the JitReasonHeader.
+func (s *Server) Reasons(path string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.reasons[strings.TrimPrefix(path, "/")]...)
+}
+
+// TotalCalls returns the number of requests to the portal.
+func (s *Server) TotalCalls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	total := 0
+	for _, n := range s.calls {
+		total += n
+	}
+	return total
+}
+
+// Logins returns the number of interactive logins that delivered a token.
+func (s *Server) Logins() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.logins
+}
+
+// handler routes the requests, after counting them and injecting errors.
+func (s *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v3/features/system", s.api(false, s.featuresSystem))
+	mux.HandleFunc("GET /v3/features/tenant/{id}", s.api(false, s.featuresTenant))
+	mux.HandleFunc("GET /admin/GetTenantsForUser", s.api(false, s.tenantsForUser))
+	mux.HandleFunc("GET /admin/GetUserProfile", s.api(false, s.userProfile))
+	mux.HandleFunc("POST /admin/Logout", s.api(false, s.logout))
+	mux.HandleFunc("GET /subscriptions/{id}/GetAwsConsoleTokenUrl", s.api(false, s.tenantAwsJitAccess))
+	mux.HandleFunc("GET /v3/subscriptions/{id}/k8s/jitAccess", s.api(false, s.tenantK8sJitAccess))
+	mux.HandleFunc("GET /v3/admin/aws/jitAccess/{role}", s.api(true, s.adminAwsJitAccess))
+	mux.HandleFunc("GET /v3/admin/plans", s.api(true, s.adminPlans))
+	mux.HandleFunc("GET /v3/admin/plans/{plan}/k8sConfig", s.api(true, s.adminK8sJitAccess))
+	mux.HandleFunc("GET /app/user/verify-token", s.verifyToken)
+
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		path := strings.TrimPrefix(req.URL.Path, "/")
+
+		s.mu.Lock()
+		s.calls[path]++
+		if reason := req.Header.Get(duplocloud.JitReasonHeader); reason != "" {
+			s.reasons[path] = append(s.reasons[path], reason)
+		}
+		status := 0
+		if e := s.errors[path]; e != nil {
+			status = e.status
+			if e.times--; e.times == 0 {
+				delete(s.errors, path)
+			}
+		}
+		s.mu.Unlock()
+
+		if status != 0 {
+			writeMessage(res, status, "injected error")
+			return
+		}
+		mux.ServeHTTP(res, req)
+	})
+}
+
+// api authenticates an API request, then calls the handler with a snapshot of the configuration.
+func (s *Server) api(admin bool, handle func(config Config, req *http.Request) (interface{}, int)) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		s.mu.Lock()
+		config := s.config
+		revoked := s.revoked
+		s.mu.Unlock()
+
+		if revoked || req.Header.Get("Authorization") != "Bearer "+config.Token {
+			writeMessage(res, http.StatusUnauthorized, "invalid or expired token")
+			return
+		}
+		if admin && !config.Admin {
+			writeMessage(res, http.StatusForbidden, "admin access is required")
+			return
+		}
+		if admin && config.OTP != "" && req.Header.Get("otpcode") != config.OTP {
+			writeMessage(res, http.StatusForbidden, "invalid OTP code")
+			return
+		}
+
+		result, status := handle(config, req)
+		if status != http.StatusOK {
+			writeMessage(res, status, http.StatusText(status))
+			return
+		}
+		res.Header().Set("Content-Type", "application/json; charset=utf-8")
+		_ = json.NewEncoder(res).Encode(result)
+	}
+}
+
+func writeMessage(res http.ResponseWriter, status int, message string) {
+	res.Header().Set("Content-Type", "application/json; charset=utf-8")
+	res.WriteHeader(status)
+	_ = json.NewEncoder(res).Encode(map[string]string{"Message": message})
+}
+
+func findTenant(config Config, id string) *duplocloud.UserTenant {
+	for i := range config.Tenants {
+		if config.Tenants[i].TenantID == id {
+			return &config.Tenants[i]
+		}
+	}
+	return nil
+}
+
+func (s *Server) featuresSystem(config Config, _ *http.Request) (interface{}, int) {
+	return &duplocloud.DuploSystemFeatures{
+		IsOtpNeeded:          config.OTP != "",
+		IsAwsAdminJITEnabled: config.AwsAdminJITEnabled,
+		IsDuploOpsEnabled:    config.DuploOpsEnabled,
+		DefaultAwsRegion:     "us-west-2",
+	}, http.StatusOK
+}
+
+func (s *Server) featuresTenant(config Config, req *http.Request) (interface{}, int) {
+	if findTenant(config, req.PathValue("id")) == nil {
+		return nil, http.StatusNotFound
+	}
+	return &duplocloud.DuploTenantFeatures{Region: "us-west-2", IsKubernetesEnabled: true}, http.StatusOK
+}
+
+func (s *Server) tenantsForUser(config Config, _ *http.Request) (interface{}, int) {
+	tenants := config.Tenants
+	if tenants == nil {
+		tenants = []duplocloud.UserTenant{}
+	}
+	return tenants, http.StatusOK
+}
+
+func (s *Server) userProfile(config Config, _ *http.Request) (interface{}, int) {
+	roles := []string{"User"}
+	if config.Admin {
+		roles = append(roles, "Administrator")
+	}
+	return &duplocloud.DuploUserProfile{Username: DefaultUsername, Roles: roles}, http.StatusOK
+}
+
+func (s *Server) logout(_ Config, _ *http.Request) (interface{}, int) {
+	s.mu.Lock()
+	s.revoked = true
+	s.mu.Unlock()
+	return struct{}{}, http.StatusOK
+}
+
+func awsJitCredentials(config Config, principal string) *duplocloud.AwsJitCredentials {
+	return &duplocloud.AwsJitCredentials{
+		ConsoleURL:      "https://console.aws.amazon.com/",
+		AccessKeyID:     "ASIADUPLOTEST" + strings.ToUpper(principal),
+		SecretAccessKey: "duplotest-secret-" + principal,
+		SessionToken:    "duplotest-session-" + principal,
+		Region:          "us-west-2",
+		Validity:        config.Validity,
+	}
+}
+
+func k8sJitAccess(config Config, name string) *duplocloud.DuploPlanK8ClusterConfig {
+	now := time.Now().UTC()
+	return &duplocloud.DuploPlanK8ClusterConfig{
+		Name:                 name,
+		ApiServer:            config.K8sServer,
+		Token:                "duplotest-k8s-" + name,
+		AwsRegion:            "us-west-2",
+		K8sVersion:           "1.30",
+		LastTokenRefreshTime: &now,
+
+		CertificateAuthorityDataBase64: base64.StdEncoding.EncodeToString(config.K8sCAData),
+	}
+}
+
+func (s *Server) tenantAwsJitAccess(config Config, req *http.Request) (interface{}, int) {
+	tenant := findTenant(config, req.PathValue("id"))
+	if tenant == nil {
+		return nil, http.StatusForbidden
+	}
+	return awsJitCredentials(config, tenant.AccountName), http.StatusOK
+}
+
+func (s *Server) tenantK8sJitAccess(config Config, req *http.Request) (interface{}, int) {
+	tenant := findTenant(config, req.PathValue("id"))
+	if tenant == nil {
+		return nil, http.StatusForbidden
+	}
+	return k8sJitAccess(config, tenant.PlanID), http.StatusOK
+}
+
+func (s *Server) adminAwsJitAccess(config Config, req *http.Request) (interface{}, int) {
+	role := req.PathValue("role")
+	if role == "duplo-ops" && !config.DuploOpsEnabled {
+		return nil, http.StatusForbidden
+	}
+	return awsJitCredentials(config, role), http.StatusOK
+}
+
+// adminPlans lists the plans of the tenants.
+func (s *Server) adminPlans(config Config, _ *http.Request) (interface{}, int) {
+	plans := []duplocloud.DuploPlan{}
+	seen := map[string]bool{}
+	for _, tenant := range config.Tenants {
+		if tenant.PlanID != "" && !seen[tenant.PlanID] {
+			seen[tenant.PlanID] = true
+			plans = append(plans, duplocloud.DuploPlan{Name: tenant.PlanID, KubernetesConfig: k8sJitAccess(config, tenant.PlanID)})
+		}
+	}
+	return plans, http.StatusOK
+}
+
+func (s *Server) adminK8sJitAccess(config Config, req *http.Request) (interface{}, int) {
+	return k8sJitAccess(config, req.PathValue("plan")), http.StatusOK
+}
+
+// verifyToken is the login page of interactive sessions.  Like the portal with redirect=true, it
+// sends the browser to the local callback of the command, which sends it back with success=true.
+func (s *Server) verifyToken(res http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	if query.Get("success") == "true" {
+		_, _ = fmt.Fprintln(res, "You are logged in, and can close this page.")
+		return
+	}
+
+	s.mu.Lock()
+	config := s.config
+	s.mu.Unlock()
+
+	admin := query.Get("isAdmin") == "true"
+	if admin && !config.Admin {
+		http.Error(res, "admin access is required", http.StatusForbidden)
+		return
+	}
+
+	// The callback with OTP receives the token and the code as JSON.
+	callback := url.URL{Scheme: "http", Host: "127.0.0.1:" + query.Get("localPort"), Path: "/"}
+	params := url.Values{"t": {config.Token}}
+	if admin && config.OTP != "" {
+		result, _ := json.Marshal(map[string]string{"token": config.Token, "otp": config.OTP})
+		callback.Path = "/v2/callbackWithOtp"
+		params.Set("t", string(result))
+	}
+	if state := query.Get("state"); state != "" {
+		params.Set("state", state)
+	}
+	callback.RawQuery = params.Encode()
+
+	s.mu.Lock()
+	s.logins++
+	s.revoked = false
+	s.mu.Unlock()
+	http.Redirect(res, req, callback.String(), http.StatusFound)
+}
@@ -0,0 +1,198 @@
+package duplotest_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/duplocloud/duplo-jit/duplocloud"
+	"github.com/duplocloud/duplo-jit/duplocloud/duplotest"
+)
+
+var testTenants = []duplocloud.UserTenant{
+	{TenantID: "11111111-1111-1111-1111-111111111111", AccountName: "dev", PlanID: "nonprod"},
+	{TenantID: "22222222-2222-2222-2222-222222222222", AccountName: "prod", PlanID: "prod"},
+}
+
+func newClient(t *testing.T, s *duplotest.Server, token, otp string) *duplocloud.Client {
+	t.Helper()
+	client, err := duplocloud.NewClientWithOtp(s.URL, token, otp)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	client.HTTPClient = s.Client()
+	return client
+}
+
+func TestServer_TenantAPIs(t *testing.T) {
+	s := duplotest.NewServer(duplotest.Config{Tenants: testTenants})
+	defer s.Close()
+	client := newClient(t, s, duplotest.DefaultToken, "")
+
+	tenants, err := client.ListTenantsForUser()
+	if err != nil || len(*tenants) != 2 {
+		t.Fatalf("ListTenantsForUser() = %v, %v", tenants, err)
+	}
+	if _, err := client.GetTenantFeatures(testTenants[0].TenantID); err != nil {
+		t.Errorf("GetTenantFeatures() error: %v", err)
+	}
+	creds, err := client.TenantGetJitAwsCredentials(testTenants[0].TenantID)
+	if err != nil || creds.AccessKeyID == "" || creds.Validity != 3600 {
+		t.Errorf("TenantGetJitAwsCredentials() = %+v, %v", creds, err)
+	}
+	k8s, err := client.TenantGetK8sJitAccess(testTenants[1].TenantID)
+	if err != nil || k8s.Name != "prod" || k8s.Token == "" {
+		t.Errorf("TenantGetK8sJitAccess() = %+v, %v", k8s, err)
+	}
+
+	if _, err := client.TenantGetJitAwsCredentials("unknown"); !errors.Is(err, duplocloud.ErrForbidden) {
+		t.Errorf("expected forbidden for an unknown tenant, got %v", err)
+	}
+	if got := s.Calls("admin/GetTenantsForUser"); got != 1 {
+		t.Errorf("expected 1 call, got %d", got)
+	}
+}
+
+func TestServer_JitReason(t *testing.T) {
+	s := duplotest.NewServer(duplotest.Config{Admin: true, Tenants: testTenants})
+	defer s.Close()
+	client := newClient(t, s, duplotest.DefaultToken, "")
+	ctx := duplocloud.WithJitReason(context.Background(), "INC-1234: restore the database")
+
+	// The reason is sent with JIT API calls only.
+	if _, err := client.ListTenantsForUserContext(ctx); err != nil {
+		t.Fatalf("ListTenantsForUser() error: %v", err)
+	}
+	if _, err := client.AdminAwsGetJitAccessContext(ctx, "admin"); err != nil {
+		t.Fatalf("AdminAwsGetJitAccess() error: %v", err)
+	}
+	if _, err := client.TenantGetK8sJitAccessContext(ctx, testTenants[0].TenantID); err != nil {
+		t.Fatalf("TenantGetK8sJitAccess() error: %v", err)
+	}
+	if _, err := client.AdminGetK8sJitAccess("nonprod"); err != nil {
+		t.Fatalf("AdminGetK8sJitAccess() error: %v", err)
+	}
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"admin/GetTenantsForUser", 0},
+		{"v3/admin/aws/jitAccess/admin", 1},
+		{"v3/subscriptions/" + testTenants[0].TenantID + "/k8s/jitAccess", 1},
+		{"v3/admin/plans/nonprod/k8sConfig", 0},
+	}
+	for _, tt := range tests {
+		reasons := s.Reasons(tt.path)
+		if len(reasons) != tt.want || (tt.want != 0 && reasons[0] != "INC-1234: restore the database") {
+			t.Errorf("%s: unexpected reasons %q", tt.path, reasons)
+		}
+	}
+}
+
+func TestServer_InvalidToken(t *testing.T) {
+	s := duplotest.NewServer(duplotest.Config{Tenants: testTenants})
+	defer s.Close()
+
+	_, err := newClient(t, s, "wrong-token", "").FeaturesSystem()
+	if !errors.Is(err, duplocloud.ErrNotAuthenticated) {
+		t.Errorf("expected not authenticated, got %v", err)
+	}
+}
+
+func TestServer_AdminAndOTP(t *testing.T) {
+	s := duplotest.NewServer(duplotest.Config{Admin: true, OTP: "123456"})
+	defer s.Close()
+
+	features, err := newClient(t, s, duplotest.DefaultToken, "").FeaturesSystem()
+	if err != nil || !features.IsOtpNeeded {
+		t.Fatalf("FeaturesSystem() = %+v, %v", features, err)
+	}
+	if _, err := newClient(t, s, duplotest.DefaultToken, "").AdminGetJitAwsCredentials(); err == nil {
+		t.Error("expected admin access without an OTP code to fail")
+	}
+	if _, err := newClient(t, s, duplotest.DefaultToken, "000000").AdminGetJitAwsCredentials(); !errors.Is(err, duplocloud.ErrInvalidOTP) {
+		t.Errorf("expected invalid OTP, got %v", err)
+	}
+	if _, err := newClient(t, s, duplotest.DefaultToken, "123456").AdminGetJitAwsCredentials(); err != nil {
+		t.Errorf("AdminGetJitAwsCredentials() error: %v", err)
+	}
+
+	// Without admin access, admin APIs are forbidden.
+	s.Update(func(c *duplotest.Config) { c.Admin = false })
+	if _, err := newClient(t, s, duplotest.DefaultToken, "123456").ListPlans(); !errors.Is(err, duplocloud.ErrForbidden) {
+		t.Errorf("expected forbidden, got %v", err)
+	}
+}
+
+func TestServer_Fail(t *testing.T) {
+	s := duplotest.NewServer(duplotest.Config{})
+	defer s.Close()
+	client := newClient(t, s, duplotest.DefaultToken, "")
+	client.Retry.MaxAttempts = 1
+
+	s.Fail("v3/features/system", http.StatusServiceUnavailable, 1)
+	if _, err := client.FeaturesSystem(); err == nil || err.Status() != http.StatusServiceUnavailable {
+		t.Errorf("expected injected error, got %v", err)
+	}
+	if _, err := client.FeaturesSystem(); err != nil {
+		t.Errorf("expected a single injected error, got %v", err)
+	}
+	if got := s.Calls("v3/features/system"); got != 2 {
+		t.Errorf("expected 2 calls, got %d", got)
+	}
+}
+
+func TestServer_Logout(t *testing.T) {
+	s := duplotest.NewServer(duplotest.Config{})
+	defer s.Close()
+	client := newClient(t, s, duplotest.DefaultToken, "")
+
+	if err := client.Logout(); err != nil {
+		t.Fatalf("Logout() error: %v", err)
+	}
+	if _, err := client.FeaturesSystem(); !errors.Is(err, duplocloud.ErrNotAuthenticated) {
+		t.Errorf("expected the token to be revoked, got %v", err)
+	}
+}
+
+func TestBrowse(t *testing.T) {
+	s := duplotest.NewTLSServer(duplotest.Config{Admin: true, OTP: "123456"})
+	defer s.Close()
+
+	// A local callback, like the one of an interactive login.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan url.Values, 1)
+	callback := &http.Server{Handler: http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		received <- req.URL.Query()
+		http.Redirect(res, req, s.URL+"/app/user/verify-token?success=true", http.StatusFound)
+	})}
+	go func() { _ = callback.Serve(listener) }()
+	defer func() { _ = callback.Close() }()
+
+	loginURL := fmt.Sprintf("%s/app/user/verify-token?localAppName=test&localPort=%d&isAdmin=true&redirect=true&state=xyz",
+		s.URL, listener.Addr().(*net.TCPAddr).Port)
+	if err := duplotest.Browse(s.Client(), loginURL); err != nil {
+		t.Fatalf("Browse() error: %v", err)
+	}
+
+	query := <-received
+	if query.Get("state") != "xyz" || query.Get("t") != `{"otp":"123456","token":"duplotest-token"}` {
+		t.Errorf("unexpected callback: %v", query)
+	}
+	if s.Logins() != 1 {
+		t.Errorf("expected 1 login, got %d", s.Logins())
+	}
+
+	// Admin requests with the token of the login still need an OTP code.
+	if _, err := newClient(t, s, duplotest.DefaultToken, "").AdminGetJitAwsCredentials(); err == nil {
+		t.Error("expected admin access without an OTP code to fail")
+	}
+}
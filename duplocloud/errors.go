@@ -0,0 +1,24 @@
+package duplocloud
+
+import "errors"
+
+// Sentinel errors that can be matched against a ClientError using errors.Is.
+var (
+	// ErrNotAuthenticated means the token is missing, invalid or expired (HTTP 401).
+	ErrNotAuthenticated = errors.New("not authenticated to Duplo: the token is invalid or expired")
+
+	// ErrForbidden means the user is authenticated but lacks access to the resource (HTTP 403).
+	ErrForbidden = errors.New("access denied by Duplo")
+
+	// ErrNotFound means the resource or API does not exist (HTTP 404).
+	ErrNotFound = errors.New("not found in Duplo")
+
+	// ErrInvalidOTP means an OTP code was sent, but was wrong or has expired (HTTP 401, or an OTP-related 403).
+	ErrInvalidOTP = errors.New("the OTP code was not accepted by Duplo")
+
+	// ErrUnreachable means the portal could not be reached, the connection failed, or the request timed out.
+	ErrUnreachable = errors.New("cannot reach the Duplo portal")
+
+	// ErrInvalidResponse means the portal answered, but not with the expected JSON, such as with an HTML error page.
+	ErrInvalidResponse = errors.New("unexpected response from the Duplo portal")
+)
@@ -0,0 +1,206 @@
+package duplocloud
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestClientError_Sentinels(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		want   error
+	}{
+		{"unauthorized", http.StatusUnauthorized, ErrNotAuthenticated},
+		{"forbidden", http.StatusForbidden, ErrForbidden},
+		{"not found", http.StatusNotFound, ErrNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, _ := newTestClient(t, func(res http.ResponseWriter, req *http.Request) {
+				res.WriteHeader(tt.status)
+			})
+
+			_, err := client.FeaturesSystem()
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !errors.Is(err, tt.want) {
+				t.Errorf("expected errors.Is(err, %v), got %v", tt.want, err)
+			}
+			if errors.Is(err, ErrUnreachable) {
+				t.Error("did not expect ErrUnreachable")
+			}
+
+			var clientErr ClientError
+			if !errors.As(error(err), &clientErr) || clientErr.Status() != tt.status {
+				t.Errorf("expected errors.As to find a ClientError with status %d", tt.status)
+			}
+		})
+	}
+}
+
+func TestClientError_InvalidOTP(t *testing.T) {
+	tests := []struct {
+		name    string
+		otp     string
+		status  int
+		message string
+		want    bool
+	}{
+		{"unauthorized with OTP", "123456", http.StatusUnauthorized, "", true},
+		{"forbidden mentioning OTP", "123456", http.StatusForbidden, "Invalid OTP", true},
+		{"forbidden for another reason", "123456", http.StatusForbidden, "Not an administrator", false},
+		{"unauthorized without OTP", "", http.StatusUnauthorized, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, _ := newTestClient(t, func(res http.ResponseWriter, req *http.Request) {
+				res.WriteHeader(tt.status)
+				_, _ = res.Write([]byte(tt.message))
+			})
+			client.OTP = tt.otp
+
+			_, err := client.AdminGetJitAwsCredentials()
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if got := errors.Is(err, ErrInvalidOTP); got != tt.want {
+				t.Errorf("errors.Is(err, ErrInvalidOTP) = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientError_Unreachable(t *testing.T) {
+	// Find a port that nothing listens on.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	url := "http://" + listener.Addr().String()
+	_ = listener.Close()
+
+	client, _ := NewClient(url, "test-token")
+	client.Retry = NoRetryPolicy()
+
+	_, cerr := client.FeaturesSystem()
+	if cerr == nil {
+		t.Fatal("expected an error")
+	}
+	if !errors.Is(cerr, ErrUnreachable) {
+		t.Errorf("expected ErrUnreachable, got %v", cerr)
+	}
+	var opErr *net.OpError
+	if !errors.As(error(cerr), &opErr) {
+		t.Errorf("expected the underlying *net.OpError to be unwrappable, got %v", cerr)
+	}
+}
+
+func TestClientError_Timeout(t *testing.T) {
+	client, _ := newTestClient(t, func(res http.ResponseWriter, req *http.Request) {
+		<-req.Context().Done()
+	})
+	client.Retry = NoRetryPolicy()
+	client.HTTPClient.Timeout = 50 * time.Millisecond
+
+	// A timeout of the HTTP client means the portal is unreachable.
+	_, err := client.FeaturesSystem()
+	if !errors.Is(err, ErrUnreachable) {
+		t.Errorf("expected ErrUnreachable, got %v", err)
+	}
+}
+
+func TestClientError_InvalidResponse(t *testing.T) {
+	client, _ := newTestClient(t, func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Content-Type", "text/html")
+		_, _ = res.Write([]byte("<html><body>Bad gateway</body></html>"))
+	})
+
+	// A portal that answers with an HTML page was reached, but did not answer as expected.
+	_, err := client.FeaturesSystem()
+	if !errors.Is(err, ErrInvalidResponse) {
+		t.Errorf("expected ErrInvalidResponse, got %v", err)
+	}
+	if errors.Is(err, ErrUnreachable) {
+		t.Error("did not expect ErrUnreachable for an HTML response")
+	}
+}
+
+func TestClientError_Canceled(t *testing.T) {
+	client, _ := newTestClient(t, func(res http.ResponseWriter, req *http.Request) {
+		_, _ = res.Write([]byte(`{}`))
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := client.FeaturesSystemContext(ctx)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if errors.Is(err, ErrUnreachable) {
+		t.Error("did not expect ErrUnreachable for a canceled request")
+	}
+}
+
+func TestRequestBodyVerbs(t *testing.T) {
+	client, _ := newTestClient(t, func(res http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Content-Type") != "application/json; charset=utf-8" {
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch req.Method {
+		case "POST", "PUT":
+			body, _ := io.ReadAll(req.Body)
+			if string(body) != `{"Name":"test"}` {
+				res.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = res.Write(body)
+		case "DELETE":
+			_, _ = res.Write([]byte("null"))
+		default:
+			res.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+
+	ctx := context.Background()
+	rq := map[string]string{"Name": "test"}
+
+	rp := map[string]string{}
+	if err := client.PostAPI(ctx, "v3/test", rq, &rp); err != nil || rp["Name"] != "test" {
+		t.Errorf("PostAPI: unexpected result %v, err %v", rp, err)
+	}
+
+	rp = map[string]string{}
+	if err := client.PutAPI(ctx, "v3/test", rq, &rp); err != nil || rp["Name"] != "test" {
+		t.Errorf("PutAPI: unexpected result %v, err %v", rp, err)
+	}
+
+	if err := client.DeleteAPI(ctx, "v3/test", nil); err != nil {
+		t.Errorf("DeleteAPI: unexpected error %v", err)
+	}
+}
+
+func TestRequestBody_MarshalError(t *testing.T) {
+	client, calls := newTestClient(t, func(res http.ResponseWriter, req *http.Request) {})
+
+	err := client.PostAPI(context.Background(), "v3/test", map[string]interface{}{"bad": make(chan int)}, nil)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if *calls != 0 {
+		t.Errorf("expected no API calls, got %d", *calls)
+	}
+}
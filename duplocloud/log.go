@@ -1,7 +1,17 @@
 package duplocloud
 
-import "log"
+import (
+	"context"
+	"log"
+	"log/slog"
+)
+
+// LevelTrace is more verbose than slog.LevelDebug, and logs every API request and response.
+const LevelTrace = slog.Level(-8)
 
+// Legacy log levels, for LogLevel.
+//
+// Deprecated: clients log with log/slog; use the slog levels and LevelTrace instead.
 const (
 	FATAL = 0
 	ERROR = 1
@@ -11,10 +21,44 @@ const (
 	TRACE = 5
 )
 
-var LogLevel = 1
+// LogLevel is the legacy log level.  DEBUG or TRACE still turns on debug or trace logging, to the output
+// of the log package, for clients without a Logger.
+//
+// Deprecated: set Client.Logger, or the level of the default slog logger, instead.
+var LogLevel = ERROR
+
+// log returns the client's logger, or the default logger if none was set.
+func (c *Client) log() *slog.Logger {
+	if c != nil && c.Logger != nil {
+		return c.Logger
+	}
+	if LogLevel >= DEBUG {
+		return legacyLogger()
+	}
+	return slog.Default()
+}
+
+// trace logs a message at LevelTrace.
+func (c *Client) trace(ctx context.Context, msg string, args ...any) {
+	c.log().Log(ctx, LevelTrace, msg, args...)
+}
+
+// legacyLogger logs to the output of the log package, at the level of LogLevel.
+func legacyLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(log.Writer(), &slog.HandlerOptions{Level: slogLevel(LogLevel)}))
+}
 
-func logf(level int, msg string, v ...interface{}) {
-	if level <= LogLevel {
-		log.Printf(msg, v...)
+// slogLevel converts a legacy log level into a slog level.
+func slogLevel(level int) slog.Level {
+	switch {
+	case level <= ERROR:
+		return slog.LevelError
+	case level == WARN:
+		return slog.LevelWarn
+	case level == INFO:
+		return slog.LevelInfo
+	case level == DEBUG:
+		return slog.LevelDebug
 	}
+	return LevelTrace
 }
@@ -0,0 +1,104 @@
+package duplocloud
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// secretFields are JSON fields (compared case-insensitively) whose values are never logged.
+var secretFields = map[string]bool{
+	"secretaccesskey": true,
+	"sessiontoken":    true,
+	"token":           true,
+	"duplotoken":      true,
+	"consoleurl":      true, // contains a federated sign-in token
+	"otp":             true,
+	"otpcode":         true,
+	"password":        true,
+	"accesstoken":     true,
+	"refreshtoken":    true,
+}
+
+// secretHeaders are HTTP headers whose values are never logged.
+var secretHeaders = []string{"Authorization", "Otpcode", "Cookie", "Set-Cookie"}
+
+// redactBody returns a loggable form of a JSON request or response body, with the values of
+// secret fields masked.  Bodies that are not JSON are summarized by their length, since
+// their contents cannot be checked for secrets.
+func redactBody(body []byte) string {
+	trimmed := strings.TrimSpace(string(body))
+	if trimmed == "" || trimmed == "null" {
+		return trimmed
+	}
+
+	var data interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return fmt.Sprintf("(%d bytes of non-JSON content)", len(body))
+	}
+
+	out, err := json.Marshal(redactValue(data))
+	if err != nil {
+		return fmt.Sprintf("(%d bytes of unloggable content)", len(body))
+	}
+	return string(out)
+}
+
+// redactValue masks secret fields found anywhere in a decoded JSON value.
+func redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if secretFields[strings.ToLower(key)] {
+				if s, ok := item.(string); ok && s == "" {
+					continue // keep empty values, so that their absence is visible
+				}
+				v[key] = redacted
+			} else {
+				v[key] = redactValue(item)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactValue(item)
+		}
+	}
+	return value
+}
+
+// redactHeaders returns a loggable form of HTTP headers, with secret values masked.
+func redactHeaders(header http.Header) string {
+	masked := header.Clone()
+	for _, name := range secretHeaders {
+		values := masked.Values(name)
+		if len(values) == 0 {
+			continue
+		}
+		maskedValues := make([]string, len(values))
+		for i, value := range values {
+			// Keep the authentication scheme, as it helps with troubleshooting.
+			if scheme, _, ok := strings.Cut(value, " "); ok && name == "Authorization" {
+				maskedValues[i] = scheme + " " + redacted
+			} else {
+				maskedValues[i] = redacted
+			}
+		}
+		masked[http.CanonicalHeaderKey(name)] = maskedValues
+	}
+
+	names := make([]string, 0, len(masked))
+	for name := range masked {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s: %s", name, strings.Join(masked[name], ", ")))
+	}
+	return strings.Join(parts, "; ")
+}
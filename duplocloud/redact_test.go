@@ -0,0 +1,108 @@
+package duplocloud
+
+import (
+	"bytes"
+	"log"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRedactBody(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			"aws credentials",
+			`{"ConsoleUrl":"https://signin.aws.amazon.com/federation?SigninToken=abc","AccessKeyId":"AKIAEXAMPLE","SecretAccessKey":"wJalrXUtnFEMI","Region":"us-west-2","SessionToken":"FwoGZXIvYXdzE","Validity":3600}`,
+			`{"AccessKeyId":"AKIAEXAMPLE","ConsoleUrl":"[REDACTED]","Region":"us-west-2","SecretAccessKey":"[REDACTED]","SessionToken":"[REDACTED]","Validity":3600}`,
+		},
+		{
+			"nested and case-insensitive",
+			`[{"Name":"default","KubernetesConfig":{"ApiServer":"https://k8s","token":"eyJhbGciOi"}}]`,
+			`[{"KubernetesConfig":{"ApiServer":"https://k8s","token":"[REDACTED]"},"Name":"default"}]`,
+		},
+		{
+			"empty secret is kept",
+			`{"SessionToken":""}`,
+			`{"SessionToken":""}`,
+		},
+		{"null", `null`, `null`},
+		{"empty", ``, ``},
+		{"not JSON", `Bearer eyJhbGciOi`, `(17 bytes of non-JSON content)`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redactBody([]byte(tt.body)); got != tt.want {
+				t.Errorf("redactBody() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactHeaders(t *testing.T) {
+	header := http.Header{}
+	header.Set("Authorization", "Bearer secret-token")
+	header.Set("otpcode", "123456")
+	header.Set("Content-Type", "application/json")
+
+	got := redactHeaders(header)
+	want := "Authorization: Bearer [REDACTED]; Content-Type: application/json; Otpcode: [REDACTED]"
+	if got != want {
+		t.Errorf("redactHeaders() = %s, want %s", got, want)
+	}
+
+	// The original headers must be untouched.
+	if header.Get("Authorization") != "Bearer secret-token" {
+		t.Error("expected the original headers to be unchanged")
+	}
+}
+
+func TestTraceLoggingOmitsSecrets(t *testing.T) {
+	var buf bytes.Buffer
+
+	client, _ := newTestClient(t, func(res http.ResponseWriter, req *http.Request) {
+		_, _ = res.Write([]byte(`{"AccessKeyId":"AKIAEXAMPLE","SecretAccessKey":"wJalrXUtnFEMI","SessionToken":"FwoGZXIvYXdzE","Region":"us-west-2"}`))
+	})
+	client.OTP = "654321"
+	client.Logger = slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: LevelTrace}))
+
+	if _, err := client.AdminGetJitAwsCredentials(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	output := buf.String()
+	for _, secret := range []string{"wJalrXUtnFEMI", "FwoGZXIvYXdzE", "test-token", "654321"} {
+		if strings.Contains(output, secret) {
+			t.Errorf("expected %q to be redacted from the log:\n%s", secret, output)
+		}
+	}
+	for _, useful := range []string{"v3/admin/aws/jitAccess/admin", "status=200", "AKIAEXAMPLE", "us-west-2"} {
+		if !strings.Contains(output, useful) {
+			t.Errorf("expected %q in the log:\n%s", useful, output)
+		}
+	}
+}
+
+func TestLegacyLogLevel(t *testing.T) {
+	var buf bytes.Buffer
+	output, level := log.Writer(), LogLevel
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(output); LogLevel = level })
+
+	// The legacy TRACE level still logs requests, to the output of the log package.
+	client, _ := newTestClient(t, func(res http.ResponseWriter, req *http.Request) {
+		_, _ = res.Write([]byte(`{}`))
+	})
+	LogLevel = TRACE
+	if _, err := client.FeaturesSystem(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if output := buf.String(); !strings.Contains(output, "level=DEBUG-4") || strings.Contains(output, "test-token") {
+		t.Errorf("expected redacted trace logs:\n%s", output)
+	}
+}
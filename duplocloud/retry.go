@@ -0,0 +1,95 @@
+package duplocloud
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how API calls are retried after transient failures.
+//
+// Only idempotent GET requests are retried, and only after I/O errors or a
+// 429, 502, 503 or 504 response.
+type RetryPolicy struct {
+	MaxAttempts int           // total attempts, including the first one (1 or less disables retries)
+	BaseDelay   time.Duration // delay before the first retry, doubled for each further retry
+	MaxDelay    time.Duration // upper bound for a single backoff delay
+	MaxElapsed  time.Duration // total deadline for all attempts (0 means no deadline)
+}
+
+// DefaultRetryPolicy returns the retry policy used by NewClient.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 4,
+		BaseDelay:   500 * time.Millisecond,
+		MaxDelay:    8 * time.Second,
+		MaxElapsed:  60 * time.Second,
+	}
+}
+
+// NoRetryPolicy returns a retry policy that makes exactly one attempt.
+func NoRetryPolicy() RetryPolicy {
+	return RetryPolicy{MaxAttempts: 1}
+}
+
+// shouldRetry determines if a request can be retried, given the result of the previous attempt.
+func (p RetryPolicy) shouldRetry(req *http.Request, res *http.Response, err error) bool {
+	if req.Method != http.MethodGet {
+		return false
+	}
+
+	// I/O errors are retryable, unless the caller gave up on the request.
+	if err != nil {
+		return req.Context().Err() == nil
+	}
+
+	switch res.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff returns the delay before the given retry (starting at 1), using exponential
+// backoff with "equal jitter": half of the delay is fixed, and half is random.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < retry && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	half := delay / 2
+	return half + rand.N(delay-half+1)
+}
+
+// retryAfter parses the Retry-After header of a response, returning zero if it is missing or invalid.
+func retryAfter(res *http.Response, now time.Time) time.Duration {
+	if res == nil {
+		return 0
+	}
+	value := res.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+
+	// Delay in seconds.
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	// HTTP date.
+	if when, err := http.ParseTime(value); err == nil && when.After(now) {
+		return when.Sub(now)
+	}
+	return 0
+}
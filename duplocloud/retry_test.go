@@ -0,0 +1,191 @@
+package duplocloud
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) (*Client, *int32) {
+	t.Helper()
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		handler(res, req)
+	}))
+	t.Cleanup(srv.Close)
+
+	client, err := NewClient(srv.URL, "test-token")
+	if err != nil {
+		t.Fatalf("unexpected error creating client: %v", err)
+	}
+	client.Retry = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond, MaxElapsed: 5 * time.Second}
+	return client, &calls
+}
+
+func TestRetry_TransientFailureThenSuccess(t *testing.T) {
+	var n int32
+	client, calls := newTestClient(t, func(res http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&n, 1) < 3 {
+			res.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = res.Write([]byte(`{"IsOtpNeeded":true}`))
+	})
+
+	features, err := client.FeaturesSystem()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !features.IsOtpNeeded {
+		t.Error("expected response from the final attempt")
+	}
+	if *calls != 3 {
+		t.Errorf("expected 3 calls, got %d", *calls)
+	}
+}
+
+func TestRetry_GivesUpAfterMaxAttempts(t *testing.T) {
+	client, calls := newTestClient(t, func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusBadGateway)
+	})
+
+	_, err := client.FeaturesSystem()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if err.Status() != http.StatusBadGateway {
+		t.Errorf("expected status 502, got %d", err.Status())
+	}
+	if *calls != 3 {
+		t.Errorf("expected 3 calls, got %d", *calls)
+	}
+}
+
+func TestRetry_NonRetryableStatus(t *testing.T) {
+	client, calls := newTestClient(t, func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusUnauthorized)
+	})
+
+	_, err := client.FeaturesSystem()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if *calls != 1 {
+		t.Errorf("expected 1 call, got %d", *calls)
+	}
+}
+
+func TestRetry_RetryAfterBeyondDeadline(t *testing.T) {
+	client, calls := newTestClient(t, func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Retry-After", "3600")
+		res.WriteHeader(http.StatusTooManyRequests)
+	})
+
+	start := time.Now()
+	_, err := client.FeaturesSystem()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if *calls != 1 {
+		t.Errorf("expected 1 call, got %d", *calls)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("expected to give up without waiting, waited %v", time.Since(start))
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+	}{
+		{"missing", "", 0},
+		{"seconds", "7", 7 * time.Second},
+		{"negative", "-1", 0},
+		{"date", now.Add(30 * time.Second).Format(http.TimeFormat), 30 * time.Second},
+		{"past date", now.Add(-30 * time.Second).Format(http.TimeFormat), 0},
+		{"garbage", "soon", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := &http.Response{Header: http.Header{}}
+			if tt.value != "" {
+				res.Header.Set("Retry-After", tt.value)
+			}
+			if got := retryAfter(res, now); got != tt.want {
+				t.Errorf("retryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_BackoffBounds(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for retry := 1; retry <= 10; retry++ {
+		limit := 100 * time.Millisecond << (retry - 1)
+		if limit > time.Second {
+			limit = time.Second
+		}
+		for i := 0; i < 20; i++ {
+			delay := policy.backoff(retry)
+			if delay < limit/2 || delay > limit {
+				t.Fatalf("backoff(%d) = %v, want between %v and %v", retry, delay, limit/2, limit)
+			}
+		}
+	}
+}
+
+func TestRetry_ContextCanceledDuringBackoff(t *testing.T) {
+	client, calls := newTestClient(t, func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusServiceUnavailable)
+	})
+	client.Retry.BaseDelay = time.Minute
+	client.Retry.MaxDelay = time.Minute
+	client.Retry.MaxElapsed = time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := client.FeaturesSystemContext(ctx)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if *calls != 1 {
+		t.Errorf("expected 1 call, got %d", *calls)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("expected cancellation to interrupt the backoff, waited %v", time.Since(start))
+	}
+}
+
+func TestRetry_MaxElapsedIsDeadline(t *testing.T) {
+	client, calls := newTestClient(t, func(res http.ResponseWriter, req *http.Request) {
+		select {
+		case <-req.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	})
+	client.Retry.MaxElapsed = 200 * time.Millisecond
+
+	// A slow attempt is cut off by the deadline, rather than by the timeout of the HTTP client.
+	start := time.Now()
+	_, err := client.FeaturesSystem()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the call to return after about 200ms, took %v", elapsed)
+	}
+	if err == nil || !errors.Is(err, ErrUnreachable) {
+		t.Errorf("expected an unreachable error, got %v", err)
+	}
+	if *calls != 1 {
+		t.Errorf("expected 1 call, got %d", *calls)
+	}
+}
@@ -0,0 +1,111 @@
+package duplocloud
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// TransportOptions configures how HTTP connections are made to Duplo and to cloud provider APIs.
+type TransportOptions struct {
+	Proxy         string // proxy URL; if empty, HTTPS_PROXY, HTTP_PROXY and NO_PROXY are honored
+	CABundle      string // PEM file of CA certificates to trust, in addition to the system roots
+	ClientCert    string // PEM file of a client certificate for mutual TLS
+	ClientKey     string // PEM file of the private key of the client certificate
+	MinTLSVersion string // minimum TLS version: "1.2" (the default) or "1.3"
+}
+
+// NewTransport creates an HTTP transport configured with the given options.
+func NewTransport(opts TransportOptions) (*http.Transport, error) {
+	proxy, err := opts.ProxyFunc()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := opts.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+// ProxyFunc returns a function that selects the proxy to use for a request.
+// An explicit proxy is used for all requests, except for hosts listed in NO_PROXY.
+func (opts TransportOptions) ProxyFunc() (func(*http.Request) (*url.URL, error), error) {
+	if opts.Proxy == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+
+	if _, err := url.Parse(opts.Proxy); err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	noProxy := os.Getenv("NO_PROXY")
+	if noProxy == "" {
+		noProxy = os.Getenv("no_proxy")
+	}
+	config := httpproxy.Config{HTTPProxy: opts.Proxy, HTTPSProxy: opts.Proxy, NoProxy: noProxy}
+	proxyURL := config.ProxyFunc()
+
+	return func(req *http.Request) (*url.URL, error) {
+		return proxyURL(req.URL)
+	}, nil
+}
+
+// TLSConfig builds the TLS configuration for the given options.
+func (opts TransportOptions) TLSConfig() (*tls.Config, error) {
+	minVersion, err := ParseTLSVersion(opts.MinTLSVersion)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{MinVersion: minVersion}
+
+	// Trust additional CA certificates.
+	if opts.CABundle != "" {
+		pem, err := os.ReadFile(opts.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no PEM certificates found in CA bundle", opts.CABundle)
+		}
+		config.RootCAs = pool
+	}
+
+	// Present a client certificate.
+	if opts.ClientCert != "" || opts.ClientKey != "" {
+		if opts.ClientCert == "" || opts.ClientKey == "" {
+			return nil, fmt.Errorf("a client certificate requires both a certificate and a key")
+		}
+		cert, err := tls.LoadX509KeyPair(opts.ClientCert, opts.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// ParseTLSVersion converts a TLS version such as "1.2" into its crypto/tls constant.
+// An empty version defaults to TLS 1.2.
+func ParseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unsupported TLS version '%s': must be 1.2 or 1.3", version)
+}
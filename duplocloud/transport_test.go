@@ -0,0 +1,116 @@
+package duplocloud
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeServerCA writes the certificate of a TLS test server to a PEM file.
+func writeServerCA(t *testing.T, srv *httptest.Server) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("failed to write CA bundle: %v", err)
+	}
+	return path
+}
+
+func TestNewTransport_CABundle(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		_, _ = res.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	// Without the CA bundle, the server is not trusted.
+	client, _ := NewClient(srv.URL, "test-token")
+	client.Retry = NoRetryPolicy()
+	if _, err := client.FeaturesSystem(); err == nil {
+		t.Fatal("expected an untrusted certificate error")
+	}
+
+	// With the CA bundle, it is.
+	transport, err := NewTransport(TransportOptions{CABundle: writeServerCA(t, srv)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	client.HTTPClient.Transport = transport
+	if _, err := client.FeaturesSystem(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestNewTransport_InvalidOptions(t *testing.T) {
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	if err := os.WriteFile(empty, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		opts TransportOptions
+	}{
+		{"missing CA bundle", TransportOptions{CABundle: filepath.Join(t.TempDir(), "missing.pem")}},
+		{"empty CA bundle", TransportOptions{CABundle: empty}},
+		{"cert without key", TransportOptions{ClientCert: empty}},
+		{"key without cert", TransportOptions{ClientKey: empty}},
+		{"invalid client cert", TransportOptions{ClientCert: empty, ClientKey: empty}},
+		{"invalid TLS version", TransportOptions{MinTLSVersion: "1.0"}},
+		{"invalid proxy", TransportOptions{Proxy: "http://proxy:bad-port"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewTransport(tt.opts); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestProxyFunc_ExplicitProxyHonorsNoProxy(t *testing.T) {
+	t.Setenv("NO_PROXY", "internal.example.com")
+
+	proxy, err := TransportOptions{Proxy: "http://proxy.example.com:3128"}.ProxyFunc()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	req, _ := http.NewRequest("GET", "https://portal.example.com/v3/features/system", nil)
+	u, err := proxy(req)
+	if err != nil || u == nil || u.Host != "proxy.example.com:3128" {
+		t.Errorf("expected the explicit proxy, got %v (err: %v)", u, err)
+	}
+
+	req, _ = http.NewRequest("GET", "https://internal.example.com/v3/features/system", nil)
+	u, err = proxy(req)
+	if err != nil || u != nil {
+		t.Errorf("expected no proxy for a NO_PROXY host, got %v (err: %v)", u, err)
+	}
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    uint16
+		wantErr bool
+	}{
+		{"", tls.VersionTLS12, false},
+		{"1.2", tls.VersionTLS12, false},
+		{"1.3", tls.VersionTLS13, false},
+		{"1.1", 0, true},
+		{"tls13", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseTLSVersion(tt.version)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseTLSVersion(%q) = %v, %v; want %v, error %v", tt.version, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// auditLogEnvVar overrides the location of the audit log.
+const auditLogEnvVar = "DUPLO_JIT_AUDIT_LOG"
+
+// AuditLogMaxSize is the size of the audit log above which it is rotated.
+const AuditLogMaxSize = 5 << 20
+
+// AuditLogMaxBackups is how many rotated audit logs are kept.
+const AuditLogMaxBackups = 3
+
+// AuditEntry records the issuance of credentials.  It never holds secrets.
+type AuditEntry struct {
+	Timestamp  string `json:"Timestamp"`
+	Host       string `json:"Host"`
+	Kind       string `json:"Kind"`
+	Role       string `json:"Role"`
+	Tenant     string `json:"Tenant,omitempty"`
+	Plan       string `json:"Plan,omitempty"`
+	Source     string `json:"Source"`
+	Expiration string `json:"Expiration,omitempty"`
+	Reason     string `json:"Reason,omitempty"`
+	Command    string `json:"Command"`
+	PID        int    `json:"PID"`
+	Caller     string `json:"Caller,omitempty"`
+	CallerPID  int    `json:"CallerPID"`
+}
+
+// AuditLogPath returns the path of the audit log: DUPLO_JIT_AUDIT_LOG, or audit.jsonl in the user
+// config directory, so that it survives clearing the cache.
+func AuditLogPath() (string, error) {
+	if path := os.Getenv(auditLogEnvVar); path != "" {
+		return path, nil
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "duplo-jit", "audit.jsonl"), nil
+}
+
+// newAuditEntry describes credentials of the given kind, identified by their cache key.
+func newAuditEntry(kind, cacheKey string, fromCache bool, expiration string) *AuditEntry {
+	entry := &AuditEntry{
+		Timestamp:  time.Now().UTC().Format(time.RFC3339),
+		Kind:       kind,
+		Source:     "api",
+		Expiration: expiration,
+		Command:    cmdName(),
+		PID:        os.Getpid(),
+		CallerPID:  os.Getppid(),
+	}
+	if fromCache {
+		entry.Source = "cache"
+	}
+	entry.Caller = processName(entry.CallerPID)
+
+	// Cache keys are HOST,admin or HOST,duplo-ops or HOST,tenant,NAME or HOST,plan,ID.
+	parts := strings.Split(cacheKey, ",")
+	entry.Host = parts[0]
+	if hostname, _, err := net.SplitHostPort(entry.Host); err == nil {
+		entry.Host = hostname
+	}
+	if len(parts) > 1 {
+		entry.Role = parts[1]
+	}
+	if len(parts) > 2 {
+		switch entry.Role {
+		case "tenant":
+			entry.Tenant = parts[2]
+		case "plan":
+			entry.Plan = parts[2]
+		}
+	}
+	return entry
+}
+
+// processName returns the command name of a process, or an empty string if it is unknown.
+func processName(pid int) string {
+	if data, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid)); err == nil {
+		return strings.TrimSpace(string(data))
+	}
+	if runtime.GOOS == "windows" {
+		return ""
+	}
+	out, err := exec.Command("ps", "-o", "comm=", "-p", strconv.Itoa(pid)).Output()
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(strings.TrimSpace(string(out)))
+}
+
+// writeAuditEntry appends the entry to the audit log, rotating it first if it is too large.
+// Failures are logged, and do not prevent credentials from being issued.
+func writeAuditEntry(entry *AuditEntry) {
+	path, err := AuditLogPath()
+	if err == nil {
+		err = appendAuditEntry(path, entry)
+	}
+	if err != nil {
+		slog.Warn("unable to write to audit log", "file", path, "error", err)
+	}
+}
+
+func appendAuditEntry(path string, entry *AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	// Only one process at a time checks the size, rotates and appends, so that no log is rotated twice.
+	unlock, err := lockAuditLog(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if info, err := os.Stat(path); err == nil && info.Size()+int64(len(line)) > AuditLogMaxSize {
+		rotateAuditLog(path)
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(line)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// auditLockStale is the age of a lock file after which its process is assumed to have died.
+const auditLockStale = 10 * time.Second
+
+// lockAuditLog creates the lock file of the audit log, waiting for other processes to remove theirs.
+// It returns a function that removes the lock file.
+func lockAuditLog(path string) (func(), error) {
+	lockPath := path + ".lock"
+	deadline := time.Now().Add(2 * auditLockStale)
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			_ = f.Close()
+			return func() { _ = os.Remove(lockPath) }, nil
+		} else if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to create audit log lock: %w", err)
+		}
+
+		if info, err := os.Stat(lockPath); err == nil && time.Since(info.ModTime()) > auditLockStale {
+			breakStaleLock(lockPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for audit log lock %s", lockPath)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// breakStaleLock removes a stale lock file.  The lock file is moved out of the way first, and only then
+// checked, since another process may have replaced the stale lock file with its own in the meantime:
+// a lock file that is not stale is put back.
+func breakStaleLock(lockPath string) {
+	tmpPath := fmt.Sprintf("%s.stale.%d", lockPath, os.Getpid())
+	if os.Rename(lockPath, tmpPath) != nil {
+		return
+	}
+	defer func() { _ = os.Remove(tmpPath) }()
+
+	if info, err := os.Stat(tmpPath); err == nil && time.Since(info.ModTime()) <= auditLockStale {
+		// Put it back without replacing a lock file created since.
+		if err := os.Link(tmpPath, lockPath); err != nil && !errors.Is(err, os.ErrExist) {
+			_ = os.Rename(tmpPath, lockPath)
+		}
+	}
+}
+
+// rotateAuditLog renames the audit log to path.1, shifting older logs and dropping the oldest.
+func rotateAuditLog(path string) {
+	_ = os.Remove(fmt.Sprintf("%s.%d", path, AuditLogMaxBackups))
+	for i := AuditLogMaxBackups - 1; i >= 1; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", path, i), fmt.Sprintf("%s.%d", path, i+1))
+	}
+	if err := os.Rename(path, path+".1"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Warn("unable to rotate audit log", "file", path, "error", err)
+	}
+}
+
+// ReadAuditLog returns the audit entries since the given time, oldest first, including rotated logs.
+// If host is not empty, only entries for that host are returned.
+func ReadAuditLog(since time.Time, host string) ([]AuditEntry, error) {
+	path, err := AuditLogPath()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []AuditEntry
+	for i := AuditLogMaxBackups; i >= 0; i-- {
+		file := path
+		if i > 0 {
+			file = fmt.Sprintf("%s.%d", path, i)
+		}
+		if err := readAuditFile(file, since, host, &entries); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Timestamp < entries[j].Timestamp })
+	return entries, nil
+}
+
+func readAuditFile(file string, since time.Time, host string, entries *[]AuditEntry) error {
+	f, err := os.Open(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+			continue // a partial line, from a full disk or a crash
+		}
+		timestamp, err := time.Parse(time.RFC3339, entry.Timestamp)
+		if err != nil || timestamp.Before(since) || (host != "" && entry.Host != host) {
+			continue
+		}
+		*entries = append(*entries, entry)
+	}
+	return scanner.Err()
+}
+
+// OutputAudit writes the audit entries as a table or as JSON.
+func OutputAudit(entries []AuditEntry, format string) {
+	switch format {
+	case "", "table":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(w, "TIME\tHOST\tKIND\tROLE\tTENANT/PLAN\tSOURCE\tEXPIRES\tCALLER\tREASON")
+		for _, entry := range entries {
+			caller := fmt.Sprintf("%s (%d)", orDash(entry.Caller), entry.CallerPID)
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				entry.Timestamp, entry.Host, entry.Kind, orDash(entry.Role), orDash(entry.Tenant+entry.Plan),
+				entry.Source, orDash(entry.Expiration), caller, orDash(entry.Reason))
+		}
+		_ = w.Flush()
+
+	case "json":
+		if entries == nil {
+			entries = []AuditEntry{}
+		}
+		outputJSON(entries)
+
+	default:
+		Fatal(fmt.Sprintf("unsupported output format '%s'", format), nil)
+	}
+}
@@ -0,0 +1,219 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// setupTestAuditLog redirects the audit log to a temp file.
+func setupTestAuditLog(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	t.Setenv(auditLogEnvVar, path)
+	return path
+}
+
+func TestNewAuditEntry(t *testing.T) {
+	tests := []struct {
+		name      string
+		kind      string
+		cacheKey  string
+		fromCache bool
+		want      AuditEntry
+	}{
+		{"admin", "aws", "test.example.com,admin", false, AuditEntry{Host: "test.example.com", Kind: "aws", Role: "admin", Source: "api"}},
+		{"duplo-ops", "aws", "test.example.com,duplo-ops", true, AuditEntry{Host: "test.example.com", Kind: "aws", Role: "duplo-ops", Source: "cache"}},
+		{"tenant", "k8s", "test.example.com,tenant,dev", false, AuditEntry{Host: "test.example.com", Kind: "k8s", Role: "tenant", Tenant: "dev", Source: "api"}},
+		{"plan", "k8s", "test.example.com,plan,default", true, AuditEntry{Host: "test.example.com", Kind: "k8s", Role: "plan", Plan: "default", Source: "cache"}},
+		{"host with port", "aws", "test.example.com:8443,admin", false, AuditEntry{Host: "test.example.com", Kind: "aws", Role: "admin", Source: "api"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newAuditEntry(tt.kind, tt.cacheKey, tt.fromCache, "")
+			if got.Timestamp == "" || got.PID != os.Getpid() || got.CallerPID != os.Getppid() {
+				t.Errorf("missing timestamp or process info: %+v", got)
+			}
+			got.Timestamp, got.Command, got.PID, got.Caller, got.CallerPID = "", "", 0, "", 0
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("newAuditEntry() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadAuditLog(t *testing.T) {
+	path := setupTestAuditLog(t)
+	now := time.Now().UTC()
+
+	old := AuditEntry{Timestamp: now.Add(-48 * time.Hour).Format(time.RFC3339), Host: "a.example.com", Kind: "aws", Role: "admin", Source: "api"}
+	recent := AuditEntry{Timestamp: now.Add(-time.Hour).Format(time.RFC3339), Host: "a.example.com", Kind: "k8s", Role: "tenant", Tenant: "dev", Source: "cache"}
+	other := AuditEntry{Timestamp: now.Format(time.RFC3339), Host: "b.example.com", Kind: "duplo", Role: "admin", Source: "api"}
+	for _, entry := range []AuditEntry{old, recent, other} {
+		if err := appendAuditEntry(path, &entry); err != nil {
+			t.Fatalf("appendAuditEntry() error: %v", err)
+		}
+	}
+
+	// A partial line is skipped.
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	_, _ = f.WriteString(`{"Timestamp":"`)
+	_ = f.Close()
+
+	got, err := ReadAuditLog(now.Add(-24*time.Hour), "")
+	if err != nil {
+		t.Fatalf("ReadAuditLog() error: %v", err)
+	}
+	if !reflect.DeepEqual(got, []AuditEntry{recent, other}) {
+		t.Errorf("ReadAuditLog() = %+v", got)
+	}
+
+	got, _ = ReadAuditLog(time.Time{}, "a.example.com")
+	if !reflect.DeepEqual(got, []AuditEntry{old, recent}) {
+		t.Errorf("ReadAuditLog(host) = %+v", got)
+	}
+
+	info, _ := os.Stat(path)
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("expected audit log mode 0600, got %v", info.Mode().Perm())
+	}
+}
+
+func TestAppendAuditEntry_Rotates(t *testing.T) {
+	path := setupTestAuditLog(t)
+
+	// Entries that fill the log up to its size limit, and that are skipped when reading.
+	filler := bytes.Repeat(append(bytes.Repeat([]byte("x"), 1023), '\n'), AuditLogMaxSize/1024+1)
+
+	for i := 0; i <= AuditLogMaxBackups+1; i++ {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = f.Write(filler)
+		_ = f.Close()
+
+		entry := AuditEntry{Timestamp: time.Now().UTC().Format(time.RFC3339), Host: "a.example.com", Kind: "aws", Tenant: strconv.Itoa(i), Source: "api"}
+		if err := appendAuditEntry(path, &entry); err != nil {
+			t.Fatalf("appendAuditEntry() error: %v", err)
+		}
+	}
+
+	// The current log only has the last entry, and the oldest log was dropped.
+	data, _ := os.ReadFile(path)
+	if bytes.Count(data, []byte("\n")) != 1 {
+		t.Errorf("expected a single entry in the current log, got %q", data)
+	}
+	if _, err := os.Stat(fmt.Sprintf("%s.%d", path, AuditLogMaxBackups+1)); err == nil {
+		t.Error("expected no more than the maximum number of rotated logs")
+	}
+
+	got, err := ReadAuditLog(time.Time{}, "")
+	if err != nil {
+		t.Fatalf("ReadAuditLog() error: %v", err)
+	}
+	var tenants []string
+	for _, entry := range got {
+		tenants = append(tenants, entry.Tenant)
+	}
+	if want := []string{"1", "2", "3", "4"}; !reflect.DeepEqual(tenants, want) {
+		t.Errorf("expected entries %v, got %v", want, tenants)
+	}
+}
+
+func TestAppendAuditEntry_Concurrent(t *testing.T) {
+	path := setupTestAuditLog(t)
+
+	// A full log, that the first append rotates.
+	filler := bytes.Repeat(append(bytes.Repeat([]byte("x"), 1023), '\n'), AuditLogMaxSize/1024+1)
+	if err := os.WriteFile(path, filler, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	const appends = 20
+	var wg sync.WaitGroup
+	for i := 0; i < appends; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			entry := AuditEntry{Timestamp: time.Now().UTC().Format(time.RFC3339), Host: "a.example.com", Kind: "aws", Tenant: strconv.Itoa(i), Source: "api"}
+			if err := appendAuditEntry(path, &entry); err != nil {
+				t.Errorf("appendAuditEntry() error: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// The log was rotated once, keeping every entry.
+	if data, _ := os.ReadFile(path + ".1"); !bytes.Equal(data, filler) {
+		t.Errorf("expected the full log to be rotated to %s.1", path)
+	}
+	if _, err := os.Stat(path + ".2"); err == nil {
+		t.Error("expected the log to be rotated once")
+	}
+	if _, err := os.Stat(path + ".lock"); err == nil {
+		t.Error("expected the lock file to be removed")
+	}
+	got, err := ReadAuditLog(time.Time{}, "")
+	if err != nil {
+		t.Fatalf("ReadAuditLog() error: %v", err)
+	}
+	if len(got) != appends {
+		t.Errorf("expected %d entries, got %d", appends, len(got))
+	}
+}
+
+func TestLockAuditLog_Stale(t *testing.T) {
+	path := setupTestAuditLog(t)
+
+	// A lock file left by a process that died is broken.
+	if err := os.WriteFile(path+".lock", nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	stale := time.Now().Add(-2 * auditLockStale)
+	if err := os.Chtimes(path+".lock", stale, stale); err != nil {
+		t.Fatal(err)
+	}
+
+	unlock, err := lockAuditLog(path)
+	if err != nil {
+		t.Fatalf("lockAuditLog() error: %v", err)
+	}
+	if info, err := os.Stat(path + ".lock"); err != nil || info.ModTime().Before(time.Now().Add(-auditLockStale)) {
+		t.Errorf("expected a new lock file, got %v, %v", info, err)
+	}
+	unlock()
+}
+
+func TestBreakStaleLock_KeepsLiveLock(t *testing.T) {
+	path := setupTestAuditLog(t)
+	lockPath := path + ".lock"
+
+	// A live lock file, such as one created after another process found the previous one stale.
+	if err := os.WriteFile(lockPath, []byte("live"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	breakStaleLock(lockPath)
+	if data, err := os.ReadFile(lockPath); err != nil || string(data) != "live" {
+		t.Errorf("expected the live lock file to be kept, got %q, %v", data, err)
+	}
+
+	// A stale lock file is removed.
+	stale := time.Now().Add(-2 * auditLockStale)
+	if err := os.Chtimes(lockPath, stale, stale); err != nil {
+		t.Fatal(err)
+	}
+	breakStaleLock(lockPath)
+	if _, err := os.Stat(lockPath); !os.IsNotExist(err) {
+		t.Errorf("expected the stale lock file to be removed, got %v", err)
+	}
+	if matches, _ := filepath.Glob(lockPath + ".stale.*"); len(matches) != 0 {
+		t.Errorf("expected no leftover files, got %v", matches)
+	}
+}
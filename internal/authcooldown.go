@@ -20,6 +20,7 @@ type authCooldownInfo struct {
 	Timestamp time.Time `json:"timestamp"`
 	Port      int       `json:"port"`
 	Admin     bool      `json:"admin"`
+	State     string    `json:"state,omitempty"`
 }
 
 // IsAuthCooldownEnabled checks the DUPLO_JIT_AUTH_COOLDOWN environment variable.
@@ -47,48 +48,45 @@ func IsAuthCooldownEnabled() (time.Duration, bool) {
 	return d, true
 }
 
-// authCooldownPath returns the path to the cooldown file for the given host URL
-// and admin flag. Cooldown files live in ~/.cache/duplo-jit-auth/.
-func authCooldownPath(host string, admin bool) (string, error) {
+// authCooldownPath returns the path to the cooldown file for the given host URL.  Admin and tenant
+// logins share it, since an admin token also covers tenant requests.  Cooldown files live in
+// ~/.cache/duplo-jit-auth/.
+func authCooldownPath(host string) (string, error) {
 	cacheDir, err := os.UserCacheDir()
 	if err != nil {
 		return "", fmt.Errorf("failed to get user cache dir: %w", err)
 	}
 
-	dir := filepath.Join(cacheDir, "duplo-jit-auth")
+	dir := filepath.Join(cacheDir, authCooldownDir)
 	if err := os.MkdirAll(dir, 0o700); err != nil {
 		return "", fmt.Errorf("failed to create auth cooldown dir: %w", err)
 	}
 
-	hostname := GetHostCacheKey(host)
-	suffix := ".cooldown"
-	if admin {
-		suffix = ".admin.cooldown"
-	}
-	return filepath.Join(dir, hostname+suffix), nil
+	return filepath.Join(dir, GetHostCacheKey(host)+".cooldown"), nil
 }
 
-// TrySetAuthCooldown atomically creates a cooldown file for the given host and admin flag.
+// TrySetAuthCooldown atomically creates a cooldown file for the given host, recording the admin flag.
 // Returns (true, zero, nil) if the cooldown was set (caller should open the browser),
 // (false, expiry, nil) if a recent cooldown is already active,
 // or (false, zero, err) on unexpected errors.
 //
 // Stale cooldowns (older than cooldownDuration) are automatically replaced.
-func TrySetAuthCooldown(host string, port int, admin bool, cooldownDuration time.Duration) (bool, time.Time, error) {
-	cooldownPath, err := authCooldownPath(host, admin)
+func TrySetAuthCooldown(host string, port int, admin bool, state string, cooldownDuration time.Duration) (bool, time.Time, error) {
+	cooldownPath, err := authCooldownPath(host)
 	if err != nil {
 		return false, time.Time{}, err
 	}
 
-	return trySetCooldown(cooldownPath, port, admin, cooldownDuration, true)
+	return trySetCooldown(cooldownPath, port, admin, state, cooldownDuration, true)
 }
 
-func trySetCooldown(cooldownPath string, port int, admin bool, cooldownDuration time.Duration, retryOnStale bool) (bool, time.Time, error) {
+func trySetCooldown(cooldownPath string, port int, admin bool, state string, cooldownDuration time.Duration, retryOnStale bool) (bool, time.Time, error) {
 	info := authCooldownInfo{
 		PID:       os.Getpid(),
 		Timestamp: time.Now(),
 		Port:      port,
 		Admin:     admin,
+		State:     state,
 	}
 	data, err := json.Marshal(info)
 	if err != nil {
@@ -110,7 +108,7 @@ func trySetCooldown(cooldownPath string, port int, admin bool, cooldownDuration
 			if os.Rename(cooldownPath, tmpPath) == nil {
 				_ = os.Remove(tmpPath)
 			}
-			return trySetCooldown(cooldownPath, port, admin, cooldownDuration, false)
+			return trySetCooldown(cooldownPath, port, admin, state, cooldownDuration, false)
 		}
 
 		var expiry time.Time
@@ -141,10 +139,10 @@ func readInfoFromPath(path string) *authCooldownInfo {
 	return &info
 }
 
-// ReadCooldownInfo reads the cooldown file for the given host and admin flag.
+// ReadCooldownInfo reads the cooldown file for the given host.
 // Returns nil if no cooldown file exists or it cannot be read.
-func ReadCooldownInfo(host string, admin bool) *authCooldownInfo {
-	cooldownPath, err := authCooldownPath(host, admin)
+func ReadCooldownInfo(host string) *authCooldownInfo {
+	cooldownPath, err := authCooldownPath(host)
 	if err != nil {
 		return nil
 	}
@@ -152,20 +150,22 @@ func ReadCooldownInfo(host string, admin bool) *authCooldownInfo {
 }
 
 // UpdateCooldown rewrites the cooldown file with the current PID and the given port,
-// preserving the original timestamp. The timestamp represents when the browser tab
-// was opened and should only be reset when a new tab is actually opened (via
+// preserving the original timestamp and callback state. The timestamp represents when the
+// browser tab was opened and should only be reset when a new tab is actually opened (via
 // TrySetAuthCooldown). Used when a relay process takes over a dead process's port.
 func UpdateCooldown(host string, admin bool, port int) error {
-	cooldownPath, err := authCooldownPath(host, admin)
+	cooldownPath, err := authCooldownPath(host)
 	if err != nil {
 		return err
 	}
 
-	// Read existing timestamp from the cooldown file.
-	existing := ReadCooldownInfo(host, admin)
+	// Read existing timestamp and state from the cooldown file.
+	existing := ReadCooldownInfo(host)
 	ts := time.Now()
+	state := ""
 	if existing != nil {
 		ts = existing.Timestamp
+		state = existing.State
 	}
 
 	info := authCooldownInfo{
@@ -173,6 +173,7 @@ func UpdateCooldown(host string, admin bool, port int) error {
 		Timestamp: ts,
 		Port:      port,
 		Admin:     admin,
+		State:     state,
 	}
 	data, err := json.Marshal(info)
 	if err != nil {
@@ -181,10 +182,19 @@ func UpdateCooldown(host string, admin bool, port int) error {
 	return os.WriteFile(cooldownPath, data, 0o600)
 }
 
-// ClearAuthCooldown removes the cooldown file for the given host and admin flag.
+// ReleaseAuthCooldown removes the cooldown file for the given host, but only
+// if it is held by the current process. Called when authentication is canceled.
+func ReleaseAuthCooldown(host string) {
+	info := ReadCooldownInfo(host)
+	if info != nil && info.PID == os.Getpid() {
+		ClearAuthCooldown(host)
+	}
+}
+
+// ClearAuthCooldown removes the cooldown file for the given host.
 // Called after successful authentication. No-op if the file doesn't exist.
-func ClearAuthCooldown(host string, admin bool) {
-	cooldownPath, err := authCooldownPath(host, admin)
+func ClearAuthCooldown(host string) {
+	cooldownPath, err := authCooldownPath(host)
 	if err != nil {
 		return
 	}
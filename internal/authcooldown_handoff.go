@@ -0,0 +1,187 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxUnixSocketPath is the shortest limit on Unix socket paths among supported systems (macOS).
+const maxUnixSocketPath = 103
+
+// tokenHandoffMessage is sent by the cooldown holder to each waiting process.
+type tokenHandoffMessage struct {
+	Token string `json:"token,omitempty"`
+	Admin bool   `json:"admin,omitempty"`
+	OTP   string `json:"otp,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// tokenHandoff is a local Unix socket, where processes waiting on the cooldown holder
+// subscribe to the result of its interactive login.
+type tokenHandoff struct {
+	listener net.Listener
+	path     string
+	admin    bool
+
+	mu     sync.Mutex
+	conns  []net.Conn
+	closed bool
+}
+
+// authHandoffPath returns the path of the hand-off socket, next to the cooldown file.
+// Paths that would be too long for a Unix socket use a hash of the host instead.
+func authHandoffPath(host string) (string, error) {
+	cooldownPath, err := authCooldownPath(host)
+	if err != nil {
+		return "", err
+	}
+
+	path := strings.TrimSuffix(cooldownPath, ".cooldown") + ".sock"
+	if len(path) > maxUnixSocketPath {
+		sum := sha256.Sum256([]byte(filepath.Base(path)))
+		path = filepath.Join(filepath.Dir(path), hex.EncodeToString(sum[:8])+".sock")
+	}
+	if len(path) > maxUnixSocketPath {
+		return "", fmt.Errorf("auth hand-off socket path is too long: %s", path)
+	}
+	return path, nil
+}
+
+// startTokenHandoff starts accepting waiting processes, for a login with the given admin flag.  It
+// returns nil if the socket cannot be created, in which case waiters fall back to watching the holder's PID.
+func startTokenHandoff(host string, admin bool) *tokenHandoff {
+	path, err := authHandoffPath(host)
+	if err != nil {
+		slog.Debug("auth cooldown: hand-off disabled", "error", err)
+		return nil
+	}
+
+	// We hold the cooldown, so any existing socket belongs to a dead process.
+	_ = os.Remove(path)
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		slog.Debug("auth cooldown: hand-off disabled", "error", err)
+		return nil
+	}
+
+	h := &tokenHandoff{listener: listener, path: path, admin: admin}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			h.mu.Lock()
+			if h.closed {
+				_ = conn.Close()
+			} else {
+				h.conns = append(h.conns, conn)
+			}
+			h.mu.Unlock()
+		}
+	}()
+	return h
+}
+
+// publish sends the result to every waiting process, then closes the hand-off.
+func (h *tokenHandoff) publish(result TokenResult) {
+	if h == nil {
+		return
+	}
+
+	msg := tokenHandoffMessage{Token: result.Token, Admin: h.admin, OTP: result.OTP}
+	if result.err != nil {
+		msg = tokenHandoffMessage{Error: result.err.Error()}
+	}
+	data, err := json.Marshal(msg)
+	DieIf(err, "cannot marshal to JSON")
+	data = append(data, '\n')
+
+	h.mu.Lock()
+	conns := h.conns
+	h.conns = nil
+	h.mu.Unlock()
+
+	for _, conn := range conns {
+		_ = conn.SetWriteDeadline(time.Now().Add(time.Second))
+		_, _ = conn.Write(data)
+	}
+	if len(conns) > 0 {
+		slog.Info("auth cooldown: handed off result to waiting processes", "count", len(conns))
+	}
+	h.close()
+}
+
+// close stops the hand-off without a result, so that waiting processes start over.
+func (h *tokenHandoff) close() {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	h.closed = true
+	for _, conn := range h.conns {
+		_ = conn.Close()
+	}
+	h.conns = nil
+	_ = h.listener.Close()
+	_ = os.Remove(h.path)
+}
+
+// subscribeTokenHandoff waits for the cooldown holder to hand off its result.  It returns false if
+// there is no hand-off socket, if the holder went away without a result, or if an admin token is
+// needed and the holder's login was not an admin login.
+func subscribeTokenHandoff(ctx context.Context, host string, admin bool, timeout time.Duration) (*TokenResult, bool) {
+	path, err := authHandoffPath(host)
+	if err != nil {
+		return nil, false
+	}
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err != nil {
+		return nil, false
+	}
+	defer func() { _ = conn.Close() }()
+
+	// Stop reading when the context is canceled.
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if ctx.Err() != nil {
+		return &TokenResult{err: ctx.Err()}, true
+	}
+	if err != nil {
+		return nil, false
+	}
+
+	var msg tokenHandoffMessage
+	if err := json.Unmarshal(line, &msg); err != nil {
+		return nil, false
+	}
+	if msg.Error != "" {
+		return &TokenResult{err: errors.New("interactive login by another process failed: " + msg.Error)}, true
+	}
+	if msg.Token == "" || (admin && !msg.Admin) {
+		return nil, false
+	}
+
+	slog.Info("auth cooldown: received token from active auth process", "host", GetHostCacheKey(host))
+	return &TokenResult{Token: msg.Token, OTP: msg.OTP}, true
+}
@@ -0,0 +1,168 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mustStartTokenHandoff starts a hand-off, and waits for the given number of subscribers.
+func mustStartTokenHandoff(t *testing.T, host string, admin bool, subscribers int, subscribe func()) *tokenHandoff {
+	t.Helper()
+	h := startTokenHandoff(host, admin)
+	if h == nil {
+		t.Fatal("expected hand-off to start")
+	}
+	t.Cleanup(h.close)
+
+	for i := 0; i < subscribers; i++ {
+		go subscribe()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		h.mu.Lock()
+		n := len(h.conns)
+		h.mu.Unlock()
+		if n == subscribers {
+			return h
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d subscribers, got %d", subscribers, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTokenHandoff_PublishToAllSubscribers(t *testing.T) {
+	host := setupTestHost(t)
+
+	var wg sync.WaitGroup
+	results := make(chan *TokenResult, 3)
+	wg.Add(3)
+	h := mustStartTokenHandoff(t, host, false, 3, func() {
+		defer wg.Done()
+		result, ok := subscribeTokenHandoff(context.Background(), host, false, 5*time.Second)
+		if !ok {
+			result = nil
+		}
+		results <- result
+	})
+
+	h.publish(TokenResult{Token: "test-token", OTP: "123456"})
+	wg.Wait()
+	close(results)
+
+	for result := range results {
+		if result == nil {
+			t.Fatal("expected a result")
+		}
+		if result.err != nil || result.Token != "test-token" || result.OTP != "123456" {
+			t.Errorf("unexpected result: %+v", result)
+		}
+	}
+
+	// The socket is removed once the result is handed off.
+	path, _ := authHandoffPath(host)
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected socket to be removed, got %v", err)
+	}
+}
+
+func TestTokenHandoff_PublishFailure(t *testing.T) {
+	host := setupTestHost(t)
+
+	results := make(chan *TokenResult, 1)
+	h := mustStartTokenHandoff(t, host, false, 1, func() {
+		result, _ := subscribeTokenHandoff(context.Background(), host, false, 5*time.Second)
+		results <- result
+	})
+
+	h.publish(TokenResult{err: errors.New("timed out")})
+	result := <-results
+	if result == nil || result.err == nil || !strings.Contains(result.err.Error(), "timed out") {
+		t.Errorf("expected failure to be handed off, got %+v", result)
+	}
+}
+
+func TestTokenHandoff_ClosedWithoutResult(t *testing.T) {
+	host := setupTestHost(t)
+
+	oks := make(chan bool, 1)
+	h := mustStartTokenHandoff(t, host, false, 1, func() {
+		_, ok := subscribeTokenHandoff(context.Background(), host, false, 5*time.Second)
+		oks <- ok
+	})
+
+	h.close()
+	if <-oks {
+		t.Error("expected no result when the holder closes without one")
+	}
+}
+
+func TestSubscribeTokenHandoff_NoHolder(t *testing.T) {
+	host := setupTestHost(t)
+
+	if _, ok := subscribeTokenHandoff(context.Background(), host, false, time.Second); ok {
+		t.Error("expected no result without a holder")
+	}
+}
+
+func TestSubscribeTokenHandoff_Canceled(t *testing.T) {
+	host := setupTestHost(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	results := make(chan *TokenResult, 1)
+	mustStartTokenHandoff(t, host, false, 1, func() {
+		result, _ := subscribeTokenHandoff(ctx, host, false, 5*time.Second)
+		results <- result
+	})
+
+	cancel()
+	result := <-results
+	if result == nil || !errors.Is(result.err, context.Canceled) {
+		t.Errorf("expected canceled result, got %+v", result)
+	}
+}
+
+func TestAuthHandoffPath_Long(t *testing.T) {
+	setupTestHost(t)
+
+	host := "https://" + strings.Repeat("a", 200) + ".example.com"
+	path, err := authHandoffPath(host)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(path) > maxUnixSocketPath || !strings.HasSuffix(path, ".sock") {
+		t.Errorf("unexpected path: %s", path)
+	}
+}
+
+func TestTokenHandoff_AdminScope(t *testing.T) {
+	host := setupTestHost(t)
+
+	// A tenant login accepts the token of an admin login.
+	results := make(chan *TokenResult, 1)
+	h := mustStartTokenHandoff(t, host, true, 1, func() {
+		result, _ := subscribeTokenHandoff(context.Background(), host, false, 5*time.Second)
+		results <- result
+	})
+	h.publish(TokenResult{Token: "admin-token"})
+	if result := <-results; result == nil || result.err != nil || result.Token != "admin-token" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	// An admin login does not accept the token of a tenant login.
+	oks := make(chan bool, 1)
+	h = mustStartTokenHandoff(t, host, false, 1, func() {
+		_, ok := subscribeTokenHandoff(context.Background(), host, true, 5*time.Second)
+		oks <- ok
+	})
+	h.publish(TokenResult{Token: "tenant-token"})
+	if <-oks {
+		t.Error("expected an admin login to ignore the token of a tenant login")
+	}
+}
@@ -1,8 +1,9 @@
 package internal
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"time"
 )
@@ -10,51 +11,58 @@ import (
 // checkCooldownBeforeListen evaluates existing cooldown state and decides how to proceed.
 // Returns the port to listen on, whether to open a browser, the adjusted timeout, and
 // an optional early result (non-nil means the caller should return immediately).
-func checkCooldownBeforeListen(baseUrl string, admin bool, cmd string, defaultPort int, cooldownDuration time.Duration) (listenPort int, openBrowser bool, timeout time.Duration, earlyResult *TokenResult) {
+func checkCooldownBeforeListen(ctx context.Context, baseUrl string, admin bool, cmd string, defaultPort int, cooldownDuration time.Duration) (listenPort int, openBrowser bool, timeout time.Duration, earlyResult *TokenResult) {
 	listenPort = defaultPort
 	openBrowser = true
 	timeout = cooldownDuration
 
-	info := ReadCooldownInfo(baseUrl, admin)
+	info := ReadCooldownInfo(baseUrl)
 	if info == nil {
 		return
 	}
 
 	remaining := cooldownDuration - time.Since(info.Timestamp)
 	if remaining <= 0 {
-		ClearAuthCooldown(baseUrl, admin)
+		ClearAuthCooldown(baseUrl)
 		return
 	}
 
 	if IsPidAlive(info.PID) {
-		result := waitForCooldownHolder(baseUrl, admin, cmd, defaultPort, info, cooldownDuration)
+		result := waitForCooldownHolder(ctx, baseUrl, admin, cmd, defaultPort, info, cooldownDuration)
 		return defaultPort, true, cooldownDuration, &result
 	}
 
-	log.Printf("auth cooldown: previous process (PID %d) is dead, attempting relay on port %d", info.PID, info.Port)
+	// Only relay a browser tab of the same kind of login, so that its token has the scope we need.
+	if info.Admin != admin {
+		slog.Info("auth cooldown: previous process is dead, starting over", "holderPid", info.PID, "holderAdmin", info.Admin)
+		ClearAuthCooldown(baseUrl)
+		return
+	}
+
+	slog.Info("auth cooldown: previous process is dead, attempting relay", "holderPid", info.PID, "port", info.Port)
 	return info.Port, false, remaining, nil
 }
 
 // recoverRelayBindFailure handles the case where binding the relay port failed.
 // Re-checks whether another relay process took over, or resets for a fresh start.
-func recoverRelayBindFailure(baseUrl string, admin bool, cmd string, defaultPort int, relayPort int, cooldownDuration time.Duration) TokenResult {
-	info := ReadCooldownInfo(baseUrl, admin)
+func recoverRelayBindFailure(ctx context.Context, baseUrl string, admin bool, cmd string, defaultPort int, relayPort int, cooldownDuration time.Duration) TokenResult {
+	info := ReadCooldownInfo(baseUrl)
 	if info != nil && IsPidAlive(info.PID) {
-		return waitForCooldownHolder(baseUrl, admin, cmd, defaultPort, info, cooldownDuration)
+		return waitForCooldownHolder(ctx, baseUrl, admin, cmd, defaultPort, info, cooldownDuration)
 	}
-	log.Printf("auth cooldown: port %d unavailable, resetting cooldown", relayPort)
-	ClearAuthCooldown(baseUrl, admin)
-	if result := cachedTokenResult(baseUrl); result != nil {
+	slog.Info("auth cooldown: port unavailable, resetting cooldown", "port", relayPort)
+	ClearAuthCooldown(baseUrl)
+	if result := cachedTokenResult(baseUrl, admin); result != nil {
 		return *result
 	}
-	return TokenViaListener(baseUrl, admin, cmd, defaultPort, 180*time.Second)
+	return TokenViaListener(ctx, baseUrl, admin, cmd, defaultPort, 180*time.Second)
 }
 
 // acquireOrUpdateCooldown atomically sets a new cooldown (fresh start) or updates
 // an existing one (relay). Returns non-nil if the caller should return early.
-func acquireOrUpdateCooldown(baseUrl string, admin bool, cmd string, defaultPort int, localPort int, openBrowser bool, cooldownDuration time.Duration, listener net.Listener) *TokenResult {
+func acquireOrUpdateCooldown(ctx context.Context, baseUrl string, admin bool, cmd string, defaultPort int, localPort int, state string, openBrowser bool, cooldownDuration time.Duration, listener net.Listener) *TokenResult {
 	if openBrowser {
-		acquired, expiry, cooldownErr := TrySetAuthCooldown(baseUrl, localPort, admin, cooldownDuration)
+		acquired, expiry, cooldownErr := TrySetAuthCooldown(baseUrl, localPort, admin, state, cooldownDuration)
 		if cooldownErr != nil {
 			_ = listener.Close()
 			result := TokenResult{err: fmt.Errorf("auth cooldown error: %w", cooldownErr)}
@@ -62,9 +70,9 @@ func acquireOrUpdateCooldown(baseUrl string, admin bool, cmd string, defaultPort
 		}
 		if !acquired {
 			_ = listener.Close()
-			info := ReadCooldownInfo(baseUrl, admin)
+			info := ReadCooldownInfo(baseUrl)
 			if info != nil {
-				result := waitForCooldownHolder(baseUrl, admin, cmd, defaultPort, info, cooldownDuration)
+				result := waitForCooldownHolder(ctx, baseUrl, admin, cmd, defaultPort, info, cooldownDuration)
 				return &result
 			}
 			result := TokenResult{err: fmt.Errorf(
@@ -75,43 +83,59 @@ func acquireOrUpdateCooldown(baseUrl string, admin bool, cmd string, defaultPort
 		}
 	} else {
 		if err := UpdateCooldown(baseUrl, admin, localPort); err != nil {
-			log.Printf("auth cooldown: failed to update cooldown for relay: %v", err)
+			slog.Warn("auth cooldown: failed to update cooldown for relay", "error", err)
 		}
 	}
 	return nil
 }
 
-// cachedTokenResult returns a TokenResult from the credential cache, or nil if unavailable.
-func cachedTokenResult(baseUrl string) *TokenResult {
-	token := CacheGetDuploTokenUnchecked(baseUrl)
+// cachedTokenResult returns a TokenResult from the credential cache, or nil if unavailable.  For
+// tenant logins, an admin token cached by an admin login will do.
+func cachedTokenResult(baseUrl string, admin bool) *TokenResult {
+	token := CacheGetDuploTokenUnchecked(baseUrl, admin)
 	if token == "" {
 		return nil
 	}
-	log.Printf("auth cooldown: using cached credentials from completed auth process for %s", GetHostCacheKey(baseUrl))
+	slog.Info("auth cooldown: using cached credentials from completed auth process", "host", GetHostCacheKey(baseUrl))
 	return &TokenResult{Token: token}
 }
 
 // waitForCooldownHolder waits for the active cooldown holder to finish, then retries.
-func waitForCooldownHolder(baseUrl string, admin bool, cmd string, defaultPort int, info *authCooldownInfo, cooldownDuration time.Duration) TokenResult {
+func waitForCooldownHolder(ctx context.Context, baseUrl string, admin bool, cmd string, defaultPort int, info *authCooldownInfo, cooldownDuration time.Duration) TokenResult {
 	remaining := cooldownDuration - time.Since(info.Timestamp)
 	if remaining <= 0 {
 		// Cooldown expired while we were checking — check cache before retrying.
-		ClearAuthCooldown(baseUrl, admin)
-		if result := cachedTokenResult(baseUrl); result != nil {
+		ClearAuthCooldown(baseUrl)
+		if result := cachedTokenResult(baseUrl, admin); result != nil {
 			return *result
 		}
-		return TokenViaListener(baseUrl, admin, cmd, defaultPort, 180*time.Second)
+		return TokenViaListener(ctx, baseUrl, admin, cmd, defaultPort, 180*time.Second)
 	}
 
-	log.Printf("auth cooldown: waiting for active auth process (PID %d, port %d) to complete (up to %s)",
-		info.PID, info.Port, remaining.Truncate(time.Second))
+	slog.Info("auth cooldown: waiting for active auth process to complete",
+		"holderPid", info.PID, "port", info.Port, "timeout", remaining.Truncate(time.Second))
 
-	if WaitForPidExit(info.PID, remaining, 500*time.Millisecond) {
+	// Get the result as soon as the holder has it, if it accepts subscribers.  The token of a tenant
+	// login will not do for an admin login, which waits for the holder to finish and then starts over.
+	deadline := time.Now().Add(remaining)
+	if !admin || info.Admin {
+		if result, ok := subscribeTokenHandoff(ctx, baseUrl, admin, remaining); ok {
+			return *result
+		}
+	}
+	remaining = time.Until(deadline)
+
+	if WaitForPidExitContext(ctx, info.PID, remaining, 500*time.Millisecond) {
 		// Holder finished — use cached credentials if available, otherwise retry.
-		if result := cachedTokenResult(baseUrl); result != nil {
+		if result := cachedTokenResult(baseUrl, admin); result != nil {
 			return *result
 		}
-		return TokenViaListener(baseUrl, admin, cmd, defaultPort, 180*time.Second)
+		return TokenViaListener(ctx, baseUrl, admin, cmd, defaultPort, 180*time.Second)
+	}
+
+	// Canceled while waiting.
+	if ctx.Err() != nil {
+		return TokenResult{err: ctx.Err()}
 	}
 
 	// Timed out waiting.
@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"context"
 	"net"
 	"os"
 	"testing"
@@ -10,7 +11,7 @@ import (
 func TestCheckCooldownBeforeListen_NoCooldown(t *testing.T) {
 	host := setupTestHost(t)
 
-	port, browser, timeout, result := checkCooldownBeforeListen(host, false, "test", 0, 60*time.Minute)
+	port, browser, timeout, result := checkCooldownBeforeListen(context.Background(), host, false, "test", 0, 60*time.Minute)
 	if result != nil {
 		t.Fatal("expected no early result")
 	}
@@ -32,7 +33,7 @@ func TestCheckCooldownBeforeListen_ExpiredCooldown(t *testing.T) {
 	// Create expired cooldown file.
 	writeFakeCooldown(t, host, false, 99999, 54321, time.Now().Add(-cooldownDuration-time.Second))
 
-	port, browser, timeout, result := checkCooldownBeforeListen(host, false, "test", 0, cooldownDuration)
+	port, browser, timeout, result := checkCooldownBeforeListen(context.Background(), host, false, "test", 0, cooldownDuration)
 	if result != nil {
 		t.Fatal("expected no early result for expired cooldown")
 	}
@@ -47,7 +48,7 @@ func TestCheckCooldownBeforeListen_ExpiredCooldown(t *testing.T) {
 	}
 
 	// Verify cooldown was cleared.
-	if ReadCooldownInfo(host, false) != nil {
+	if ReadCooldownInfo(host) != nil {
 		t.Error("expected cooldown to be cleared after expiry")
 	}
 }
@@ -59,7 +60,7 @@ func TestCheckCooldownBeforeListen_DeadPid(t *testing.T) {
 	// Create cooldown with a PID that is almost certainly not running.
 	writeFakeCooldown(t, host, false, 2147483647, 54321, time.Now().Add(-10*time.Minute))
 
-	port, browser, timeout, result := checkCooldownBeforeListen(host, false, "test", 0, cooldownDuration)
+	port, browser, timeout, result := checkCooldownBeforeListen(context.Background(), host, false, "test", 0, cooldownDuration)
 	if result != nil {
 		t.Fatal("expected no early result for dead PID relay")
 	}
@@ -74,23 +75,26 @@ func TestCheckCooldownBeforeListen_DeadPid(t *testing.T) {
 	}
 }
 
-func TestCheckCooldownBeforeListen_AdminIndependent(t *testing.T) {
+func TestCheckCooldownBeforeListen_AdminDoesNotRelayTenant(t *testing.T) {
 	host := setupTestHost(t)
 	cooldownDuration := 60 * time.Minute
 
 	// Create non-admin cooldown with dead PID.
 	writeFakeCooldown(t, host, false, 2147483647, 54321, time.Now().Add(-10*time.Minute))
 
-	// Admin check should see no cooldown.
-	port, browser, _, result := checkCooldownBeforeListen(host, true, "test", 0, cooldownDuration)
+	// Admin check should start over, since the tab of a tenant login cannot give an admin token.
+	port, browser, _, result := checkCooldownBeforeListen(context.Background(), host, true, "test", 0, cooldownDuration)
 	if result != nil {
-		t.Fatal("expected no early result for admin (independent cooldown)")
+		t.Fatal("expected no early result for admin")
 	}
 	if port != 0 {
 		t.Errorf("expected default port for admin, got %d", port)
 	}
 	if !browser {
-		t.Error("expected openBrowser=true for admin (no cooldown)")
+		t.Error("expected openBrowser=true for admin")
+	}
+	if ReadCooldownInfo(host) != nil {
+		t.Error("expected the tenant cooldown to be cleared")
 	}
 }
 
@@ -105,13 +109,13 @@ func TestAcquireOrUpdateCooldown_FreshStart(t *testing.T) {
 	defer func() { _ = listener.Close() }()
 	localPort := listener.Addr().(*net.TCPAddr).Port
 
-	result := acquireOrUpdateCooldown(host, false, "test", 0, localPort, true, cooldownDuration, listener)
+	result := acquireOrUpdateCooldown(context.Background(), host, false, "test", 0, localPort, "test-state", true, cooldownDuration, listener)
 	if result != nil {
 		t.Fatalf("expected nil result for fresh start, got err: %v", result.err)
 	}
 
 	// Verify cooldown was created.
-	info := ReadCooldownInfo(host, false)
+	info := ReadCooldownInfo(host)
 	if info == nil {
 		t.Fatal("expected cooldown info to exist after fresh start")
 	}
@@ -121,6 +125,9 @@ func TestAcquireOrUpdateCooldown_FreshStart(t *testing.T) {
 	if info.Port != localPort {
 		t.Errorf("expected port %d, got %d", localPort, info.Port)
 	}
+	if info.State != "test-state" {
+		t.Errorf("expected state %q, got %q", "test-state", info.State)
+	}
 }
 
 func TestAcquireOrUpdateCooldown_RelayUpdate(t *testing.T) {
@@ -129,7 +136,7 @@ func TestAcquireOrUpdateCooldown_RelayUpdate(t *testing.T) {
 
 	// Create initial cooldown (simulating a previous process).
 	mustSetCooldown(t, host, 8080, false, cooldownDuration)
-	originalInfo := ReadCooldownInfo(host, false)
+	originalInfo := ReadCooldownInfo(host)
 	if originalInfo == nil {
 		t.Fatal("expected initial cooldown info")
 	}
@@ -142,13 +149,13 @@ func TestAcquireOrUpdateCooldown_RelayUpdate(t *testing.T) {
 	localPort := listener.Addr().(*net.TCPAddr).Port
 
 	// Relay path: openBrowser=false.
-	result := acquireOrUpdateCooldown(host, false, "test", 0, localPort, false, cooldownDuration, listener)
+	result := acquireOrUpdateCooldown(context.Background(), host, false, "test", 0, localPort, "", false, cooldownDuration, listener)
 	if result != nil {
 		t.Fatalf("expected nil result for relay, got err: %v", result.err)
 	}
 
 	// Verify cooldown was updated with new PID and port but preserved timestamp.
-	info := ReadCooldownInfo(host, false)
+	info := ReadCooldownInfo(host)
 	if info == nil {
 		t.Fatal("expected cooldown info after relay update")
 	}
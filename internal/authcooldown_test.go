@@ -58,7 +58,7 @@ func TestTrySetAuthCooldown_SecondSetBlocked(t *testing.T) {
 	host := setupTestHost(t)
 	mustSetCooldown(t, host, 8080, false, 60*time.Minute)
 
-	ok, _, err := TrySetAuthCooldown(host, 9090, false, 60*time.Minute)
+	ok, _, err := TrySetAuthCooldown(host, 9090, false, "", 60*time.Minute)
 	if err != nil {
 		t.Fatalf("unexpected error on second set: %v", err)
 	}
@@ -74,7 +74,7 @@ func TestTrySetAuthCooldown_StaleCooldownReplaced(t *testing.T) {
 	// Create a stale cooldown file manually.
 	writeFakeCooldown(t, host, false, 99999, 7777, time.Now().Add(-cooldownDuration-time.Second))
 
-	ok, _, err := TrySetAuthCooldown(host, 8080, false, cooldownDuration)
+	ok, _, err := TrySetAuthCooldown(host, 8080, false, "", cooldownDuration)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -83,7 +83,7 @@ func TestTrySetAuthCooldown_StaleCooldownReplaced(t *testing.T) {
 	}
 
 	// Verify the new cooldown has our PID.
-	info := ReadCooldownInfo(host, false)
+	info := ReadCooldownInfo(host)
 	if info.PID != os.Getpid() {
 		t.Fatalf("expected PID %d, got %d", os.Getpid(), info.PID)
 	}
@@ -93,12 +93,12 @@ func TestClearAuthCooldown_RemovesFile(t *testing.T) {
 	host := setupTestHost(t)
 	mustSetCooldown(t, host, 8080, false, 60*time.Minute)
 
-	cooldownPath, _ := authCooldownPath(host, false)
+	cooldownPath, _ := authCooldownPath(host)
 	if _, err := os.Stat(cooldownPath); os.IsNotExist(err) {
 		t.Fatal("cooldown file should exist before clear")
 	}
 
-	ClearAuthCooldown(host, false)
+	ClearAuthCooldown(host)
 
 	if _, err := os.Stat(cooldownPath); !os.IsNotExist(err) {
 		t.Fatal("cooldown file should not exist after clear")
@@ -116,12 +116,59 @@ func TestTrySetAuthCooldown_DifferentHostsIndependent(t *testing.T) {
 func TestClearAuthCooldown_AllowsReacquire(t *testing.T) {
 	host := setupTestHost(t)
 	mustSetCooldown(t, host, 8080, false, 60*time.Minute)
-	ClearAuthCooldown(host, false)
+	ClearAuthCooldown(host)
 	mustSetCooldown(t, host, 9090, false, 60*time.Minute)
 }
 
-func TestTrySetAuthCooldown_AdminFlagIndependent(t *testing.T) {
+func TestTrySetAuthCooldown_AdminFlagShared(t *testing.T) {
 	host := setupTestHost(t)
 	mustSetCooldown(t, host, 8080, false, 60*time.Minute)
-	mustSetCooldown(t, host, 9090, true, 60*time.Minute)
+
+	ok, _, err := TrySetAuthCooldown(host, 9090, true, "", 60*time.Minute)
+	if err != nil || ok {
+		t.Fatalf("expected an admin login to be blocked by a tenant login, got %v, %v", ok, err)
+	}
+	if info := ReadCooldownInfo(host); info == nil || info.Admin || info.Port != 8080 {
+		t.Errorf("unexpected cooldown info: %+v", info)
+	}
+}
+
+func TestReleaseAuthCooldown_OwnCooldownRemoved(t *testing.T) {
+	host := setupTestHost(t)
+	mustSetCooldown(t, host, 8080, false, 60*time.Minute)
+
+	ReleaseAuthCooldown(host)
+
+	if ReadCooldownInfo(host) != nil {
+		t.Fatal("expected own cooldown to be released")
+	}
+}
+
+func TestReleaseAuthCooldown_OtherProcessCooldownKept(t *testing.T) {
+	host := setupTestHost(t)
+	writeFakeCooldown(t, host, false, 2147483647, 8080, time.Now())
+
+	ReleaseAuthCooldown(host)
+
+	if ReadCooldownInfo(host) == nil {
+		t.Fatal("expected cooldown held by another process to be kept")
+	}
+}
+
+func TestUpdateCooldown_PreservesState(t *testing.T) {
+	host := setupTestHost(t)
+
+	ok, _, err := TrySetAuthCooldown(host, 8080, false, "session-state", 60*time.Minute)
+	if err != nil || !ok {
+		t.Fatalf("expected cooldown set to succeed, got %v, %v", ok, err)
+	}
+
+	// A relay process takes over the port, and must accept the same callback state.
+	if err := UpdateCooldown(host, false, 9090); err != nil {
+		t.Fatalf("unexpected error updating cooldown: %v", err)
+	}
+	info := ReadCooldownInfo(host)
+	if info == nil || info.Port != 9090 || info.State != "session-state" {
+		t.Errorf("expected port 9090 and preserved state, got %+v", info)
+	}
 }
@@ -25,7 +25,7 @@ func setupTestHostNamed(t *testing.T, hostname string) string {
 // writeFakeCooldown creates a cooldown file with the given parameters for testing.
 func writeFakeCooldown(t *testing.T, host string, admin bool, pid int, port int, timestamp time.Time) {
 	t.Helper()
-	cooldownPath, err := authCooldownPath(host, admin)
+	cooldownPath, err := authCooldownPath(host)
 	if err != nil {
 		t.Fatalf("unexpected error getting cooldown path: %v", err)
 	}
@@ -47,7 +47,7 @@ func writeFakeCooldown(t *testing.T, host string, admin bool, pid int, port int,
 // mustSetCooldown calls TrySetAuthCooldown and fails the test if it doesn't succeed.
 func mustSetCooldown(t *testing.T, host string, port int, admin bool, duration time.Duration) {
 	t.Helper()
-	ok, _, err := TrySetAuthCooldown(host, port, admin, duration)
+	ok, _, err := TrySetAuthCooldown(host, port, admin, "", duration)
 	if err != nil {
 		t.Fatalf("unexpected error setting cooldown: %v", err)
 	}
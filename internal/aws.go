@@ -2,79 +2,79 @@ package internal
 
 import (
 	"context"
-	"fmt"
+	"net/http"
 	"os"
-	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
-	"github.com/duplocloud/duplo-jit/duplocloud"
+	"github.com/duplocloud/duplo-jit/jit"
 )
 
-type AwsConfigOutput struct {
-	Version         int    `json:"Version"`
-	ConsoleUrl      string `json:"ConsoleUrl"`
-	AccessKeyId     string `json:"AccessKeyId"`
-	SecretAccessKey string `json:"SecretAccessKey"`
-	Region          string `json:"Region"`
-	SessionToken    string `json:"SessionToken,omitempty"`
-	Expiration      string `json:"Expiration,omitempty"`
-}
-
-func ConvertAwsCreds(creds *duplocloud.AwsJitCredentials) *AwsConfigOutput {
-	// Calculate the expiration time.
-	now := time.Now().UTC()
-	validity := creds.Validity
-	if validity <= 0 {
-		validity = 3600 // default is one hour
-	}
-	expiration := now.Add(time.Duration(validity) * time.Second)
+// AwsConfigOutput is the output of "duplo-jit aws", in the format of an AWS CLI credential process.
+type AwsConfigOutput = jit.AwsCredentials
 
-	// Build the resulting credentials to be output.
-	return &AwsConfigOutput{
-		Version:         1,
-		ConsoleUrl:      creds.ConsoleURL,
-		AccessKeyId:     creds.AccessKeyID,
-		SecretAccessKey: creds.SecretAccessKey,
-		Region:          creds.Region,
-		SessionToken:    creds.SessionToken,
-		Expiration:      expiration.Format(time.RFC3339),
-	}
+// AwsCallerIdentity is the AWS principal behind a set of credentials.
+type AwsCallerIdentity struct {
+	Account string `json:"Account"`
+	Arn     string `json:"Arn"`
+	UserId  string `json:"UserId"`
 }
 
-func OutputAwsCreds(creds *AwsConfigOutput, cacheKey string) {
+func OutputAwsCreds(result *jit.AwsResult) {
 
-	// Write the creds to the cache.
-	cacheFile := fmt.Sprintf("%s,aws-creds.json", cacheKey)
-	json := cacheWriteMustMarshal(cacheFile, creds)
+	// Record the creds in the audit log.
+	json := mustMarshalJSON(result.Credentials)
+	entry := newAuditEntry("aws", result.CacheKey, result.FromCache, result.Credentials.Expiration)
+	entry.Reason = result.Reason
+	writeAuditEntry(entry)
 
 	// Write the creds to the output.
 	_, _ = os.Stdout.Write(json)
 	_, _ = os.Stdout.WriteString("\n")
 }
 
-func PingAWSCreds(creds *AwsConfigOutput) error {
+func PingAWSCreds(ctx context.Context, creds *AwsConfigOutput) error {
+	_, err := GetAwsCallerIdentity(ctx, creds)
+	return err
+}
+
+// GetAwsCallerIdentity returns the AWS principal behind the creds, by calling STS.
+func GetAwsCallerIdentity(ctx context.Context, creds *AwsConfigOutput) (*AwsCallerIdentity, error) {
 	credsProvider := aws.NewCredentialsCache(credentials.NewStaticCredentialsProvider(creds.AccessKeyId, creds.SecretAccessKey, creds.SessionToken))
 
-	// Create an AWS config using the creds.
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
+	// Create an AWS config using the creds, and the configured proxy and TLS options.
+	opts := []func(*config.LoadOptions) error{
 		config.WithRegion(creds.Region),
 		config.WithCredentialsProvider(credsProvider),
-	)
+	}
+	if transport != nil {
+		httpClient := awshttp.NewBuildableClient().WithTransportOptions(func(t *http.Transport) {
+			t.Proxy = transport.Proxy
+			t.TLSClientConfig = transport.TLSClientConfig.Clone()
+			t.TLSClientConfig.Certificates = nil // the client certificate is only for Duplo
+		})
+		opts = append(opts, config.WithHTTPClient(httpClient))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Create an STS client with the AWS config.
 	stsClient := sts.NewFromConfig(cfg)
 
-	// Call the STS client API for get-caller-identity to test cred validity.
-	_, err = stsClient.GetCallerIdentity(context.TODO(), &sts.GetCallerIdentityInput{})
+	// Call the STS client API for get-caller-identity, which also tests cred validity.
+	result, err := stsClient.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return &AwsCallerIdentity{
+		Account: aws.ToString(result.Account),
+		Arn:     aws.ToString(result.Arn),
+		UserId:  aws.ToString(result.UserId),
+	}, nil
 }
@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/skratchdot/open-golang/open"
+	"golang.org/x/term"
+)
+
+var noBrowser bool
+var browserCommand string
+
+// ConfigureBrowser configures how interactive sessions are started.  With noBrowser, the login URL
+// is printed instead of opened.  Otherwise, browser is the command used to open the login URL,
+// defaulting to the BROWSER environment variable, then to the system default browser.
+func ConfigureBrowser(disabled bool, browser string) {
+	noBrowser = disabled
+	browserCommand = browser
+}
+
+// isHeadless returns true if no browser can be opened: either disabled, or with no display and no BROWSER.
+func isHeadless() bool {
+	if noBrowser {
+		return true
+	}
+	if browserCommand != "" || os.Getenv("BROWSER") != "" {
+		return false
+	}
+
+	// macOS and Windows always have a desktop; other systems need X11 or Wayland.
+	if runtime.GOOS == "darwin" || runtime.GOOS == "windows" {
+		return false
+	}
+	return os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == ""
+}
+
+// launchBrowser opens the URL with --browser, BROWSER or the system default browser.
+func launchBrowser(url string) error {
+	// Like many tools, accept a colon-separated list of browsers to try in order.
+	browsers := browserCommand
+	if browsers == "" {
+		browsers = os.Getenv("BROWSER")
+	}
+	if browsers == "" {
+		return open.Run(url)
+	}
+
+	var errs []error
+	for _, browser := range strings.Split(browsers, ":") {
+		args := strings.Fields(browser)
+		if len(args) == 0 {
+			continue
+		}
+		err := exec.Command(args[0], append(args[1:], url)...).Start()
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// promptHeadless explains how to log in without a local browser.  If stdin is a terminal, a token
+// pasted by the user is sent to the channel.  The returned function stops waiting for a token, and
+// restores the terminal.
+func promptHeadless(url string, localPort int, done chan<- TokenResult) (restore func()) {
+	_, _ = fmt.Fprintf(os.Stderr, "\nTo log in to Duplo, open this URL in a browser:\n\n    %s\n\n", url)
+	_, _ = fmt.Fprintf(os.Stderr, "The browser must reach http://127.0.0.1:%d on this machine.  From a remote session, forward the port first:\n\n", localPort)
+	_, _ = fmt.Fprintf(os.Stderr, "    ssh -L %d:127.0.0.1:%d USER@THIS-HOST\n\n", localPort, localPort)
+	_, _ = fmt.Fprintln(os.Stderr, "Use --port to keep the same port across logins.")
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return func() {}
+	}
+
+	// Read the token without echoing it, and restore the terminal even if the browser wins the race.
+	state, err := term.GetState(fd)
+	if err != nil {
+		return func() {}
+	}
+	_, _ = fmt.Fprint(os.Stderr, "\nOr paste a Duplo API token here and press Enter: ")
+	stop := pasteToken(done)
+	return func() {
+		stop()
+		_ = term.Restore(fd, state)
+	}
+}
+
+// pasteToken sends a token pasted on stdin to the channel, until the returned function is called.
+// A token that is still being read then goes to the next prompt, with the terminal echoing again.
+func pasteToken(done chan<- TokenResult) (stop func()) {
+	lines := stdinLines.start(readStdinPassword)
+	stopped := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case input := <-lines:
+			stdinLines.received(lines)
+			_, _ = fmt.Fprintln(os.Stderr)
+
+			result := TokenResult{Token: strings.TrimSpace(input.line), err: input.err}
+			if result.err == nil && result.Token == "" {
+				result.err = errors.New("no token was pasted")
+			}
+			select {
+			case done <- result:
+			default:
+			}
+		case <-stopped:
+		}
+	}()
+	return func() {
+		close(stopped)
+		<-exited
+	}
+}
@@ -0,0 +1,152 @@
+package internal
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsHeadless(t *testing.T) {
+	if runtime.GOOS == "darwin" || runtime.GOOS == "windows" {
+		t.Skip("desktop systems always have a display")
+	}
+
+	tests := []struct {
+		name      string
+		noBrowser bool
+		browser   string
+		env       map[string]string
+		want      bool
+	}{
+		{"no display", false, "", nil, true},
+		{"X11", false, "", map[string]string{"DISPLAY": ":0"}, false},
+		{"Wayland", false, "", map[string]string{"WAYLAND_DISPLAY": "wayland-0"}, false},
+		{"BROWSER", false, "", map[string]string{"BROWSER": "w3m"}, false},
+		{"--browser", false, "w3m", nil, false},
+		{"--no-browser", true, "", map[string]string{"DISPLAY": ":0"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"DISPLAY", "WAYLAND_DISPLAY", "BROWSER"} {
+				t.Setenv(name, tt.env[name])
+			}
+			ConfigureBrowser(tt.noBrowser, tt.browser)
+			defer ConfigureBrowser(false, "")
+
+			if got := isHeadless(); got != tt.want {
+				t.Errorf("isHeadless() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLaunchBrowser_Command(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script")
+	}
+
+	// A fake browser that records its arguments.
+	dir := t.TempDir()
+	out := filepath.Join(dir, "args")
+	script := filepath.Join(dir, "browser")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho \"$@\" > "+out+"\n"), 0o700); err != nil {
+		t.Fatalf("failed to write script: %v", err)
+	}
+
+	// --browser takes precedence over BROWSER, and may have arguments.
+	t.Setenv("BROWSER", "/nonexistent/browser")
+	ConfigureBrowser(false, "/nonexistent/browser:"+script+" --new-window")
+	defer ConfigureBrowser(false, "")
+
+	if err := launchBrowser("https://test.example.com/app"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, err := os.ReadFile(out)
+		if err == nil && strings.TrimSpace(string(data)) == "--new-window https://test.example.com/app" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("browser not run with the expected arguments: %q, %v", data, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLaunchBrowser_NoneWorks(t *testing.T) {
+	ConfigureBrowser(false, "/nonexistent/one:/nonexistent/two")
+	defer ConfigureBrowser(false, "")
+
+	if err := launchBrowser("https://test.example.com/app"); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+// fakeStdin makes prompts read lines from the returned writer.
+func fakeStdin(t *testing.T) io.Writer {
+	t.Helper()
+	r, w := io.Pipe()
+	reader := bufio.NewReader(r)
+	read := func() (string, error) { return reader.ReadString('\n') }
+
+	origLine, origPassword := readStdinLine, readStdinPassword
+	readStdinLine, readStdinPassword = read, read
+	stdinLines = lineReader{}
+	t.Cleanup(func() {
+		_ = w.Close()
+		readStdinLine, readStdinPassword = origLine, origPassword
+		stdinLines = lineReader{}
+	})
+	return w
+}
+
+func TestPasteToken(t *testing.T) {
+	stdin := fakeStdin(t)
+
+	done := make(chan TokenResult, 1)
+	stop := pasteToken(done)
+	defer stop()
+	_, _ = io.WriteString(stdin, "  pasted-token \n")
+
+	select {
+	case result := <-done:
+		if result.err != nil || result.Token != "pasted-token" {
+			t.Errorf("unexpected result: %+v", result)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the pasted token")
+	}
+}
+
+func TestPasteToken_CallbackWins(t *testing.T) {
+	stdin := fakeStdin(t)
+
+	// The browser callback wins, and the next prompt gets the following line.
+	done := make(chan TokenResult, 1)
+	pasteToken(done)()
+	go func() { _, _ = io.WriteString(stdin, "yes\n") }()
+
+	line, err := readLine()
+	if err != nil || line != "yes\n" {
+		t.Errorf("readLine() = %q, %v", line, err)
+	}
+	select {
+	case result := <-done:
+		t.Errorf("unexpected token result: %+v", result)
+	default:
+	}
+
+	// Later prompts read their own lines.
+	go func() { _, _ = io.WriteString(stdin, "INC-1234\n") }()
+	if line, err := readLine(); err != nil || line != "INC-1234\n" {
+		t.Errorf("readLine() = %q, %v", line, err)
+	}
+}
@@ -5,13 +5,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
-	"time"
 
-	"github.com/duplocloud/duplo-jit/duplocloud"
-	clientauthv1beta1 "k8s.io/client-go/pkg/apis/clientauthentication/v1beta1"
+	"github.com/duplocloud/duplo-jit/jit"
 )
 
 var cacheDir string
@@ -45,19 +43,25 @@ func cacheReadUnmarshal(file string, target interface{}) bool {
 				return true
 			}
 
-			log.Printf("warning: %s: invalid JSON in cache: %s", file, err)
+			slog.Warn("invalid JSON in cache", "file", file, "error", err)
 		} else if !errors.Is(err, os.ErrNotExist) {
-			log.Printf("warning: %s: unable to read from cache", file)
+			slog.Warn("unable to read from cache", "file", file, "error", err)
 		}
 	}
 
 	return false
 }
 
-// cacheWriteMustMarshal unmarshals the source and writes JSON.
-// It returns the JSON bytes and ignores cache write failures.
-func cacheWriteMustMarshal(file string, source interface{}) []byte {
-	// Convert the source to JSON
+// credentialsCache returns the cache of credentials, or nil if caching is disabled.
+func credentialsCache() jit.Cache {
+	if noCache || cacheDir == "" {
+		return nil
+	}
+	return &jit.FileCache{Dir: cacheDir}
+}
+
+// mustMarshalJSON converts the source to JSON, without escaping the HTML characters of URLs.
+func mustMarshalJSON(source interface{}) []byte {
 	var buf bytes.Buffer
 	encoder := json.NewEncoder(&buf)
 	encoder.SetEscapeHTML(false)
@@ -65,40 +69,19 @@ func cacheWriteMustMarshal(file string, source interface{}) []byte {
 	DieIf(err, "cannot marshal to JSON")
 
 	// Remove the trailing newline added by encoder.Encode.
-	jsonBytes := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
-
-	// Cache the JSON if caching is enabled
-	if !noCache && cacheDir != "" {
-		file = filepath.Join(cacheDir, file)
-		err = os.WriteFile(file, jsonBytes, 0600)
-		if err != nil {
-			log.Printf("warning: %s: unable to write to cache", file)
-		}
-	}
-
-	return jsonBytes
-}
-
-func cacheRemoveEntry(cacheKey, cacheType string) {
-	cacheRemoveFile(cacheKey, fmt.Sprintf("%s,%s-creds.json", cacheKey, cacheType))
-}
-
-func cacheRemoveFile(cacheKey, file string) {
-	err := os.Remove(filepath.Join(cacheDir, file))
-	if err != nil && !errors.Is(err, os.ErrNotExist) {
-		log.Printf("warning: %s: unable to remove from credentials cache", cacheKey)
-	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
 }
 
 // CacheGetDuploTokenUnchecked reads a cached Duplo token without API validation.
-func CacheGetDuploTokenUnchecked(baseUrl string) string {
+// If admin is true, only an admin token is returned.
+func CacheGetDuploTokenUnchecked(baseUrl string, admin bool) string {
 	if noCache || cacheDir == "" {
 		return ""
 	}
 	cacheKey := GetHostCacheKey(baseUrl)
 	file := fmt.Sprintf("%s,duplo-creds.json", cacheKey)
 	creds := &DuploCredsOutput{}
-	if !cacheReadUnmarshal(file, creds) {
+	if !cacheReadUnmarshal(file, creds) || !creds.HasScope(admin) {
 		return ""
 	}
 	return creds.DuploToken
@@ -111,7 +94,7 @@ func ClearAllCaches() {
 
 	dirs := []string{
 		filepath.Join(userCacheDir, "duplo-jit"),
-		filepath.Join(userCacheDir, "duplo-jit-auth"),
+		filepath.Join(userCacheDir, authCooldownDir),
 	}
 
 	var count int
@@ -133,131 +116,3 @@ func ClearAllCaches() {
 
 	fmt.Fprintf(os.Stderr, "Cleared %d cached file(s)\n", count)
 }
-
-// CacheGetAwsConfigOutput tries to read prior AWS creds from the cache.
-func CacheGetAwsConfigOutput(cacheKey string) (creds *AwsConfigOutput) {
-	var file string
-
-	// Read credentials from the cache.
-	if !noCache && cacheDir != "" {
-		file = fmt.Sprintf("%s,aws-creds.json", cacheKey)
-		creds = &AwsConfigOutput{}
-		if !cacheReadUnmarshal(file, creds) {
-			creds = nil
-		}
-
-		// Check credentials for expiry.
-		if creds != nil {
-			fiveMinutesFromNow := time.Now().UTC().Add(5 * time.Minute)
-			expiration, err := time.Parse(time.RFC3339, creds.Expiration)
-
-			// Invalid expiration?
-			if err != nil {
-				log.Printf("warning: %s: invalid Expiration time in credentials cache: %s", cacheKey, creds.Expiration)
-				creds = nil
-
-				// Expires in five minutes or less?
-			} else if fiveMinutesFromNow.After(expiration) {
-				creds = nil
-			}
-		}
-
-		// Validate creds by executing ping
-		if creds != nil {
-			if err := PingAWSCreds(creds); err != nil {
-				creds = nil
-			}
-		}
-
-		// Clear the cache if the creds expired.
-		if creds == nil {
-			cacheRemoveFile(cacheKey, file)
-		}
-	}
-
-	return
-}
-
-// CacheGetDuploOutput tries to read prior AWS creds from the cache.
-func CacheGetDuploOutput(cacheKey string, host string) (creds *DuploCredsOutput) {
-	var file string
-
-	// Read credentials from the cache.
-	if !noCache && cacheDir != "" {
-		file = fmt.Sprintf("%s,duplo-creds.json", cacheKey)
-		creds = &DuploCredsOutput{}
-		if !cacheReadUnmarshal(file, creds) {
-			creds = nil
-		}
-
-		// Check credentials for expiry - by trying to retrieve system features
-		if creds != nil {
-			// Retrieve system features.
-			client, err := duplocloud.NewClient(host, creds.DuploToken)
-			if err == nil {
-				var features *duplocloud.DuploSystemFeatures
-				features, err = client.FeaturesSystem()
-				if features != nil {
-					creds.NeedOTP = features.IsOtpNeeded
-				}
-			}
-
-			// If we have any errors, assume that the credentials have expired
-			if err != nil {
-				creds = nil
-			}
-		}
-
-		// Validate creds by executing ping
-		if creds != nil {
-			if err := PingDuploCreds(creds, host); err != nil {
-				creds = nil
-			}
-		}
-
-		// Clear the cache if the creds expired.
-		if creds == nil {
-			cacheRemoveFile(cacheKey, file)
-		}
-	}
-
-	return
-}
-
-// CacheGetAwsConfigOutput tries to read prior K8s creds from the cache.
-func CacheGetK8sConfigOutput(cacheKey string, tenantName string) (creds *clientauthv1beta1.ExecCredential) {
-	var file string
-
-	// Read credentials from the cache.
-	if !noCache && cacheDir != "" {
-		file = fmt.Sprintf("%s,k8s-creds.json", cacheKey)
-		creds = &clientauthv1beta1.ExecCredential{}
-		if !cacheReadUnmarshal(file, creds) {
-			creds = nil
-		}
-
-		// Check credentials for expiry.
-		if creds != nil {
-			// Expires in five minutes or less?
-			fiveMinutesFromNow := time.Now().UTC().Add(5 * time.Minute)
-			expiration := creds.Status.ExpirationTimestamp.Time
-			if fiveMinutesFromNow.After(expiration) {
-				creds = nil
-			}
-		}
-
-		// Validate creds by executing ping
-		if creds != nil {
-			if err := PingK8sCreds(creds, tenantName); err != nil {
-				creds = nil
-			}
-		}
-
-		// Clear the cache if the creds expired or invalid
-		if creds == nil {
-			cacheRemoveFile(cacheKey, file)
-		}
-	}
-
-	return
-}
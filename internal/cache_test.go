@@ -0,0 +1,56 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/duplocloud/duplo-jit/jit"
+)
+
+// setupTestCache enables caching in a temporary directory.
+func setupTestCache(t *testing.T) {
+	t.Helper()
+	oldDir, oldNoCache := cacheDir, noCache
+	cacheDir, noCache = t.TempDir(), false
+	t.Cleanup(func() { cacheDir, noCache = oldDir, oldNoCache })
+}
+
+// writeTestDuploCreds caches Duplo credentials for a host.
+func writeTestDuploCreds(t *testing.T, cacheKey string, creds *DuploCredsOutput) {
+	t.Helper()
+	if err := credentialsCache().Put(cacheKey+",duplo-creds.json", mustMarshalJSON(creds)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCacheGetDuploTokenUnchecked_Scope(t *testing.T) {
+	setupTestCache(t)
+
+	writeTestDuploCreds(t, "test.example.com", &DuploCredsOutput{Version: 1, DuploToken: "tenant-token"})
+
+	if got := CacheGetDuploTokenUnchecked("https://test.example.com", false); got != "tenant-token" {
+		t.Errorf("expected tenant token, got %q", got)
+	}
+	if got := CacheGetDuploTokenUnchecked("https://test.example.com", true); got != "" {
+		t.Errorf("expected no token for admin request, got %q", got)
+	}
+
+	// An admin token satisfies both.
+	writeTestDuploCreds(t, "test.example.com", &DuploCredsOutput{Version: 1, DuploToken: "admin-token", Admin: true})
+	for _, admin := range []bool{false, true} {
+		if got := CacheGetDuploTokenUnchecked("https://test.example.com", admin); got != "admin-token" {
+			t.Errorf("admin=%v: expected admin token, got %q", admin, got)
+		}
+	}
+}
+
+func TestCredentialsCache(t *testing.T) {
+	setupTestCache(t)
+	if cache, ok := credentialsCache().(*jit.FileCache); !ok || cache.Dir != cacheDir {
+		t.Errorf("expected a file cache in %s, got %#v", cacheDir, credentialsCache())
+	}
+
+	noCache = true
+	if cache := credentialsCache(); cache != nil {
+		t.Errorf("expected no cache, got %#v", cache)
+	}
+}
@@ -0,0 +1,61 @@
+package internal
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/duplocloud/duplo-jit/duplocloud"
+)
+
+var clientTimeout = duplocloud.DefaultTimeout
+var clientRetry = duplocloud.DefaultRetryPolicy()
+var transport *http.Transport
+var transportOptions duplocloud.TransportOptions
+
+// ConfigureDuploClients sets the per-call timeout and the retry policy of all Duplo API clients
+// created by this package.
+func ConfigureDuploClients(timeout time.Duration, retry duplocloud.RetryPolicy) {
+	if timeout > 0 {
+		clientTimeout = timeout
+	}
+	clientRetry = retry
+}
+
+// ConfigureTransport sets the proxy and TLS options used for the Duplo API, as well as
+// for validating AWS and Kubernetes credentials.  The client certificate is only sent to Duplo.
+func ConfigureTransport(opts duplocloud.TransportOptions) error {
+	t, err := duplocloud.NewTransport(opts)
+	if err != nil {
+		return err
+	}
+	transport, transportOptions = t, opts
+	return nil
+}
+
+// httpTransport returns the configured HTTP transport, or nil to use the default.
+func httpTransport() http.RoundTripper {
+	if transport == nil {
+		return nil
+	}
+	return transport
+}
+
+// RetryPolicyFromFlags builds a retry policy from the number of retries and the total deadline.
+func RetryPolicyFromFlags(retries int, maxTime time.Duration) duplocloud.RetryPolicy {
+	retry := duplocloud.DefaultRetryPolicy()
+	retry.MaxAttempts = retries + 1
+	retry.MaxElapsed = maxTime
+	return retry
+}
+
+// NewDuploClient creates a new Duplo API client, using the configured timeout and retry policy.
+func NewDuploClient(host, token, otp string) (*duplocloud.Client, error) {
+	client, err := duplocloud.NewClientWithOtp(host, token, otp)
+	if err != nil {
+		return nil, err
+	}
+	client.HTTPClient.Timeout = clientTimeout
+	client.HTTPClient.Transport = httpTransport()
+	client.Retry = clientRetry
+	return client, nil
+}
@@ -0,0 +1,74 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/duplocloud/duplo-jit/jit"
+)
+
+// configEnvVar overrides the location of the configuration file.
+const configEnvVar = "DUPLO_JIT_CONFIG"
+
+// Config is the configuration file of duplo-jit.
+type Config struct {
+	Policy Policy `json:"Policy"`
+
+	// Retention is the retention policy of each class of cached credentials, such as "admin".
+	Retention map[string]RetentionPolicy `json:"Retention,omitempty"`
+
+	retention map[string]jit.Retention
+}
+
+var currentConfig = &Config{}
+
+// ConfigPath returns the path of the configuration file: DUPLO_JIT_CONFIG, or config.json in the user
+// config directory.
+func ConfigPath() (string, error) {
+	if path := os.Getenv(configEnvVar); path != "" {
+		return path, nil
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "duplo-jit", "config.json"), nil
+}
+
+// LoadConfig reads a configuration file.  A missing file is an empty configuration.  Unknown fields are
+// rejected, so that a misspelled policy is not silently ignored.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &Config{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(cfg); err != nil {
+		return nil, err
+	}
+	if cfg.retention, err = sessionRetention(cfg.Retention); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// MustLoadConfig loads the configuration file.  Without a user config directory, there is none.
+func MustLoadConfig() {
+	path, err := ConfigPath()
+	if err != nil {
+		slog.Debug("no configuration file", "error", err)
+		return
+	}
+	currentConfig, err = LoadConfig(path)
+	DieIf(err, "cannot load configuration file "+path)
+}
@@ -1,163 +1,84 @@
 package internal
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
-	"fmt"
-	"log"
-	"net/url"
 	"os"
 
 	"github.com/duplocloud/duplo-jit/duplocloud"
+	"github.com/duplocloud/duplo-jit/jit"
 )
 
-type DuploCredsOutput struct {
-	Version    int    `json:"Version"`
-	DuploToken string `json:"DuploToken,omitempty"`
-	NeedOTP    bool   `json:"NeedOTP"`
-}
-
-// duploClientAndOtpFlag returns a duplo client if and only if the token is valid and OTP is not needed.
-func duploClientAndOtpFlag(host, token, otp string, admin bool) (*duplocloud.Client, bool) {
-	client, err := duplocloud.NewClientWithOtp(host, token, otp)
-	DieIf(err, "invalid arguments")
-	features, err := client.FeaturesSystem() // this API call is doubling as a system "ping"
-
-	// Is the token invalid?
-	if err != nil {
-		return nil, false
+// DuploCredsOutput is the output of "duplo-jit duplo", and the cached Duplo token.
+type DuploCredsOutput = jit.DuploCreds
+
+// SessionOptions returns the options of a JIT session for the command-line arguments, using the
+// configured Duplo API clients, cache, retention policy and interactive login.
+func SessionOptions(host string, apiHost string, token string, interactive bool, cmd string, port int) jit.Options {
+	opts := jit.Options{
+		Host:    host,
+		APIHost: apiHost,
+		Token:   token,
+		OTP: func(context.Context) (string, error) {
+			return mustOtpCode(), nil
+		},
+		Retention:   currentConfig.retention,
+		NewClient:   NewDuploClient,
+		ValidateAws: PingAWSCreds,
+		ValidateK8s: PingK8sCreds,
+		OnCachedToken: func(creds *DuploCredsOutput) {
+			warnDuploTokenExpiry(host, creds)
+		},
 	}
-
-	// Do we need to retrieve a OTP?
-	if admin && features.IsOtpNeeded && otp == "" {
-		return nil, true
+	if interactive {
+		opts.Authenticator = InteractiveAuthenticator(cmd, port)
 	}
-
-	// Otherwise, the client is usable.
-	return client, false
-}
-
-// MustDuploClient retrieves a duplo client (and credentials) or panics.
-func MustDuploClient(host string, apiHost string, token string, interactive bool, admin bool, port int) (client *duplocloud.Client, creds *DuploCredsOutput) {
-	needsOtp := false
-	cacheKey := GetHostCacheKey(host)
-
-	// Try non-interactive auth first.
-	if token != "" {
-		cacheRemoveEntry(cacheKey, "duplo") // never cache explicitly passed creds
-		client, needsOtp = duploClientAndOtpFlag(apiHost, token, "", admin)
-
-		// If OTP is needed, we can only continue if interactive auth is allowed.
-		if needsOtp {
-			if !interactive {
-				log.Fatalf("%s: server requires MFA but --interactive mode is disabled", os.Args[0])
-			}
-
-			// The client is usable, so we can return our result.
-		} else if client != nil {
-			creds = &DuploCredsOutput{
-				Version:    1,
-				DuploToken: token,
-				NeedOTP:    needsOtp,
-			}
-			return
-
-			// The client is not usable, so we have an error.
-		} else {
-			log.Fatalf("%s: authentication failure: failed to collect system features", os.Args[0])
-		}
+	if cache := credentialsCache(); cache != nil {
+		opts.Cache = cache
+		opts.TokenCache = cache
 	}
+	return opts
+}
 
-	// Non-interactive auth was not available or not sufficient.
-	if !interactive {
-		log.Fatalf("%s: --token not specified and --interactive mode is disabled", os.Args[0])
-	}
-
-	// Next, we load and validate Duplo credentials from the cache.
-	if token == "" {
-		creds = CacheGetDuploOutput(cacheKey, apiHost)
-		if creds != nil {
-			client, _ = duploClientAndOtpFlag(apiHost, creds.DuploToken, "", admin)
-		}
-	}
-
-	// Cached credentials were not available or not sufficient.
-	// So, finally, we try to retrieve and validate Duplo credentials interactively.
-	if client == nil {
-
-		// Clear invalid cached credentials.
-		if token == "" {
-			cacheRemoveEntry(cacheKey, "duplo")
-		}
-
-		// Get the token, or fail.
-		tokenResult := MustTokenInteractive(host, admin, "duplo-jit", port)
-		if tokenResult.Token == "" {
-			log.Fatalf("%s: authentication failure: failed to get token interactively", os.Args[0])
-		}
-
-		// Get the client, or fail.
-		client, _ = duploClientAndOtpFlag(apiHost, tokenResult.Token, tokenResult.OTP, admin)
-		if client == nil {
-			log.Fatalf("%s: authentication failure: failed to collect system features", os.Args[0])
-		}
-
-		// Build credentials.
-		creds = &DuploCredsOutput{
-			Version:    1,
-			DuploToken: tokenResult.Token,
-			NeedOTP:    tokenResult.OTP != "",
-		}
+// MustSession creates a JIT session or panics.
+func MustSession(opts jit.Options) *jit.Session {
+	session, err := jit.New(opts)
+	DieIf(err, "invalid arguments")
+	return session
+}
 
-		// Write the creds to the cache, unless we started out with a non-interactive token
-		if token == "" {
-			cacheFile := fmt.Sprintf("%s,duplo-creds.json", cacheKey)
-			cacheWriteMustMarshal(cacheFile, creds)
-		}
+// MustDuploClient retrieves a duplo client (and credentials) from the session or panics.  An admin client
+// carries an admin token, so the policy must allow admin credentials.
+func MustDuploClient(ctx context.Context, session *jit.Session, admin bool) (*duplocloud.Client, *DuploCredsOutput) {
+	if admin {
+		MustAuthorize(ctx, session, jit.RoleAdmin, "")
 	}
-
-	return
+	client, creds, err := session.Client(ctx, admin)
+	DieIf(err, "cannot get Duplo credentials")
+	return client, creds
 }
 
 func GetHostCacheKey(host string) string {
-	u, err := url.Parse(host)
-	if err != nil {
-		log.Fatalf("failed to parse host: %v", err)
-	}
-	return u.Hostname()
+	hostKey, err := jit.HostKey(host)
+	DieIf(err, "invalid host")
+	return hostKey
 }
 
-func OutputDuploCreds(creds *DuploCredsOutput) {
+// OutputDuploCreds writes the Duplo token, recording it in the audit log with the requested scope.
+func OutputDuploCreds(creds *DuploCredsOutput, cacheKey string, admin bool) {
 
 	// Convert the source to JSON
 	jsonBytes, err := json.Marshal(creds)
 	DieIf(err, "cannot marshal to JSON")
 
+	// Record the creds in the audit log.
+	role := "tenant"
+	if admin || creds.Admin {
+		role = "admin"
+	}
+	writeAuditEntry(newAuditEntry("duplo", cacheKey+","+role, creds.FromCache, creds.Expiration))
+
 	// Write the creds to the output.
 	_, _ = os.Stdout.Write(jsonBytes)
 	_, _ = os.Stdout.WriteString("\n")
 }
-
-func PingDuploCreds(creds *DuploCredsOutput, host string) error {
-	client, err := duplocloud.NewClient(host, creds.DuploToken)
-	if err != nil {
-		return err
-	}
-
-	tenants, terr := client.ListTenantsForUser()
-	if terr != nil {
-		return terr
-	}
-
-	if len(*tenants) == 0 {
-		return errors.New("PingDuploCreds: user has no tenants")
-	}
-
-	tenant := (*tenants)[0]
-	_, ferr := client.GetTenantFeatures(tenant.TenantID)
-	if ferr != nil {
-		return ferr
-	}
-
-	return nil
-}
@@ -0,0 +1,43 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// DuploTokenRenewWindow is how long before its expiry a Duplo token should be renewed.
+const DuploTokenRenewWindow = 30 * time.Minute
+
+// warnDuploTokenExpiry tells terminal users when the token is close to expiring, before they are
+// forced to log in again in the middle of a command.
+func warnDuploTokenExpiry(host string, creds *DuploCredsOutput) {
+	remaining, ok := creds.ExpiresIn(time.Now())
+	if !ok || remaining > DuploTokenRenewWindow || !term.IsTerminal(int(os.Stderr.Fd())) {
+		return
+	}
+
+	renew := "duplo-jit login --renew --host " + host
+	if creds.Admin {
+		renew += " --admin"
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "Warning: the Duplo token for %s expires in %s.  To renew it now, run: %s\n",
+		GetHostCacheKey(host), remaining.Truncate(time.Second), renew)
+}
+
+// OutputLogin reports the Duplo token that was cached by a login.
+func OutputLogin(host string, creds *DuploCredsOutput) {
+	scope := "tenant access"
+	if creds.Admin {
+		scope = "admin access"
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "Logged in to %s with %s\n", GetHostCacheKey(host), scope)
+
+	if remaining, ok := creds.ExpiresIn(time.Now()); ok {
+		_, _ = fmt.Fprintf(os.Stderr, "The Duplo token expires at %s (in %s)\n", creds.Expiration, remaining.Truncate(time.Second))
+	} else {
+		_, _ = fmt.Fprintln(os.Stderr, "The portal does not expose when the Duplo token expires")
+	}
+}
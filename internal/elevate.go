@@ -0,0 +1,37 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/duplocloud/duplo-jit/jit"
+)
+
+// HoldElevation records elevated credentials in the audit log, and holds them in the cache until the end
+// of their window, or until the context is canceled, such as by Ctrl-C or by closing the terminal.  Then,
+// it removes them.
+func HoldElevation(ctx context.Context, session *jit.Session, result *jit.AwsResult) {
+
+	// Record the creds in the audit log.
+	entry := newAuditEntry("elevate", result.CacheKey, false, result.Credentials.Expiration)
+	entry.Reason = result.Reason
+	writeAuditEntry(entry)
+
+	fmt.Fprintf(os.Stderr, "Holding %s credentials in the cache until %s (press Ctrl-C to end the elevation early)\n",
+		result.Role, result.Until.Local().Format(time.Kitchen))
+	if expiration, err := time.Parse(time.RFC3339, result.Credentials.Expiration); err == nil && expiration.Before(result.Until) {
+		fmt.Fprintf(os.Stderr, "The credentials expire at %s, before the end of the elevation\n", expiration.Local().Format(time.Kitchen))
+	}
+
+	timer := time.NewTimer(time.Until(result.Until))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	session.EndElevation(result)
+	fmt.Fprintf(os.Stderr, "Elevation ended: removed %s credentials from the cache\n", result.Role)
+}
@@ -1,32 +1,68 @@
 package internal
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"time"
 
-	"github.com/skratchdot/open-golang/open"
+	"github.com/duplocloud/duplo-jit/jit"
 	"golang.org/x/term"
 )
 
+// allowCallbackWithoutState accepts token callbacks from older portals, which do not return the state.
+var allowCallbackWithoutState bool
+
+// ConfigureCallback configures how the interactive token callback is checked.
+func ConfigureCallback(allowWithoutState bool) {
+	allowCallbackWithoutState = allowWithoutState
+}
+
 type TokenResult struct {
 	Token string `json:"token"`
 	OTP   string `json:"otp,omitempty"`
 	err   error
 }
 
-func handlerToken(baseUrl string, localPort int, admin bool, res http.ResponseWriter, req *http.Request) (completed bool, tokenBytes []byte) {
+// newCallbackState returns a random value that the portal must send back with the token, so that
+// no other page can make the browser deliver a token of its choosing.
+func newCallbackState() string {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	DieIf(err, "failed to generate callback state")
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// callbackStateValid checks the state sent back with a token callback.
+func callbackStateValid(state string, req *http.Request) bool {
+	got := req.URL.Query().Get("state")
+	if got == "" {
+		return allowCallbackWithoutState
+	}
+	return state != "" && subtle.ConstantTimeCompare([]byte(got), []byte(state)) == 1
+}
+
+func handlerToken(baseUrl string, localPort int, admin bool, state string, res http.ResponseWriter, req *http.Request) (completed bool, tokenBytes []byte) {
 	// Only allow the specified Duplo to give us creds.
 	res.Header().Add("Access-Control-Allow-Origin", baseUrl)
 	res.Header().Add("Access-Control-Allow-Headers", "X-Requested-With, Accept, Content-Type")
 
+	// Only accept the token from the session we started.  Rejected requests do not end the session.
+	if (req.Method == "GET" || req.Method == "POST") && !callbackStateValid(state, req) {
+		http.Error(res, "invalid or missing state", http.StatusForbidden)
+		return false, nil
+	}
+
 	// Check if this is a GET request carrying the token in the query string.
 	if req.Method == "GET" {
 		token := req.URL.Query().Get("t")
@@ -78,31 +114,34 @@ func handlerToken(baseUrl string, localPort int, admin bool, res http.ResponseWr
 	return false, nil
 }
 
-func TokenViaListener(baseUrl string, admin bool, cmd string, port int, timeout time.Duration) TokenResult {
+// TokenViaListener retrieves a token from an interactive browser session, until the timeout
+// is reached or the context is canceled.
+func TokenViaListener(ctx context.Context, baseUrl string, admin bool, cmd string, port int, timeout time.Duration) TokenResult {
 	cooldownDuration, cooldownEnabled := IsAuthCooldownEnabled()
 	isTTY := term.IsTerminal(int(os.Stderr.Fd()))
 
 	openBrowser := true
 	listenPort := port
+	state := newCallbackState()
 
 	// If auth cooldown is enabled and we are NOT in a TTY (i.e. automated caller like
 	// kubectl), check before creating the listener. TTY callers (user at terminal)
 	// always bypass the cooldown for immediate access.
 	if cooldownEnabled && !isTTY {
 		var earlyResult *TokenResult
-		listenPort, openBrowser, timeout, earlyResult = checkCooldownBeforeListen(baseUrl, admin, cmd, port, cooldownDuration)
+		listenPort, openBrowser, timeout, earlyResult = checkCooldownBeforeListen(ctx, baseUrl, admin, cmd, port, cooldownDuration)
 		if earlyResult != nil {
 			return *earlyResult
 		}
 	} else if cooldownEnabled {
-		log.Printf("auth cooldown: TTY detected, bypassing cooldown for %s", GetHostCacheKey(baseUrl))
+		slog.Info("auth cooldown: TTY detected, bypassing cooldown", "host", GetHostCacheKey(baseUrl))
 	}
 
 	// Create the listener.
 	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", listenPort))
 	if err != nil {
 		if listenPort != 0 && listenPort != port {
-			return recoverRelayBindFailure(baseUrl, admin, cmd, port, listenPort, cooldownDuration)
+			return recoverRelayBindFailure(ctx, baseUrl, admin, cmd, port, listenPort, cooldownDuration)
 		}
 		return TokenResult{err: err}
 	}
@@ -111,20 +150,31 @@ func TokenViaListener(baseUrl string, admin bool, cmd string, port int, timeout
 	localPort := listener.Addr().(*net.TCPAddr).Port
 
 	// Set or update cooldown now that we have the port.
+	var handoff *tokenHandoff
 	if cooldownEnabled && !isTTY {
-		if result := acquireOrUpdateCooldown(baseUrl, admin, cmd, port, localPort, openBrowser, cooldownDuration, listener); result != nil {
+		if result := acquireOrUpdateCooldown(ctx, baseUrl, admin, cmd, port, localPort, state, openBrowser, cooldownDuration, listener); result != nil {
 			return *result
 		}
+
+		// Waiting processes subscribe to our result.
+		handoff = startTokenHandoff(baseUrl, admin)
+
+		// A relay must accept the state of the existing browser tab.
+		if !openBrowser {
+			if info := ReadCooldownInfo(baseUrl); info != nil {
+				state = info.State
+			}
+		}
 	}
 
 	// Run the HTTP server on localhost.
-	done := make(chan TokenResult)
+	done := make(chan TokenResult, 1)
 	go func() {
 		mux := http.NewServeMux()
 
 		// legacy API, with no facility for OTP
 		mux.HandleFunc("/", func(res http.ResponseWriter, req *http.Request) {
-			completed, tokenBytes := handlerToken(baseUrl, localPort, admin, res, req)
+			completed, tokenBytes := handlerToken(baseUrl, localPort, admin, state, res, req)
 
 			// If we are done, send the result to the channel.
 			if completed {
@@ -134,7 +184,7 @@ func TokenViaListener(baseUrl string, admin bool, cmd string, port int, timeout
 
 		// API with facility for OTP
 		mux.HandleFunc("/v2/callbackWithOtp", func(res http.ResponseWriter, req *http.Request) {
-			completed, tokenBytes := handlerToken(baseUrl, localPort, admin, res, req)
+			completed, tokenBytes := handlerToken(baseUrl, localPort, admin, state, res, req)
 
 			// If we are done, send the result to the channel.
 			if completed {
@@ -147,24 +197,40 @@ func TokenViaListener(baseUrl string, admin bool, cmd string, port int, timeout
 	}()
 
 	// Open the browser only for fresh starts (not port-reuse relays).
+	// Without a usable browser, explain how to log in from elsewhere.
 	if openBrowser {
-		url := getInteractiveUrl(admin, baseUrl, cmd, localPort)
-		err = open.Run(url)
-		DieIf(err, "failed to open interactive browser session")
+		url := getInteractiveUrl(admin, baseUrl, cmd, localPort, state)
+		if isHeadless() {
+			defer promptHeadless(url, localPort, done)()
+		} else if err = launchBrowser(url); err != nil {
+			slog.Warn("failed to open interactive browser session", "error", err)
+			defer promptHeadless(url, localPort, done)()
+		}
 	} else {
-		log.Printf("auth cooldown: relay — listening on port %d for existing browser tab", localPort)
+		slog.Info("auth cooldown: relay, listening for existing browser tab", "port", localPort)
 	}
 
 	// Wait for the token result, and return it.
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
 	select {
 	case tokenResult := <-done:
+		handoff.publish(tokenResult)
 		if tokenResult.err == nil {
-			ClearAuthCooldown(baseUrl, admin)
+			ClearAuthCooldown(baseUrl)
 		}
 		return tokenResult
-	case <-time.After(timeout):
+	case <-timer.C:
+		_ = listener.Close()
+		tokenResult := TokenResult{err: errors.New("timed out")}
+		handoff.publish(tokenResult)
+		return tokenResult
+	case <-ctx.Done():
+		// Release the port and our cooldown, so that another process can start over.
 		_ = listener.Close()
-		return TokenResult{err: errors.New("timed out")}
+		handoff.close()
+		ReleaseAuthCooldown(baseUrl)
+		return TokenResult{err: ctx.Err()}
 	}
 }
 
@@ -182,17 +248,23 @@ func getTokenResult(tokenBytes []byte) TokenResult {
 	return tokenResult
 }
 
-func getInteractiveUrl(admin bool, baseUrl string, cmd string, localPort int) string {
+func getInteractiveUrl(admin bool, baseUrl string, cmd string, localPort int, state string) string {
 	adminFlag := ""
 	if admin {
 		adminFlag = "&isAdmin=true"
 	}
-	url := fmt.Sprintf("%s/app/user/verify-token?localAppName=%s&localPort=%d%s&redirect=true", baseUrl, cmd, localPort, adminFlag)
+	url := fmt.Sprintf("%s/app/user/verify-token?localAppName=%s&localPort=%d%s&redirect=true&state=%s", baseUrl, cmd, localPort, adminFlag, url.QueryEscape(state))
 	return url
 }
 
-func MustTokenInteractive(host string, admin bool, cmd string, port int) (tokenResult TokenResult) {
-	tokenResult = TokenViaListener(host, admin, cmd, port, 180*time.Second)
-	DieIf(tokenResult.err, "failed to get token from interactive session (timed out or canceled)")
-	return
+// InteractiveAuthenticator returns an authenticator that gets tokens from an interactive browser session,
+// with the local web server of the given command.
+func InteractiveAuthenticator(cmd string, port int) jit.Authenticator {
+	return jit.AuthenticatorFunc(func(ctx context.Context, host string, admin bool) (*jit.Token, error) {
+		tokenResult := TokenViaListener(ctx, host, admin, cmd, port, 180*time.Second)
+		if tokenResult.err != nil {
+			return nil, tokenResult.err
+		}
+		return &jit.Token{Token: tokenResult.Token, OTP: tokenResult.OTP}, nil
+	})
 }
@@ -0,0 +1,75 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestGetInteractiveUrl_State(t *testing.T) {
+	got := getInteractiveUrl(true, "https://test.example.com", "duplo-jit", 8080, "a+b/c")
+	want := "https://test.example.com/app/user/verify-token?localAppName=duplo-jit&localPort=8080&isAdmin=true&redirect=true&state=a%2Bb%2Fc"
+	if got != want {
+		t.Errorf("getInteractiveUrl() = %s, want %s", got, want)
+	}
+}
+
+func TestNewCallbackState(t *testing.T) {
+	a, b := newCallbackState(), newCallbackState()
+	if len(a) < 40 || a == b {
+		t.Errorf("expected long, random states, got %q and %q", a, b)
+	}
+}
+
+func TestHandlerToken_State(t *testing.T) {
+	const baseUrl = "https://test.example.com"
+	const state = "session-state"
+
+	tests := []struct {
+		name          string
+		method        string
+		query         string
+		allowNoState  bool
+		wantStatus    int
+		wantCompleted bool
+		wantToken     string
+	}{
+		{"GET with state", "GET", "t=token-1&state=session-state", false, http.StatusFound, true, "token-1"},
+		{"GET without state", "GET", "t=token-1", false, http.StatusForbidden, false, ""},
+		{"GET with wrong state", "GET", "t=token-1&state=attacker", false, http.StatusForbidden, false, ""},
+		{"GET without state, allowed", "GET", "t=token-1", true, http.StatusFound, true, "token-1"},
+		{"GET with wrong state, allowed", "GET", "t=token-1&state=attacker", true, http.StatusForbidden, false, ""},
+		{"POST with state", "POST", "state=session-state", false, http.StatusOK, true, "token-2"},
+		{"POST without state", "POST", "", false, http.StatusForbidden, false, ""},
+		{"POST without state, allowed", "POST", "", true, http.StatusOK, true, "token-2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ConfigureCallback(tt.allowNoState)
+			defer ConfigureCallback(false)
+
+			req := httptest.NewRequest(tt.method, "http://127.0.0.1:8080/v2/callbackWithOtp?"+tt.query, strings.NewReader("token-2"))
+			req.Header.Set("Origin", baseUrl)
+			res := httptest.NewRecorder()
+
+			completed, tokenBytes := handlerToken(baseUrl, 8080, false, state, res, req)
+			if res.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d", tt.wantStatus, res.Code)
+			}
+			if completed != tt.wantCompleted || string(tokenBytes) != tt.wantToken {
+				t.Errorf("expected %v, %q, got %v, %q", tt.wantCompleted, tt.wantToken, completed, tokenBytes)
+			}
+
+			// The redirect back to Duplo never includes the state.
+			if location := res.Header().Get("Location"); location != "" {
+				u, err := url.Parse(location)
+				if err != nil || u.Query().Get("state") != "" || u.Query().Get("success") != "true" {
+					t.Errorf("unexpected redirect: %s", location)
+				}
+			}
+		})
+	}
+}
@@ -2,12 +2,15 @@ package internal
 
 import (
 	"context"
-	"encoding/base64"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"time"
 
 	"github.com/duplocloud/duplo-jit/duplocloud"
+	"github.com/duplocloud/duplo-jit/jit"
 	"k8s.io/client-go/kubernetes"
 	rest "k8s.io/client-go/rest"
 
@@ -15,63 +18,33 @@ import (
 	clientauthv1beta1 "k8s.io/client-go/pkg/apis/clientauthentication/v1beta1"
 )
 
-func ConvertK8sCreds(creds *duplocloud.DuploPlanK8ClusterConfig) *clientauthv1beta1.ExecCredential {
-	// Populate cluster info.
-	cluster := clientauthv1beta1.Cluster{Server: creds.ApiServer}
+func OutputK8sCreds(result *jit.K8sResult) {
 
-	// Populate CA certificate data.
-	if creds.CertificateAuthorityDataBase64 != "" {
-		data, err := base64.StdEncoding.DecodeString(creds.CertificateAuthorityDataBase64)
-		DieIf(err, "failed to base64 decode CA certificate data")
-		cluster.CertificateAuthorityData = data
-	} else {
-		cluster.InsecureSkipTLSVerify = true
-	}
-
-	// Populate token.
-	status := clientauthv1beta1.ExecCredentialStatus{Token: creds.Token}
-
-	// Populate expiration time.
-	var expiration time.Time
-	if creds.LastTokenRefreshTime != nil {
-		expiration = *creds.LastTokenRefreshTime
-
-		// Default expiration time: 55 minutes
-	} else {
-		expiration = time.Now().Add(time.Duration(60*55) * time.Second)
-	}
-	status.ExpirationTimestamp = &metav1.Time{Time: expiration}
-
-	return &clientauthv1beta1.ExecCredential{
-		TypeMeta: metav1.TypeMeta{
-			Kind:       "ExecCredential",
-			APIVersion: "client.authentication.k8s.io/v1beta1",
-		},
-		Spec: clientauthv1beta1.ExecCredentialSpec{
-			Cluster: &cluster,
-		},
-		Status: &status,
+	// Record the creds in the audit log.
+	creds := result.Credentials
+	json := mustMarshalJSON(creds)
+	var expiration string
+	if creds.Status != nil && creds.Status.ExpirationTimestamp != nil {
+		expiration = creds.Status.ExpirationTimestamp.UTC().Format(time.RFC3339)
 	}
-}
-
-func OutputK8sCreds(creds *clientauthv1beta1.ExecCredential, cacheKey string) {
-
-	// Write the creds to the cache.
-	cacheFile := fmt.Sprintf("%s,k8s-creds.json", cacheKey)
-	json := cacheWriteMustMarshal(cacheFile, creds)
+	entry := newAuditEntry("k8s", result.CacheKey, result.FromCache, expiration)
+	entry.Reason = result.Reason
+	writeAuditEntry(entry)
 
 	// Write the creds to the output.
 	_, _ = os.Stdout.Write(json)
 	_, _ = os.Stdout.WriteString("\n")
 }
 
-func PingK8sCreds(creds *clientauthv1beta1.ExecCredential, tenantName string) error {
+func PingK8sCreds(ctx context.Context, creds *clientauthv1beta1.ExecCredential, tenantName string) error {
+	t, err := k8sTransport(creds)
+	if err != nil {
+		return err
+	}
 	config := &rest.Config{
-		Host: creds.Spec.Cluster.Server,
-		TLSClientConfig: rest.TLSClientConfig{
-			Insecure: true,
-		},
+		Host:        creds.Spec.Cluster.Server,
 		BearerToken: creds.Status.Token,
+		Transport:   t,
 	}
 
 	clientset, err := kubernetes.NewForConfig(config)
@@ -86,10 +59,31 @@ func PingK8sCreds(creds *clientauthv1beta1.ExecCredential, tenantName string) er
 		namespace = fmt.Sprintf("duploservices-%s", namespace)
 	}
 
-	_, err = clientset.CoreV1().ServiceAccounts(namespace).List(context.TODO(), metav1.ListOptions{})
+	_, err = clientset.CoreV1().ServiceAccounts(namespace).List(ctx, metav1.ListOptions{})
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// k8sTransport returns the transport that validates Kubernetes credentials, with the configured proxy
+// and minimum TLS version.  It trusts the cluster CA of the credentials or, without one, the configured
+// CA bundle.  The Duplo client certificate is never sent to the cluster.
+func k8sTransport(creds *clientauthv1beta1.ExecCredential) (*http.Transport, error) {
+	opts := transportOptions
+	opts.ClientCert, opts.ClientKey = "", ""
+	t, err := duplocloud.NewTransport(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if cluster := creds.Spec.Cluster; cluster != nil && len(cluster.CertificateAuthorityData) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(cluster.CertificateAuthorityData) {
+			return nil, errors.New("invalid cluster CA certificate data")
+		}
+		t.TLSClientConfig.RootCAs = pool
+	}
+	return t, nil
+}
@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/duplocloud/duplo-jit/duplocloud"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientauthv1beta1 "k8s.io/client-go/pkg/apis/clientauthentication/v1beta1"
+)
+
+func TestPingK8sCreds(t *testing.T) {
+	k8s := httptest.NewTLSServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer k8s-token" {
+			res.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		res.Header().Set("Content-Type", "application/json")
+		_, _ = res.Write([]byte(`{"kind": "ServiceAccountList", "apiVersion": "v1", "items": []}`))
+	}))
+	defer k8s.Close()
+	caData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: k8s.Certificate().Raw})
+
+	creds := func(caData []byte) *clientauthv1beta1.ExecCredential {
+		return &clientauthv1beta1.ExecCredential{
+			Spec: clientauthv1beta1.ExecCredentialSpec{Cluster: &clientauthv1beta1.Cluster{Server: k8s.URL, CertificateAuthorityData: caData}},
+			Status: &clientauthv1beta1.ExecCredentialStatus{
+				Token:               "k8s-token",
+				ExpirationTimestamp: &metav1.Time{Time: time.Now().Add(time.Hour)},
+			},
+		}
+	}
+
+	// The cluster is trusted through the CA of the credentials.
+	if err := PingK8sCreds(context.Background(), creds(caData), "dev"); err != nil {
+		t.Errorf("PingK8sCreds() error: %v", err)
+	}
+
+	// Without it, the cluster certificate is verified, not ignored.
+	if err := PingK8sCreds(context.Background(), creds(nil), "dev"); err == nil {
+		t.Error("expected an unknown certificate authority")
+	}
+}
+
+func TestK8sTransport(t *testing.T) {
+	saved := transportOptions
+	t.Cleanup(func() { transportOptions = saved })
+	transportOptions = duplocloud.TransportOptions{Proxy: "http://proxy.example.com:3128", MinTLSVersion: "1.3"}
+
+	// The proxy and minimum TLS version apply to the cluster.
+	k8s, err := k8sTransport(&clientauthv1beta1.ExecCredential{Spec: clientauthv1beta1.ExecCredentialSpec{Cluster: &clientauthv1beta1.Cluster{}}})
+	if err != nil {
+		t.Fatalf("k8sTransport() error: %v", err)
+	}
+	req, _ := http.NewRequest("GET", "https://cluster.example.com/api", nil)
+	if proxy, _ := k8s.Proxy(req); proxy == nil || proxy.Host != "proxy.example.com:3128" {
+		t.Errorf("unexpected proxy %v", proxy)
+	}
+	if k8s.TLSClientConfig.MinVersion != tls.VersionTLS13 || k8s.TLSClientConfig.InsecureSkipVerify || len(k8s.TLSClientConfig.Certificates) != 0 {
+		t.Errorf("unexpected TLS configuration: %+v", k8s.TLSClientConfig)
+	}
+
+	// Invalid CA data is an error.
+	if _, err := k8sTransport(&clientauthv1beta1.ExecCredential{Spec: clientauthv1beta1.ExecCredentialSpec{Cluster: &clientauthv1beta1.Cluster{CertificateAuthorityData: []byte("junk")}}}); err == nil {
+		t.Error("expected an error for invalid CA data")
+	}
+}
@@ -0,0 +1,79 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/duplocloud/duplo-jit/duplocloud"
+)
+
+// logToStderr is false when logs are written to a file, so that fatal errors must also be shown on stderr.
+var logToStderr = true
+
+// ParseLogLevel converts a log level name into a slog level.
+func ParseLogLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "trace":
+		return duplocloud.LevelTrace, nil
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unsupported log level '%s': must be trace, debug, info, warn or error", level)
+}
+
+// MustInitLogging configures the default logger with the given level and format ("text" or "json"),
+// writing to the given file or to stderr.  Logs are never written to stdout, which holds credentials.
+func MustInitLogging(level string, format string, file string) {
+	lvl, err := ParseLogLevel(level)
+	DieIf(err, "invalid arguments")
+
+	var out io.Writer = os.Stderr
+	if file != "" {
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		DieIf(err, "cannot open log file")
+		out = f
+		logToStderr = false
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl, ReplaceAttr: replaceLevelName}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "", "text":
+		handler = slog.NewTextHandler(out, opts)
+	case "json":
+		handler = slog.NewJSONHandler(out, opts)
+	default:
+		Fatal(fmt.Sprintf("unsupported log format '%s': must be text or json", format), nil)
+	}
+
+	logger := slog.New(handler).With("cmd", cmdName(), "pid", os.Getpid())
+	slog.SetDefault(logger)
+}
+
+// replaceLevelName gives the trace level a readable name.
+func replaceLevelName(groups []string, a slog.Attr) slog.Attr {
+	if a.Key == slog.LevelKey && len(groups) == 0 {
+		if level, ok := a.Value.Any().(slog.Level); ok && level == duplocloud.LevelTrace {
+			a.Value = slog.StringValue("TRACE")
+		}
+	}
+	return a
+}
+
+// cmdName returns the name of the running command, without its directory.
+func cmdName() string {
+	name := os.Args[0]
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
@@ -0,0 +1,163 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/duplocloud/duplo-jit/duplocloud"
+)
+
+// credentialCacheDirs are the cache directories of both commands, relative to the user cache dir.
+var credentialCacheDirs = []string{"duplo-jit", "duplo-aws-credential-process"}
+
+// authCooldownDir is the auth cooldown directory, relative to the user cache dir.
+const authCooldownDir = "duplo-jit-auth"
+
+// LogoutResult reports what was done to log out of a host.
+type LogoutResult struct {
+	Host    string
+	Revoke  string
+	Removed []string
+}
+
+// Logout revokes the Duplo token for the host, where the portal supports it, then removes all
+// cached credentials, auth cooldown files and hand-off sockets for the host.  If token is empty, the cached token is revoked.
+func Logout(ctx context.Context, host, apiHost, token string) *LogoutResult {
+	userCacheDir, err := os.UserCacheDir()
+	DieIf(err, "cannot find cache directory")
+
+	hostKey := GetHostCacheKey(host)
+	result := &LogoutResult{Host: hostKey}
+
+	// Revoke the token.
+	if token == "" {
+		token = readCachedDuploToken(userCacheDir, hostKey)
+	}
+	result.Revoke = revokeDuploToken(ctx, apiHost, token)
+
+	// Remove cached credentials.
+	for _, dir := range credentialCacheDirs {
+		entries, _ := os.ReadDir(filepath.Join(userCacheDir, dir))
+		for _, entry := range entries {
+			if !entry.IsDir() && strings.HasPrefix(entry.Name(), hostKey+",") {
+				result.removeFile(filepath.Join(userCacheDir, dir, entry.Name()))
+			}
+		}
+	}
+
+	// Remove the auth cooldown file and hand-off socket.
+	for _, authPath := range []func(string) (string, error){authCooldownPath, authHandoffPath} {
+		path, err := authPath(host)
+		DieIf(err, "cannot find auth cooldown files")
+		result.removeFile(path)
+	}
+
+	return result
+}
+
+// LogoutAll logs out of every host with cached credentials or auth cooldown files.
+func LogoutAll(ctx context.Context) []*LogoutResult {
+	userCacheDir, err := os.UserCacheDir()
+	DieIf(err, "cannot find cache directory")
+
+	// Collect the hosts.
+	hosts := map[string]bool{}
+	for _, dir := range credentialCacheDirs {
+		entries, _ := os.ReadDir(filepath.Join(userCacheDir, dir))
+		for _, entry := range entries {
+			if hostKey, _, ok := strings.Cut(entry.Name(), ","); ok && !entry.IsDir() {
+				hosts[hostKey] = true
+			}
+		}
+	}
+	entries, _ := os.ReadDir(filepath.Join(userCacheDir, authCooldownDir))
+	for _, entry := range entries {
+		if hostKey, ok := strings.CutSuffix(entry.Name(), ".cooldown"); ok && !entry.IsDir() {
+			hosts[hostKey] = true
+		}
+	}
+
+	hostKeys := make([]string, 0, len(hosts))
+	for hostKey := range hosts {
+		hostKeys = append(hostKeys, hostKey)
+	}
+	sort.Strings(hostKeys)
+
+	// Log out of each host.  Only the hostname is cached, so the portal is assumed to use https.
+	results := make([]*LogoutResult, 0, len(hostKeys))
+	for _, hostKey := range hostKeys {
+		host := "https://" + hostKey
+		results = append(results, Logout(ctx, host, host, ""))
+	}
+	return results
+}
+
+// OutputLogout reports the logout results.
+func OutputLogout(results []*LogoutResult) {
+	if len(results) == 0 {
+		fmt.Fprintln(os.Stderr, "No cached credentials found")
+		return
+	}
+	for _, result := range results {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", result.Host, result.Revoke)
+		for _, file := range result.Removed {
+			fmt.Fprintf(os.Stderr, "  removed %s\n", file)
+		}
+		if len(result.Removed) == 0 {
+			fmt.Fprintln(os.Stderr, "  no cached files")
+		}
+	}
+}
+
+// removeFile removes a file, recording it if it existed.
+func (r *LogoutResult) removeFile(path string) {
+	err := os.Remove(path)
+	if err == nil {
+		r.Removed = append(r.Removed, path)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		Fatal(fmt.Sprintf("%s: unable to remove cached file", path), err)
+	}
+}
+
+// readCachedDuploToken reads the cached Duplo token for a host, or returns an empty string.
+func readCachedDuploToken(userCacheDir, hostKey string) string {
+	data, err := os.ReadFile(filepath.Join(userCacheDir, "duplo-jit", hostKey+",duplo-creds.json"))
+	if err != nil {
+		return ""
+	}
+	creds := DuploCredsOutput{}
+	if json.Unmarshal(data, &creds) != nil {
+		return ""
+	}
+	return creds.DuploToken
+}
+
+// revokeDuploToken invalidates the token, and describes the outcome.
+func revokeDuploToken(ctx context.Context, apiHost, token string) string {
+	if token == "" {
+		return "no Duplo token to revoke"
+	}
+
+	client, err := NewDuploClient(apiHost, token, "")
+	if err != nil {
+		return fmt.Sprintf("Duplo token not revoked: %s", err)
+	}
+
+	cerr := client.LogoutContext(ctx)
+	switch {
+	case cerr == nil:
+		return "Duplo token revoked"
+	case cerr.PossibleMissingAPI(), cerr.Status() == http.StatusMethodNotAllowed, cerr.Status() == http.StatusNotImplemented:
+		return "Duplo token not revoked: not supported by this portal, it will expire on its own"
+	case errors.Is(cerr, duplocloud.ErrNotAuthenticated):
+		return "Duplo token was already invalid"
+	}
+	return fmt.Sprintf("Duplo token not revoked: %s", cerr)
+}
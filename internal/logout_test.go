@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeCacheFiles creates empty files in a directory below the user cache dir.
+func writeCacheFiles(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	userCacheDir, err := os.UserCacheDir()
+	if err != nil {
+		t.Fatalf("failed to get user cache dir: %v", err)
+	}
+	dir = filepath.Join(userCacheDir, dir)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		t.Fatalf("failed to create cache dir: %v", err)
+	}
+	for _, name := range names {
+		content := "{}"
+		if strings.HasSuffix(name, ",duplo-creds.json") {
+			content = `{"Version":1,"DuploToken":"cached-token"}`
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatalf("failed to write cache file: %v", err)
+		}
+	}
+}
+
+func TestLogout(t *testing.T) {
+	setupTestHost(t)
+
+	var revokedTokens []string
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.Method != "POST" || req.URL.Path != "/admin/Logout" {
+			res.WriteHeader(http.StatusNotFound)
+			return
+		}
+		revokedTokens = append(revokedTokens, req.Header.Get("Authorization"))
+		_, _ = res.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+	hostKey := GetHostCacheKey(srv.URL)
+
+	writeCacheFiles(t, "duplo-jit", hostKey+",duplo-creds.json", hostKey+",admin,aws-creds.json", hostKey+",tenants.json", "other.example.com,duplo-creds.json")
+	writeCacheFiles(t, "duplo-aws-credential-process", hostKey+",tenant,dev01,aws-creds.json")
+	writeCacheFiles(t, authCooldownDir, hostKey+".cooldown", "other.example.com.admin.cooldown")
+	handoffPath, err := authHandoffPath(srv.URL)
+	if err != nil {
+		t.Fatalf("authHandoffPath() error: %v", err)
+	}
+	writeCacheFiles(t, authCooldownDir, filepath.Base(handoffPath))
+
+	result := Logout(context.Background(), srv.URL, srv.URL, "")
+
+	if result.Revoke != "Duplo token revoked" {
+		t.Errorf("unexpected revoke outcome: %s", result.Revoke)
+	}
+	if len(revokedTokens) != 1 || revokedTokens[0] != "Bearer cached-token" {
+		t.Errorf("expected the cached token to be revoked, got %v", revokedTokens)
+	}
+	if len(result.Removed) != 6 {
+		t.Errorf("expected 6 removed files, got %v", result.Removed)
+	}
+	for _, file := range result.Removed {
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed", file)
+		}
+		if strings.Contains(file, "other.example.com") {
+			t.Errorf("did not expect %s to be removed", file)
+		}
+	}
+
+	// Logging out again removes nothing.
+	result = Logout(context.Background(), srv.URL, srv.URL, "")
+	if result.Revoke != "no Duplo token to revoke" || len(result.Removed) != 0 {
+		t.Errorf("unexpected second logout: %+v", result)
+	}
+}
+
+func TestLogout_NotSupported(t *testing.T) {
+	setupTestHost(t)
+
+	// Portals without the API answer with a 404 or a 500.
+	for _, status := range []int{http.StatusNotFound, http.StatusInternalServerError} {
+		srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			res.WriteHeader(status)
+		}))
+
+		result := Logout(context.Background(), srv.URL, srv.URL, "explicit-token")
+		if !strings.Contains(result.Revoke, "not supported by this portal") {
+			t.Errorf("%d: unexpected revoke outcome: %s", status, result.Revoke)
+		}
+		srv.Close()
+	}
+}
@@ -0,0 +1,164 @@
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+var otpCode string
+var otpSecretFile string
+
+// ConfigureOtp configures how OTP codes are obtained for non-interactive logins: either the given
+// code, or a code generated from the TOTP secret in the given file.
+func ConfigureOtp(code string, secretFile string) {
+	otpCode = strings.TrimSpace(code)
+	otpSecretFile = secretFile
+}
+
+// TOTPKey holds the parameters of an RFC 6238 time-based one-time password.
+type TOTPKey struct {
+	Secret    []byte
+	Digits    int
+	Period    time.Duration
+	Algorithm string
+}
+
+// ParseTOTPKey parses a base32 TOTP secret, or an otpauth://totp/ URI as found in QR codes.
+func ParseTOTPKey(s string) (*TOTPKey, error) {
+	key := &TOTPKey{Digits: 6, Period: 30 * time.Second, Algorithm: "SHA1"}
+	secret := strings.TrimSpace(s)
+
+	if strings.HasPrefix(secret, "otpauth://") {
+		u, err := url.Parse(secret)
+		if err != nil {
+			return nil, fmt.Errorf("invalid otpauth URI: %w", err)
+		}
+		if u.Host != "totp" {
+			return nil, fmt.Errorf("unsupported otpauth type '%s': must be totp", u.Host)
+		}
+		query := u.Query()
+		secret = query.Get("secret")
+		if digits := query.Get("digits"); digits != "" {
+			if key.Digits, err = strconv.Atoi(digits); err != nil || key.Digits < 6 || key.Digits > 8 {
+				return nil, fmt.Errorf("invalid otpauth digits '%s'", digits)
+			}
+		}
+		if period := query.Get("period"); period != "" {
+			seconds, err := strconv.Atoi(period)
+			if err != nil || seconds <= 0 {
+				return nil, fmt.Errorf("invalid otpauth period '%s'", period)
+			}
+			key.Period = time.Duration(seconds) * time.Second
+		}
+		if algorithm := query.Get("algorithm"); algorithm != "" {
+			key.Algorithm = strings.ToUpper(algorithm)
+		}
+	}
+
+	// Secrets are often shown in groups, in lower case, or without padding.
+	secret = strings.ToUpper(strings.NewReplacer(" ", "", "-", "").Replace(secret))
+	decoded, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(secret, "="))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base32 TOTP secret: %w", err)
+	}
+	if len(decoded) == 0 {
+		return nil, errors.New("empty TOTP secret")
+	}
+	key.Secret = decoded
+
+	if _, err := key.hash(); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (k *TOTPKey) hash() (func() hash.Hash, error) {
+	switch k.Algorithm {
+	case "", "SHA1":
+		return sha1.New, nil
+	case "SHA256":
+		return sha256.New, nil
+	case "SHA512":
+		return sha512.New, nil
+	}
+	return nil, fmt.Errorf("unsupported TOTP algorithm '%s'", k.Algorithm)
+}
+
+// Code generates the TOTP code for the given time.
+func (k *TOTPKey) Code(t time.Time) string {
+	newHash, err := k.hash()
+	DieIf(err, "invalid TOTP key")
+
+	// HOTP (RFC 4226) of the number of periods since the Unix epoch.
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(t.Unix())/uint64(k.Period/time.Second))
+	mac := hmac.New(newHash, k.Secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation.
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for i := 0; i < k.Digits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", k.Digits, value%modulo)
+}
+
+// OtpFromFlags returns the OTP code from --otp, or generated from --otp-secret-file, or an empty string.
+func OtpFromFlags() string {
+	if otpCode != "" {
+		return otpCode
+	}
+	if otpSecretFile == "" {
+		return ""
+	}
+
+	// The secret allows anyone to generate codes, so warn if it is readable by others.
+	info, err := os.Stat(otpSecretFile)
+	DieIf(err, "cannot read OTP secret file")
+	if info.Mode().Perm()&0o077 != 0 {
+		slog.Warn("OTP secret file is accessible by other users", "file", otpSecretFile, "mode", info.Mode().Perm().String())
+	}
+
+	data, err := os.ReadFile(otpSecretFile)
+	DieIf(err, "cannot read OTP secret file")
+	key, err := ParseTOTPKey(string(data))
+	DieIf(err, "invalid OTP secret file")
+	return key.Code(time.Now())
+}
+
+// mustOtpCode returns the OTP code from the flags or, from a terminal, prompts for it.
+// It returns an empty string if no code is available.
+func mustOtpCode() string {
+	if otp := OtpFromFlags(); otp != "" {
+		return otp
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stderr.Fd())) {
+		return ""
+	}
+
+	_, _ = fmt.Fprint(os.Stderr, "Duplo requires an OTP code for admin access.  Enter the code: ")
+	line, err := readLine()
+	if err != nil && line == "" {
+		return ""
+	}
+	return strings.TrimSpace(line)
+}
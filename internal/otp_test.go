@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B test vectors, using the ASCII secret "12345678901234567890" (and longer for SHA256/512).
+func TestTOTPKey_RFC6238(t *testing.T) {
+	sha1Key := &TOTPKey{Secret: []byte("12345678901234567890"), Digits: 8, Period: 30 * time.Second, Algorithm: "SHA1"}
+	sha256Key := &TOTPKey{Secret: []byte("12345678901234567890123456789012"), Digits: 8, Period: 30 * time.Second, Algorithm: "SHA256"}
+	sha512Key := &TOTPKey{Secret: []byte("1234567890123456789012345678901234567890123456789012345678901234"), Digits: 8, Period: 30 * time.Second, Algorithm: "SHA512"}
+
+	tests := []struct {
+		unix int64
+		key  *TOTPKey
+		want string
+	}{
+		{59, sha1Key, "94287082"},
+		{1111111109, sha1Key, "07081804"},
+		{1234567890, sha1Key, "89005924"},
+		{20000000000, sha1Key, "65353130"},
+		{59, sha256Key, "46119246"},
+		{1111111109, sha256Key, "68084774"},
+		{59, sha512Key, "90693936"},
+		{1234567890, sha512Key, "93441116"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.key.Code(time.Unix(tt.unix, 0)); got != tt.want {
+			t.Errorf("%s code at %d = %s, want %s", tt.key.Algorithm, tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestParseTOTPKey(t *testing.T) {
+	// "12345678901234567890" in base32.
+	const secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+	tests := []struct {
+		name    string
+		input   string
+		digits  int
+		period  time.Duration
+		wantErr bool
+	}{
+		{"base32", secret, 6, 30 * time.Second, false},
+		{"grouped lower case", "gezd gnbv gy3t qojq gezd gnbv gy3t qojq\n", 6, 30 * time.Second, false},
+		{"otpauth URI", "otpauth://totp/Duplo:ci?secret=" + secret + "&issuer=Duplo&digits=8&period=60", 8, 60 * time.Second, false},
+		{"HOTP URI", "otpauth://hotp/Duplo:ci?secret=" + secret, 0, 0, true},
+		{"not base32", "not-a-secret!", 0, 0, true},
+		{"empty", "", 0, 0, true},
+		{"bad algorithm", "otpauth://totp/x?secret=" + secret + "&algorithm=MD5", 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParseTOTPKey(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(key.Secret) != "12345678901234567890" || key.Digits != tt.digits || key.Period != tt.period {
+				t.Errorf("unexpected key: %+v", key)
+			}
+		})
+	}
+}
+
+func TestOtpFromFlags(t *testing.T) {
+	defer ConfigureOtp("", "")
+
+	ConfigureOtp(" 123456 ", "")
+	if got := OtpFromFlags(); got != "123456" {
+		t.Errorf("expected the --otp code, got %q", got)
+	}
+
+	file := filepath.Join(t.TempDir(), "totp")
+	if err := os.WriteFile(file, []byte("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ\n"), 0o600); err != nil {
+		t.Fatalf("failed to write secret: %v", err)
+	}
+	ConfigureOtp("", file)
+	got := OtpFromFlags()
+	key, _ := ParseTOTPKey("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ")
+	if want, previous := key.Code(time.Now()), key.Code(time.Now().Add(-30*time.Second)); got != want && got != previous {
+		t.Errorf("expected a generated code, got %q", got)
+	}
+
+	ConfigureOtp("", "")
+	if got := OtpFromFlags(); got != "" {
+		t.Errorf("expected no code, got %q", got)
+	}
+}
@@ -0,0 +1,124 @@
+package internal
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/duplocloud/duplo-jit/duplocloud"
+	clientcmdapiv1 "k8s.io/client-go/tools/clientcmd/api/v1"
+)
+
+type PlanOutput struct {
+	PlanID                         string `json:"PlanID"`
+	K8sProvider                    string `json:"K8sProvider,omitempty"` // the portal's K8Provider number
+	Region                         string `json:"Region,omitempty"`
+	K8sVersion                     string `json:"K8sVersion,omitempty"`
+	ApiServer                      string `json:"ApiServer,omitempty"`
+	CertificateAuthorityDataBase64 string `json:"CertificateAuthorityDataBase64,omitempty"`
+}
+
+// ConvertPlans converts plans from the Duplo API into plan output, sorted by plan ID.
+// Cluster tokens are never included in the output.
+func ConvertPlans(plans *[]duplocloud.DuploPlan) []PlanOutput {
+	out := make([]PlanOutput, 0, len(*plans))
+
+	for _, plan := range *plans {
+		item := PlanOutput{PlanID: plan.Name}
+		if k8s := plan.KubernetesConfig; k8s != nil {
+			item.K8sProvider = strconv.Itoa(k8s.K8Provider)
+			item.Region = k8s.AwsRegion
+			item.K8sVersion = k8s.K8sVersion
+			item.ApiServer = k8s.ApiServer
+			item.CertificateAuthorityDataBase64 = k8s.CertificateAuthorityDataBase64
+		}
+		out = append(out, item)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].PlanID < out[j].PlanID })
+	return out
+}
+
+// OutputPlans writes the plans in the requested format: "table", "json" or "kubeconfig".
+func OutputPlans(plans []PlanOutput, format string, host string) {
+	switch format {
+	case "", "table":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(w, "PLAN\tK8S PROVIDER\tREGION\tK8S VERSION\tAPI SERVER")
+		for _, plan := range plans {
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+				plan.PlanID, orDash(plan.K8sProvider), orDash(plan.Region), orDash(plan.K8sVersion), orDash(plan.ApiServer))
+		}
+		_ = w.Flush()
+
+	case "json":
+		outputJSON(plans)
+
+	case "kubeconfig":
+		outputJSON(plansKubeconfig(plans, host))
+
+	default:
+		Fatal(fmt.Sprintf("unsupported output format '%s'", format), nil)
+	}
+}
+
+// plansKubeconfig builds a kubeconfig with one context per plan that has a Kubernetes cluster.
+// Each context authenticates by running "duplo-jit k8s --plan" as an exec plugin.
+func plansKubeconfig(plans []PlanOutput, host string) *clientcmdapiv1.Config {
+	hostKey := GetHostCacheKey(host)
+	config := &clientcmdapiv1.Config{Kind: "Config", APIVersion: "v1"}
+
+	for _, plan := range plans {
+		if plan.ApiServer == "" {
+			continue
+		}
+		name := fmt.Sprintf("%s-%s", hostKey, plan.PlanID)
+
+		cluster := clientcmdapiv1.Cluster{Server: plan.ApiServer}
+		if plan.CertificateAuthorityDataBase64 != "" {
+			data, err := base64.StdEncoding.DecodeString(plan.CertificateAuthorityDataBase64)
+			DieIf(err, "failed to base64 decode CA certificate data")
+			cluster.CertificateAuthorityData = data
+		} else {
+			cluster.InsecureSkipTLSVerify = true
+		}
+
+		user := clientcmdapiv1.AuthInfo{
+			Exec: &clientcmdapiv1.ExecConfig{
+				APIVersion:      "client.authentication.k8s.io/v1beta1",
+				Command:         "duplo-jit",
+				Args:            []string{"k8s", "--plan", plan.PlanID, "--host", host, "--interactive"},
+				InteractiveMode: clientcmdapiv1.IfAvailableExecInteractiveMode,
+			},
+		}
+
+		config.Clusters = append(config.Clusters, clientcmdapiv1.NamedCluster{Name: name, Cluster: cluster})
+		config.AuthInfos = append(config.AuthInfos, clientcmdapiv1.NamedAuthInfo{Name: name, AuthInfo: user})
+		config.Contexts = append(config.Contexts, clientcmdapiv1.NamedContext{
+			Name:    name,
+			Context: clientcmdapiv1.Context{Cluster: name, AuthInfo: name},
+		})
+	}
+
+	return config
+}
+
+// outputJSON writes the source to the output as indented JSON.
+func outputJSON(source interface{}) {
+	jsonBytes, err := json.MarshalIndent(source, "", "  ")
+	DieIf(err, "cannot marshal to JSON")
+
+	_, _ = os.Stdout.Write(jsonBytes)
+	_, _ = os.Stdout.WriteString("\n")
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
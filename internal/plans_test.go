@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/duplocloud/duplo-jit/duplocloud"
+	clientcmdapiv1 "k8s.io/client-go/tools/clientcmd/api/v1"
+)
+
+func TestConvertPlans(t *testing.T) {
+	tests := []struct {
+		name  string
+		plans []duplocloud.DuploPlan
+		want  []PlanOutput
+	}{
+		{"no plans", []duplocloud.DuploPlan{}, []PlanOutput{}},
+		{"no cluster", []duplocloud.DuploPlan{{Name: "default"}}, []PlanOutput{{PlanID: "default"}}},
+		{
+			"cluster",
+			[]duplocloud.DuploPlan{{Name: "prod", KubernetesConfig: &duplocloud.DuploPlanK8ClusterConfig{
+				Name: "duploinfra-prod", ApiServer: "https://prod.example.com", Token: "secret", K8Provider: 2,
+				AwsRegion: "us-west-2", K8sVersion: "1.29", CertificateAuthorityDataBase64: "Y2E=",
+			}}},
+			[]PlanOutput{{
+				PlanID: "prod", K8sProvider: "2", Region: "us-west-2", K8sVersion: "1.29",
+				ApiServer: "https://prod.example.com", CertificateAuthorityDataBase64: "Y2E=",
+			}},
+		},
+		{
+			"sorted by plan ID",
+			[]duplocloud.DuploPlan{{Name: "prod"}, {Name: "dev", KubernetesConfig: &duplocloud.DuploPlanK8ClusterConfig{}}},
+			[]PlanOutput{{PlanID: "dev", K8sProvider: "0"}, {PlanID: "prod"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ConvertPlans(&tt.plans); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ConvertPlans() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlansKubeconfig(t *testing.T) {
+	host := "https://test.example.com"
+	exec := func(plan string) *clientcmdapiv1.ExecConfig {
+		return &clientcmdapiv1.ExecConfig{
+			APIVersion:      "client.authentication.k8s.io/v1beta1",
+			Command:         "duplo-jit",
+			Args:            []string{"k8s", "--plan", plan, "--host", host, "--interactive"},
+			InteractiveMode: clientcmdapiv1.IfAvailableExecInteractiveMode,
+		}
+	}
+
+	tests := []struct {
+		name     string
+		plans    []PlanOutput
+		clusters []clientcmdapiv1.NamedCluster
+	}{
+		{"no cluster", []PlanOutput{{PlanID: "default"}}, nil},
+		{
+			"CA data",
+			[]PlanOutput{{PlanID: "prod", ApiServer: "https://prod.example.com", CertificateAuthorityDataBase64: "Y2E="}},
+			[]clientcmdapiv1.NamedCluster{{Name: "test.example.com-prod", Cluster: clientcmdapiv1.Cluster{
+				Server: "https://prod.example.com", CertificateAuthorityData: []byte("ca"),
+			}}},
+		},
+		{
+			"no CA data",
+			[]PlanOutput{{PlanID: "dev", ApiServer: "https://dev.example.com"}},
+			[]clientcmdapiv1.NamedCluster{{Name: "test.example.com-dev", Cluster: clientcmdapiv1.Cluster{
+				Server: "https://dev.example.com", InsecureSkipTLSVerify: true,
+			}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := plansKubeconfig(tt.plans, host)
+			if got.Kind != "Config" || got.APIVersion != "v1" {
+				t.Errorf("unexpected kind or API version: %s %s", got.Kind, got.APIVersion)
+			}
+			if !reflect.DeepEqual(got.Clusters, tt.clusters) {
+				t.Errorf("Clusters = %+v, want %+v", got.Clusters, tt.clusters)
+			}
+
+			// Each cluster has a user and a context of the same name.
+			if len(got.AuthInfos) != len(tt.clusters) || len(got.Contexts) != len(tt.clusters) {
+				t.Fatalf("expected %d users and contexts, got %+v", len(tt.clusters), got)
+			}
+			for i, cluster := range tt.clusters {
+				plan := tt.plans[i].PlanID
+				if user := got.AuthInfos[i]; user.Name != cluster.Name || !reflect.DeepEqual(user.AuthInfo.Exec, exec(plan)) {
+					t.Errorf("unexpected user: %+v", user)
+				}
+				if ctx := got.Contexts[i]; ctx.Name != cluster.Name || ctx.Context.Cluster != cluster.Name || ctx.Context.AuthInfo != cluster.Name {
+					t.Errorf("unexpected context: %+v", ctx)
+				}
+			}
+		})
+	}
+}
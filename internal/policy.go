@@ -0,0 +1,173 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/duplocloud/duplo-jit/duplocloud"
+	"github.com/duplocloud/duplo-jit/jit"
+	"golang.org/x/term"
+)
+
+// RolePlan is the role of admin Kubernetes credentials for the cluster of a plan.
+const RolePlan = "plan"
+
+// ErrConfirmationRequired is returned when production credentials are not confirmed.
+var ErrConfirmationRequired = errors.New("confirmation required for production credentials")
+
+// Policy restricts which credentials duplo-jit issues.  Hosts are matched by host name, or by
+// path.Match patterns such as "*.duplocloud.net".
+type Policy struct {
+	// AllowedHosts, if any, are the only hosts that duplo-jit gets credentials for.
+	AllowedHosts []string `json:"AllowedHosts,omitempty"`
+
+	// Hosts are the policies of hosts.  The policies of all matching patterns apply.
+	Hosts map[string]HostPolicy `json:"Hosts,omitempty"`
+}
+
+// HostPolicy is the policy of a host.
+type HostPolicy struct {
+	// Production requires a confirmation for admin, duplo-ops and plan credentials.
+	Production bool `json:"Production,omitempty"`
+
+	// ProductionTenants are the names or IDs of tenants whose credentials require a confirmation.
+	ProductionTenants []string `json:"ProductionTenants,omitempty"`
+
+	// ForbidAdmin forbids admin, duplo-ops and plan credentials.
+	ForbidAdmin bool `json:"ForbidAdmin,omitempty"`
+
+	// RequireReason requires a reason for admin, duplo-ops and plan credentials.
+	RequireReason bool `json:"RequireReason,omitempty"`
+}
+
+var assumeYes bool
+
+// ConfigurePolicy configures whether production credentials are confirmed without asking.
+func ConfigurePolicy(yes bool) {
+	assumeYes = yes
+}
+
+// CheckHost returns an error if the policy does not allow the host.
+func (p *Policy) CheckHost(hostKey string) error {
+	if len(p.AllowedHosts) == 0 || matchHost(p.AllowedHosts, hostKey) {
+		return nil
+	}
+	return fmt.Errorf("host %s is not in the allowed hosts of the policy", hostKey)
+}
+
+// hostPolicy returns the policies of all the patterns that match the host, combined.
+func (p *Policy) hostPolicy(hostKey string) HostPolicy {
+	combined := HostPolicy{}
+	for pattern, policy := range p.Hosts {
+		if matchHost([]string{pattern}, hostKey) {
+			combined.Production = combined.Production || policy.Production
+			combined.ForbidAdmin = combined.ForbidAdmin || policy.ForbidAdmin
+			combined.RequireReason = combined.RequireReason || policy.RequireReason
+			combined.ProductionTenants = append(combined.ProductionTenants, policy.ProductionTenants...)
+		}
+	}
+	return combined
+}
+
+// Check returns whether credentials of the given role need a confirmation, or an error if the policy
+// forbids them.  The tenant is only needed for tenant credentials.
+func (p *Policy) Check(hostKey, role string, tenant *duplocloud.UserTenant) (bool, error) {
+	policy := p.hostPolicy(hostKey)
+	if role != jit.RoleTenant {
+		if policy.ForbidAdmin {
+			return false, fmt.Errorf("the policy forbids %s credentials for %s", role, hostKey)
+		}
+		return policy.Production, nil
+	}
+
+	for _, name := range policy.ProductionTenants {
+		if tenant != nil && (strings.EqualFold(name, tenant.TenantID) || jit.NormalizeTenantName(name) == jit.NormalizeTenantName(tenant.AccountName)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// RequiresReason returns true if credentials of the given role need a reason.
+func (p *Policy) RequiresReason(hostKey, role string) bool {
+	return role != jit.RoleTenant && p.hostPolicy(hostKey).RequireReason
+}
+
+// needsTenant returns true if the policy of the host depends on the tenant.
+func (p *Policy) needsTenant(hostKey string) bool {
+	return len(p.hostPolicy(hostKey).ProductionTenants) != 0
+}
+
+// matchHost returns true if one of the patterns matches the host.
+func matchHost(patterns []string, hostKey string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSuffix(strings.TrimPrefix(pattern, "https://"), "/"))
+		if ok, _ := path.Match(pattern, strings.ToLower(hostKey)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// MustAllowHost exits unless the policy allows the host.
+func MustAllowHost(host string) {
+	DieIf(currentConfig.Policy.CheckHost(GetHostCacheKey(host)), "host not allowed")
+}
+
+// authorized records the credentials that MustAuthorize already allowed.
+var authorized = map[string]bool{}
+
+// MustAuthorize exits unless the policy allows credentials of the given role, the user gives a reason
+// where the policy requires one, and the user confirms production credentials.  The tenant is only
+// needed for tenant credentials.  It returns the context of the JIT API calls, which send the reason.
+func MustAuthorize(ctx context.Context, session *jit.Session, role string, tenantIDorName string) context.Context {
+	hostKey := GetHostCacheKey(session.Host())
+
+	// Ask the user once per command.
+	key := strings.Join([]string{hostKey, role, tenantIDorName}, ",")
+	if authorized[key] {
+		return ReasonContext(ctx)
+	}
+
+	var tenant *duplocloud.UserTenant
+	if role == jit.RoleTenant && currentConfig.Policy.needsTenant(hostKey) {
+		var err error
+		tenant, err = session.Tenant(ctx, tenantIDorName)
+		DieIf(err, "failed to get credentials")
+	}
+
+	production, err := currentConfig.Policy.Check(hostKey, role, tenant)
+	DieIf(err, "credentials not allowed")
+	if currentConfig.Policy.RequiresReason(hostKey, role) {
+		mustReason(fmt.Sprintf("%s credentials for %s", role, hostKey))
+	}
+	if production {
+		what := fmt.Sprintf("%s credentials for production host %s", role, hostKey)
+		if tenant != nil {
+			what = fmt.Sprintf("credentials for production tenant %s on %s", tenant.AccountName, hostKey)
+		}
+		mustConfirm(what)
+	}
+	authorized[key] = true
+	return ReasonContext(ctx)
+}
+
+// mustConfirm exits unless the user confirms issuing the credentials, or passed --yes.
+func mustConfirm(what string) {
+	if assumeYes {
+		return
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stderr.Fd())) {
+		Fatal("cannot issue "+what, ErrConfirmationRequired)
+	}
+
+	_, _ = fmt.Fprintf(os.Stderr, "Issue %s? [y/N] ", what)
+	line, _ := readLine()
+	if answer := strings.ToLower(strings.TrimSpace(line)); answer != "y" && answer != "yes" {
+		Fatal("cannot issue "+what, errors.New("not confirmed"))
+	}
+}
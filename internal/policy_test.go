@@ -0,0 +1,96 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/duplocloud/duplo-jit/duplocloud"
+	"github.com/duplocloud/duplo-jit/jit"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+
+	// A missing file is an empty configuration.
+	cfg, err := LoadConfig(filepath.Join(dir, "missing.json"))
+	if err != nil || len(cfg.Policy.AllowedHosts) != 0 || len(cfg.Policy.Hosts) != 0 {
+		t.Errorf("LoadConfig() = %+v, %v", cfg, err)
+	}
+
+	path := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(path, []byte(`{"Policy": {"AllowedHosts": ["*.example.com"], "Hosts": {"prod.example.com": {"Production": true}}}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = LoadConfig(path)
+	if err != nil || cfg.Policy.AllowedHosts[0] != "*.example.com" || !cfg.Policy.Hosts["prod.example.com"].Production {
+		t.Errorf("LoadConfig() = %+v, %v", cfg, err)
+	}
+
+	// A misspelled policy is an error.
+	if err := os.WriteFile(path, []byte(`{"Policy": {"Hosts": {"prod.example.com": {"Prodution": true}}}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "Prodution") {
+		t.Errorf("expected an unknown field error, got %v", err)
+	}
+}
+
+func TestPolicy_CheckHost(t *testing.T) {
+	tests := []struct {
+		allowed []string
+		host    string
+		want    bool
+	}{
+		{nil, "any.example.com", true},
+		{[]string{"dev.example.com"}, "dev.example.com", true},
+		{[]string{"https://dev.example.com/"}, "DEV.example.com", true},
+		{[]string{"*.example.com"}, "prod.example.com", true},
+		{[]string{"*.example.com"}, "example.org", false},
+		{[]string{"dev.example.com"}, "prod.example.com", false},
+	}
+	for _, tt := range tests {
+		policy := &Policy{AllowedHosts: tt.allowed}
+		if err := policy.CheckHost(tt.host); (err == nil) != tt.want {
+			t.Errorf("CheckHost(%q) with %v = %v, want allowed %v", tt.host, tt.allowed, err, tt.want)
+		}
+	}
+}
+
+func TestPolicy_Check(t *testing.T) {
+	policy := &Policy{Hosts: map[string]HostPolicy{
+		"*.example.com":    {ProductionTenants: []string{"prod"}},
+		"prod.example.com": {Production: true, ProductionTenants: []string{"22222222-2222-2222-2222-222222222222"}},
+		"dev.example.com":  {ForbidAdmin: true},
+	}}
+	dev := &duplocloud.UserTenant{TenantID: "11111111-1111-1111-1111-111111111111", AccountName: "dev"}
+	prod := &duplocloud.UserTenant{TenantID: "33333333-3333-3333-3333-333333333333", AccountName: "prod"}
+	live := &duplocloud.UserTenant{TenantID: "22222222-2222-2222-2222-222222222222", AccountName: "live"}
+
+	tests := []struct {
+		host        string
+		role        string
+		tenant      *duplocloud.UserTenant
+		wantConfirm bool
+		wantErr     bool
+	}{
+		{"prod.example.com", jit.RoleAdmin, nil, true, false},
+		{"prod.example.com", jit.RoleDuploOps, nil, true, false},
+		{"prod.example.com", RolePlan, nil, true, false},
+		{"prod.example.com", jit.RoleTenant, dev, false, false},
+		{"prod.example.com", jit.RoleTenant, prod, true, false},
+		{"prod.example.com", jit.RoleTenant, live, true, false},
+		{"dev.example.com", jit.RoleAdmin, nil, false, true},
+		{"dev.example.com", RolePlan, nil, false, true},
+		{"dev.example.com", jit.RoleTenant, prod, true, false},
+		{"dev.example.com", jit.RoleTenant, dev, false, false},
+		{"other.example.org", jit.RoleAdmin, nil, false, false},
+	}
+	for _, tt := range tests {
+		confirm, err := policy.Check(tt.host, tt.role, tt.tenant)
+		if confirm != tt.wantConfirm || (err != nil) != tt.wantErr {
+			t.Errorf("Check(%q, %q, %v) = %v, %v", tt.host, tt.role, tt.tenant, confirm, err)
+		}
+	}
+}
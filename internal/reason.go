@@ -0,0 +1,53 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/duplocloud/duplo-jit/duplocloud"
+	"golang.org/x/term"
+)
+
+// ErrReasonRequired is returned when the policy requires a reason for credentials, but none was given.
+var ErrReasonRequired = errors.New("the policy requires a reason for these credentials")
+
+var jitReason string
+
+// ConfigureReason configures the reason for credentials, such as "INC-1234: restore the orders database".
+// It is sent to the portal, and recorded in the audit log.
+func ConfigureReason(reason string) error {
+	reason = strings.TrimSpace(reason)
+	if strings.ContainsFunc(reason, unicode.IsControl) {
+		return errors.New("the reason must be a single line of text")
+	}
+	jitReason = reason
+	return nil
+}
+
+// ReasonContext returns a context whose JIT API calls send the configured reason, if any.
+func ReasonContext(ctx context.Context) context.Context {
+	if jitReason == "" {
+		return ctx
+	}
+	return duplocloud.WithJitReason(ctx, jitReason)
+}
+
+// mustReason exits unless a reason was given or, from a terminal, the user enters one.
+func mustReason(what string) {
+	if jitReason != "" {
+		return
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stderr.Fd())) {
+		Fatal("cannot issue "+what, ErrReasonRequired)
+	}
+
+	_, _ = fmt.Fprintf(os.Stderr, "Enter the reason for %s (such as INC-1234: ...): ", what)
+	line, _ := readLine()
+	if err := ConfigureReason(line); err != nil || jitReason == "" {
+		Fatal("cannot issue "+what, ErrReasonRequired)
+	}
+}
@@ -0,0 +1,44 @@
+package internal
+
+import (
+	"context"
+	"testing"
+
+	"github.com/duplocloud/duplo-jit/duplocloud"
+	"github.com/duplocloud/duplo-jit/jit"
+)
+
+func TestConfigureReason(t *testing.T) {
+	defer func() { jitReason = "" }()
+
+	if err := ConfigureReason("  INC-1234: restore the database \n"); err != nil || jitReason != "INC-1234: restore the database" {
+		t.Errorf("ConfigureReason() = %v, reason %q", err, jitReason)
+	}
+	if got := duplocloud.JitReason(ReasonContext(context.Background())); got != jitReason {
+		t.Errorf("expected the reason in the context, got %q", got)
+	}
+	if err := ConfigureReason("INC-1234\nsecond line"); err == nil {
+		t.Error("expected an error for a multi-line reason")
+	}
+
+	// Without a reason, the context is unchanged.
+	if err := ConfigureReason(""); err != nil {
+		t.Fatal(err)
+	}
+	if ctx := context.Background(); ReasonContext(ctx) != ctx {
+		t.Error("expected the same context without a reason")
+	}
+}
+
+func TestPolicy_RequiresReason(t *testing.T) {
+	policy := &Policy{Hosts: map[string]HostPolicy{"*.example.com": {RequireReason: true}}}
+
+	for _, role := range []string{jit.RoleAdmin, jit.RoleDuploOps, RolePlan} {
+		if !policy.RequiresReason("prod.example.com", role) {
+			t.Errorf("expected %s credentials to require a reason", role)
+		}
+	}
+	if policy.RequiresReason("prod.example.com", jit.RoleTenant) || policy.RequiresReason("example.org", jit.RoleAdmin) {
+		t.Error("expected no reason to be required")
+	}
+}
@@ -0,0 +1,44 @@
+package internal
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/duplocloud/duplo-jit/jit"
+)
+
+// RetentionPolicy is how long a class of credentials stays in the cache.  Without one, credentials are
+// cached until they expire.
+type RetentionPolicy struct {
+	MaxAge       string `json:"MaxAge,omitempty"` // such as "15m"
+	NoCache      bool   `json:"NoCache,omitempty"`
+	WipeOnLogout bool   `json:"WipeOnLogout,omitempty"`
+}
+
+// sessionRetention converts the retention policies of a configuration, keyed by class of credentials.
+func sessionRetention(policies map[string]RetentionPolicy) (map[string]jit.Retention, error) {
+	retention := map[string]jit.Retention{}
+	for class, policy := range policies {
+		if !slices.Contains(jit.Classes, class) {
+			return nil, fmt.Errorf("unknown class of credentials '%s' in the retention policy, expected one of %v", class, jit.Classes)
+		}
+		if policy.NoCache && (policy.MaxAge != "" || policy.WipeOnLogout) {
+			return nil, fmt.Errorf("the retention policy of %s cannot combine NoCache with other settings", class)
+		}
+		if policy.WipeOnLogout && class == jit.ClassDuploToken {
+			return nil, fmt.Errorf("WipeOnLogout does not apply to %s, which logging out always removes", class)
+		}
+
+		r := jit.Retention{NoCache: policy.NoCache, WipeOnLogout: policy.WipeOnLogout}
+		if policy.MaxAge != "" {
+			maxAge, err := time.ParseDuration(policy.MaxAge)
+			if err != nil || maxAge <= 0 {
+				return nil, fmt.Errorf("invalid MaxAge '%s' in the retention policy of %s", policy.MaxAge, class)
+			}
+			r.MaxAge = maxAge
+		}
+		retention[class] = r
+	}
+	return retention, nil
+}
@@ -0,0 +1,36 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/duplocloud/duplo-jit/jit"
+)
+
+func TestSessionRetention(t *testing.T) {
+	retention, err := sessionRetention(map[string]RetentionPolicy{
+		jit.ClassAdmin:     {MaxAge: "15m"},
+		jit.ClassDuploOps:  {NoCache: true},
+		jit.ClassTenantAws: {MaxAge: "8h", WipeOnLogout: true},
+	})
+	if err != nil {
+		t.Fatalf("sessionRetention() error: %v", err)
+	}
+	if retention[jit.ClassAdmin].MaxAge != 15*time.Minute || !retention[jit.ClassDuploOps].NoCache ||
+		retention[jit.ClassTenantAws].MaxAge != 8*time.Hour || !retention[jit.ClassTenantAws].WipeOnLogout {
+		t.Errorf("unexpected retention: %+v", retention)
+	}
+
+	invalid := []map[string]RetentionPolicy{
+		{"admins": {NoCache: true}},
+		{jit.ClassAdmin: {MaxAge: "15"}},
+		{jit.ClassAdmin: {MaxAge: "-1h"}},
+		{jit.ClassAdmin: {MaxAge: "15m", NoCache: true}},
+		{jit.ClassDuploToken: {WipeOnLogout: true}},
+	}
+	for _, policies := range invalid {
+		if _, err := sessionRetention(policies); err == nil {
+			t.Errorf("expected an error for %+v", policies)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"bufio"
+	"os"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// readStdinLine and readStdinPassword read a line of stdin, with and without echo.
+var readStdinLine = func() (string, error) {
+	return bufio.NewReader(os.Stdin).ReadString('\n')
+}
+var readStdinPassword = func() (string, error) {
+	input, err := term.ReadPassword(int(os.Stdin.Fd()))
+	return string(input), err
+}
+
+// lineResult is a line read from stdin.
+type lineResult struct {
+	line string
+	err  error
+}
+
+// lineReader hands lines of stdin to one prompt at a time.  A read cannot be interrupted, so a read
+// started for a prompt that is gone, such as the token prompt of a login completed in the browser,
+// hands its line to the next prompt instead.
+type lineReader struct {
+	mu      sync.Mutex
+	pending chan lineResult
+}
+
+var stdinLines lineReader
+
+// start returns the pending read, or starts a new one with the read function.
+func (r *lineReader) start(read func() (string, error)) <-chan lineResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pending == nil {
+		lines := make(chan lineResult, 1)
+		go func() {
+			line, err := read()
+			lines <- lineResult{line: line, err: err}
+		}()
+		r.pending = lines
+	}
+	return r.pending
+}
+
+// received forgets a read once its line was received.
+func (r *lineReader) received(lines <-chan lineResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pending == lines {
+		r.pending = nil
+	}
+}
+
+// readLine reads a line of stdin for a prompt.
+func readLine() (string, error) {
+	lines := stdinLines.start(readStdinLine)
+	result := <-lines
+	stdinLines.received(lines)
+	return result.line, result.err
+}
@@ -1,11 +1,16 @@
 package internal
 
 import (
+	"context"
 	"errors"
-	"log"
+	"fmt"
+	"log/slog"
 	"os"
 	"syscall"
 	"time"
+
+	"github.com/duplocloud/duplo-jit/duplocloud"
+	"github.com/duplocloud/duplo-jit/jit"
 )
 
 func DieIf(err error, msg string) {
@@ -14,11 +19,53 @@ func DieIf(err error, msg string) {
 	}
 }
 
+// Fatal writes the message and error to stderr as a plain line, then exits.  When logs go to a file,
+// they also get a structured record of the failure.
 func Fatal(msg string, err error) {
+	var attrs []any
+	message := fmt.Sprintf("%s: %s", os.Args[0], msg)
 	if err != nil {
-		log.Fatalf("%s: %s: %s", os.Args[0], msg, err)
+		attrs = append(attrs, "error", err.Error())
+		message = fmt.Sprintf("%s: %s", message, err)
+		if hint := errorHint(err); hint != "" {
+			attrs = append(attrs, "hint", hint)
+			message = fmt.Sprintf("%s\nhint: %s", message, hint)
+		}
+	}
+
+	if !logToStderr {
+		slog.Error(msg, attrs...)
+	}
+	_, _ = fmt.Fprintln(os.Stderr, message)
+	os.Exit(1)
+}
+
+// errorHint explains how to resolve well-known Duplo API failures.
+func errorHint(err error) string {
+	var tenantNotFound *jit.TenantNotFoundError
+	switch {
+	case errors.As(err, &tenantNotFound):
+		return tenantNotFound.Hint()
+	case errors.Is(err, jit.ErrInteractiveDisabled):
+		return "pass --token, or use --interactive"
+	case errors.Is(err, jit.ErrOTPRequired):
+		return "pass --otp or --otp-secret-file, or use --interactive"
+	case errors.Is(err, ErrConfirmationRequired):
+		return "confirm on a terminal, or pass --yes"
+	case errors.Is(err, ErrReasonRequired):
+		return "pass --reason, such as --reason \"INC-1234: restore the orders database\""
+	case errors.Is(err, duplocloud.ErrInvalidOTP):
+		return "the OTP code is wrong or has expired: codes are only valid for a short time, so pass a new --otp code, or check the system clock when using --otp-secret-file"
+	case errors.Is(err, duplocloud.ErrNotAuthenticated):
+		return "the Duplo token is invalid or has expired: log in again with --interactive, or pass a new --token"
+	case errors.Is(err, duplocloud.ErrForbidden):
+		return "the Duplo user does not have access to the requested tenant, plan or role"
+	case errors.Is(err, duplocloud.ErrUnreachable):
+		return "the Duplo portal could not be reached: check --host, your network connection and proxy settings"
+	case errors.Is(err, duplocloud.ErrInvalidResponse):
+		return "the Duplo portal did not answer with its API: check --host and --api-host, or try again later"
 	}
-	log.Fatalf("%s: %s", os.Args[0], msg)
+	return ""
 }
 
 // IsPidAlive checks whether a process with the given PID is still running.
@@ -37,12 +84,22 @@ func IsPidAlive(pid int) bool {
 // WaitForPidExit polls until the given PID exits or the timeout is reached.
 // Returns true if the PID exited, false if the timeout was reached.
 func WaitForPidExit(pid int, timeout time.Duration, pollInterval time.Duration) bool {
+	return WaitForPidExitContext(context.Background(), pid, timeout, pollInterval)
+}
+
+// WaitForPidExitContext polls until the given PID exits, the timeout is reached or the context is canceled.
+// Returns true if the PID exited, false otherwise.
+func WaitForPidExitContext(ctx context.Context, pid int, timeout time.Duration, pollInterval time.Duration) bool {
 	deadline := time.Now().Add(timeout)
 	for time.Now().Before(deadline) {
 		if !IsPidAlive(pid) {
 			return true
 		}
-		time.Sleep(pollInterval)
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(pollInterval):
+		}
 	}
 	return !IsPidAlive(pid)
 }
@@ -0,0 +1,29 @@
+package internal
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/duplocloud/duplo-jit/jit"
+)
+
+func TestErrorHint(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"tenant not found", &jit.TenantNotFoundError{Tenant: "dev1", Suggestions: []string{"dev01", "dev02"}}, "did you mean 'dev01' or 'dev02'?"},
+		{"tenant not found without suggestions", &jit.TenantNotFoundError{Tenant: "dev1"}, ""},
+		{"interactive disabled", fmt.Errorf("failed to resolve tenant: %w", jit.ErrInteractiveDisabled), "pass --token, or use --interactive"},
+		{"OTP required", jit.ErrOTPRequired, "pass --otp or --otp-secret-file, or use --interactive"},
+		{"other error", fmt.Errorf("other"), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errorHint(tt.err); got != tt.want {
+				t.Errorf("errorHint() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package internal
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/duplocloud/duplo-jit/duplocloud"
+)
+
+// WhoamiOutput describes the identity behind the Duplo token, and optionally behind JIT AWS credentials.
+type WhoamiOutput struct {
+	Host                 string           `json:"Host"`
+	Username             string           `json:"Username,omitempty"`
+	Roles                []string         `json:"Roles,omitempty"`
+	IsAdmin              bool             `json:"IsAdmin"`
+	UserError            string           `json:"UserError,omitempty"`
+	IsOtpNeeded          bool             `json:"IsOtpNeeded"`
+	IsAwsAdminJitEnabled bool             `json:"IsAwsAdminJITEnabled"`
+	IsDuploOpsEnabled    bool             `json:"IsDuploOpsEnabled"`
+	Aws                  *WhoamiAwsOutput `json:"Aws,omitempty"`
+}
+
+// WhoamiAwsOutput describes the AWS principal behind JIT AWS credentials.
+type WhoamiAwsOutput struct {
+	Role       string `json:"Role"`
+	Tenant     string `json:"Tenant,omitempty"`
+	Account    string `json:"Account"`
+	Arn        string `json:"Arn"`
+	UserId     string `json:"UserId"`
+	Expiration string `json:"Expiration,omitempty"`
+	FromCache  bool   `json:"FromCache"`
+}
+
+// MustWhoami retrieves the Duplo user and system features for the client's token.
+// Portals without the user profile API report the user named by the token's claims, if any, and
+// still report their system features.
+func MustWhoami(ctx context.Context, client *duplocloud.Client, host string) *WhoamiOutput {
+	features, err := client.FeaturesSystemContext(ctx)
+	DieIf(err, "failed to get system features")
+
+	out := &WhoamiOutput{
+		Host:                 host,
+		IsOtpNeeded:          features.IsOtpNeeded,
+		IsAwsAdminJitEnabled: features.IsAwsAdminJITEnabled,
+		IsDuploOpsEnabled:    features.IsDuploOpsEnabled,
+	}
+
+	profile, err := client.GetUserProfileContext(ctx)
+	if err != nil && err.PossibleMissingAPI() {
+		// Portals without the API answer with a 404 or a 500: fall back to the token's claims.
+		profile = tokenProfile(client.Token)
+		if profile == nil {
+			out.UserError = "not supported by this portal"
+		}
+	} else if err != nil {
+		out.UserError = err.Error()
+	}
+	if profile != nil {
+		out.Username = profile.Username
+		out.Roles = profile.Roles
+		out.IsAdmin = profile.IsAdmin()
+	}
+
+	return out
+}
+
+// tokenProfile returns the Duplo user named by the claims of a JWT, or nil for opaque tokens.
+func tokenProfile(token string) *duplocloud.DuploUserProfile {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil
+	}
+	var claims map[string]interface{}
+	if json.Unmarshal(payload, &claims) != nil {
+		return nil
+	}
+
+	profile := &duplocloud.DuploUserProfile{}
+	for _, name := range []string{"email", "unique_name", "preferred_username", "name", nameClaim, "sub"} {
+		if username, ok := claims[name].(string); ok && username != "" {
+			profile.Username = username
+			break
+		}
+	}
+	for _, name := range []string{"role", "roles", roleClaim} {
+		switch roles := claims[name].(type) {
+		case string:
+			profile.Roles = append(profile.Roles, roles)
+		case []interface{}:
+			for _, role := range roles {
+				if role, ok := role.(string); ok {
+					profile.Roles = append(profile.Roles, role)
+				}
+			}
+		}
+	}
+	if profile.Username == "" {
+		return nil
+	}
+	return profile
+}
+
+// The .NET claim types for the user name and roles.
+const (
+	nameClaim = "http://schemas.xmlsoap.org/ws/2005/05/identity/claims/name"
+	roleClaim = "http://schemas.microsoft.com/ws/2008/06/identity/claims/role"
+)
+
+// MustWhoamiAws retrieves the AWS principal behind the creds.
+func MustWhoamiAws(ctx context.Context, creds *AwsConfigOutput, role, tenant string, fromCache bool) *WhoamiAwsOutput {
+	identity, err := GetAwsCallerIdentity(ctx, creds)
+	DieIf(err, "failed to get AWS caller identity")
+
+	return &WhoamiAwsOutput{
+		Role:       role,
+		Tenant:     tenant,
+		Account:    identity.Account,
+		Arn:        identity.Arn,
+		UserId:     identity.UserId,
+		Expiration: creds.Expiration,
+		FromCache:  fromCache,
+	}
+}
+
+func OutputWhoami(out *WhoamiOutput, format string) {
+	switch format {
+	case "", "table":
+		user := out.Username
+		if out.UserError != "" {
+			user = fmt.Sprintf("unknown (%s)", out.UserError)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintf(w, "HOST\t%s\n", out.Host)
+		_, _ = fmt.Fprintf(w, "USER\t%s\n", orDash(user))
+		_, _ = fmt.Fprintf(w, "ROLES\t%s\n", orDash(strings.Join(out.Roles, ", ")))
+		_, _ = fmt.Fprintf(w, "ADMIN\t%s\n", yesNo(out.IsAdmin))
+		_, _ = fmt.Fprintf(w, "OTP REQUIRED\t%s\n", yesNo(out.IsOtpNeeded))
+		_, _ = fmt.Fprintf(w, "ADMIN JIT ENABLED\t%s\n", yesNo(out.IsAwsAdminJitEnabled))
+		_, _ = fmt.Fprintf(w, "DUPLO OPS ENABLED\t%s\n", yesNo(out.IsDuploOpsEnabled))
+		if out.Aws != nil {
+			_, _ = fmt.Fprintf(w, "AWS ROLE\t%s\n", out.Aws.Role)
+			if out.Aws.Tenant != "" {
+				_, _ = fmt.Fprintf(w, "AWS TENANT\t%s\n", out.Aws.Tenant)
+			}
+			_, _ = fmt.Fprintf(w, "AWS ACCOUNT\t%s\n", out.Aws.Account)
+			_, _ = fmt.Fprintf(w, "AWS ARN\t%s\n", out.Aws.Arn)
+			_, _ = fmt.Fprintf(w, "AWS EXPIRATION\t%s\n", orDash(out.Aws.Expiration))
+			_, _ = fmt.Fprintf(w, "AWS FROM CACHE\t%s\n", yesNo(out.Aws.FromCache))
+		}
+		_ = w.Flush()
+
+	case "json":
+		outputJSON(out)
+
+	default:
+		Fatal(fmt.Sprintf("unsupported output format '%s'", format), nil)
+	}
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,112 @@
+package internal
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/duplocloud/duplo-jit/duplocloud"
+)
+
+func newWhoamiClient(t *testing.T, token string, status int, profile string) *duplocloud.Client {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/v3/features/system":
+			_, _ = res.Write([]byte(`{"IsOtpNeeded":true,"IsAwsAdminJITEnabled":true}`))
+		case "/admin/GetUserProfile":
+			if status != http.StatusOK {
+				res.WriteHeader(status)
+				return
+			}
+			_, _ = res.Write([]byte(profile))
+		default:
+			res.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	client, err := duplocloud.NewClient(srv.URL, token)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	return client
+}
+
+func TestMustWhoami(t *testing.T) {
+	client := newWhoamiClient(t, "test-token", http.StatusOK, `{"Username":"jane@example.com","Roles":["User","Administrator"]}`)
+
+	got := MustWhoami(context.Background(), client, "https://test.example.com")
+	want := &WhoamiOutput{
+		Host:                 "https://test.example.com",
+		Username:             "jane@example.com",
+		Roles:                []string{"User", "Administrator"},
+		IsAdmin:              true,
+		IsOtpNeeded:          true,
+		IsAwsAdminJitEnabled: true,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MustWhoami() = %+v, want %+v", got, want)
+	}
+}
+
+func TestMustWhoami_UserNotSupported(t *testing.T) {
+	client := newWhoamiClient(t, "test-token", http.StatusInternalServerError, "")
+
+	got := MustWhoami(context.Background(), client, "https://test.example.com")
+	if got.Username != "" || got.IsAdmin {
+		t.Errorf("expected no user, got %+v", got)
+	}
+	if got.UserError != "not supported by this portal" {
+		t.Errorf("unexpected user error: %q", got.UserError)
+	}
+	if !got.IsOtpNeeded || !got.IsAwsAdminJitEnabled {
+		t.Errorf("expected system features to be reported, got %+v", got)
+	}
+}
+
+func TestMustWhoami_UserFromToken(t *testing.T) {
+	enc := base64.RawURLEncoding
+	jwt := func(claims string) string {
+		return enc.EncodeToString([]byte(`{"alg":"none"}`)) + "." + enc.EncodeToString([]byte(claims)) + ".sig"
+	}
+
+	cases := []struct {
+		name   string
+		status int
+		token  string
+		want   *duplocloud.DuploUserProfile
+	}{
+		{"not found", http.StatusNotFound, jwt(`{"email":"jane@example.com","role":"Administrator"}`), &duplocloud.DuploUserProfile{Username: "jane@example.com", Roles: []string{"Administrator"}}},
+		{"server error", http.StatusInternalServerError, jwt(`{"unique_name":"joe","roles":["User"]}`), &duplocloud.DuploUserProfile{Username: "joe", Roles: []string{"User"}}},
+		{"dotnet claims", http.StatusInternalServerError, jwt(`{"` + nameClaim + `":"joe","` + roleClaim + `":["User","Administrator"]}`), &duplocloud.DuploUserProfile{Username: "joe", Roles: []string{"User", "Administrator"}}},
+		{"no user claim", http.StatusInternalServerError, jwt(`{"role":"User"}`), nil},
+		{"opaque token", http.StatusInternalServerError, "test-token", nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := MustWhoami(context.Background(), newWhoamiClient(t, c.token, c.status, ""), "https://test.example.com")
+			if c.want == nil {
+				if got.Username != "" || got.UserError != "not supported by this portal" {
+					t.Errorf("expected an unsupported user, got %+v", got)
+				}
+				return
+			}
+			if got.Username != c.want.Username || !reflect.DeepEqual(got.Roles, c.want.Roles) || got.IsAdmin != c.want.IsAdmin() || got.UserError != "" {
+				t.Errorf("MustWhoami() = %+v, want user %+v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestMustWhoami_UserError(t *testing.T) {
+	client := newWhoamiClient(t, "test-token", http.StatusForbidden, "")
+
+	got := MustWhoami(context.Background(), client, "https://test.example.com")
+	if got.Username != "" || got.UserError == "" || got.UserError == "not supported by this portal" {
+		t.Errorf("expected the portal's error, got %+v", got)
+	}
+}
@@ -0,0 +1,209 @@
+package jit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/duplocloud/duplo-jit/duplocloud"
+)
+
+// AwsCredentials are AWS credentials, in the format of an AWS CLI credential process.
+type AwsCredentials struct {
+	Version         int    `json:"Version"`
+	ConsoleUrl      string `json:"ConsoleUrl"`
+	AccessKeyId     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	Region          string `json:"Region"`
+	SessionToken    string `json:"SessionToken,omitempty"`
+	Expiration      string `json:"Expiration,omitempty"`
+}
+
+// AwsResult holds AWS credentials, and where they came from.
+type AwsResult struct {
+	Credentials *AwsCredentials
+	CacheKey    string
+	Role        string
+	TenantName  string
+	FromCache   bool
+
+	// Reason is the reason given when the credentials were issued, if any.
+	Reason string
+
+	// Until, if set, is the end of the elevation that holds the credentials in the cache.
+	Until time.Time
+}
+
+func ConvertAwsCreds(creds *duplocloud.AwsJitCredentials) *AwsCredentials {
+	// Calculate the expiration time.
+	expiration := awsExpiration(creds, time.Now().UTC())
+
+	// Build the resulting credentials to be output.
+	return &AwsCredentials{
+		Version:         1,
+		ConsoleUrl:      creds.ConsoleURL,
+		AccessKeyId:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		Region:          creds.Region,
+		SessionToken:    creds.SessionToken,
+		Expiration:      expiration.Format(time.RFC3339),
+	}
+}
+
+// awsExpiration returns when credentials issued at the given time expire.
+func awsExpiration(creds *duplocloud.AwsJitCredentials, issued time.Time) time.Time {
+	validity := creds.Validity
+	if validity <= 0 {
+		validity = 3600 // default is one hour
+	}
+	return issued.Add(time.Duration(validity) * time.Second)
+}
+
+// GetAwsCredentials gets AWS credentials for a role, from the cache or from Duplo.  Tenant credentials
+// are for the tenant with the given ID or name; the tenant is ignored for other roles.
+func (s *Session) GetAwsCredentials(ctx context.Context, role string, tenant string) (*AwsResult, error) {
+	result := &AwsResult{Role: role}
+	var tenantID string
+
+	// Build the cache key.
+	switch role {
+	case RoleAdmin, RoleDuploOps:
+		result.CacheKey = strings.Join([]string{s.cacheKey, role}, ",")
+	case RoleTenant:
+		if tenant == "" {
+			return nil, fmt.Errorf("a tenant ID or name is required for %s credentials", role)
+		}
+		t, err := s.Tenant(ctx, tenant)
+		if err != nil {
+			return nil, err
+		}
+		tenantID, result.TenantName = t.TenantID, t.AccountName
+		result.CacheKey = strings.Join([]string{s.cacheKey, "tenant", result.TenantName}, ",")
+	default:
+		return nil, fmt.Errorf("unknown role '%s'", role)
+	}
+
+	// Try to find credentials from the cache.
+	if result.Credentials = s.cachedAwsCreds(ctx, awsClass(role), result.CacheKey); result.Credentials != nil {
+		info := cacheGetInfo(s.opts.Cache, awsEntry(result.CacheKey))
+		result.FromCache = true
+		result.Reason = info.Reason
+		result.Until, _ = time.Parse(time.RFC3339, info.Until)
+		return result, nil
+	}
+
+	// Otherwise, get the credentials from Duplo.
+	creds, err := s.awsJitCredentials(ctx, role, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Credentials = ConvertAwsCreds(creds)
+	result.Reason = duplocloud.JitReason(ctx)
+	s.cacheCreds(s.opts.Cache, awsClass(role), awsEntry(result.CacheKey), result.Credentials, &credsInfo{Reason: result.Reason})
+	return result, nil
+}
+
+// ElevateAws gets new AWS credentials for RoleAdmin or RoleDuploOps from Duplo, and holds them in the cache
+// until the end of the window, instead of for the retention policy of the role.
+func (s *Session) ElevateAws(ctx context.Context, role string, window time.Duration) (*AwsResult, error) {
+	if role != RoleAdmin && role != RoleDuploOps {
+		return nil, fmt.Errorf("cannot elevate to role '%s'", role)
+	} else if window <= 0 {
+		return nil, errors.New("the elevation window must be positive")
+	} else if s.opts.Cache == nil {
+		return nil, errors.New("a cache is required to hold elevated credentials")
+	}
+
+	creds, err := s.awsJitCredentials(ctx, role, "")
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	result := &AwsResult{
+		Credentials: ConvertAwsCreds(creds),
+		CacheKey:    strings.Join([]string{s.cacheKey, role}, ","),
+		Role:        role,
+		Reason:      duplocloud.JitReason(ctx),
+		Until:       now.Add(window),
+	}
+	cachePutCreds(s.opts.Cache, awsEntry(result.CacheKey), result.Credentials, &credsInfo{
+		Reason:   result.Reason,
+		CachedAt: now.Format(time.RFC3339),
+		Until:    result.Until.Format(time.RFC3339),
+	})
+	return result, nil
+}
+
+// EndElevation removes elevated credentials from the cache before the end of their window.  Credentials
+// cached since, such as by a later elevation, are kept.
+func (s *Session) EndElevation(result *AwsResult) {
+	entry := awsEntry(result.CacheKey)
+	if info := cacheGetInfo(s.opts.Cache, entry); info.Until != "" && info.Until == result.Until.Format(time.RFC3339) {
+		cacheDeleteCreds(s.opts.Cache, entry)
+	}
+}
+
+// awsJitCredentials gets AWS credentials for a role from Duplo, sending the reason of the context, if any.
+func (s *Session) awsJitCredentials(ctx context.Context, role string, tenantID string) (*duplocloud.AwsJitCredentials, error) {
+	client, _, err := s.Client(ctx, role != RoleTenant)
+	if err != nil {
+		return nil, err
+	}
+
+	var creds *duplocloud.AwsJitCredentials
+	var cerr duplocloud.ClientError
+	if role == RoleTenant {
+		creds, cerr = client.TenantGetJitAwsCredentialsContext(ctx, tenantID)
+	} else {
+		creds, cerr = client.AdminAwsGetJitAccessContext(ctx, role)
+	}
+	if cerr != nil {
+		return nil, fmt.Errorf("failed to get %s credentials: %w", role, cerr)
+	}
+	return creds, nil
+}
+
+// cachedAwsCreds tries to read prior AWS creds of a class from the cache.  Expired or invalid creds, creds
+// of an ended elevation, whatever their class, and creds that the retention policy no longer keeps, are removed.
+func (s *Session) cachedAwsCreds(ctx context.Context, class string, cacheKey string) *AwsCredentials {
+	entry := awsEntry(cacheKey)
+	if removeEndedElevation(s.opts.Cache, entry) {
+		return nil
+	}
+	creds := &AwsCredentials{}
+	if !cacheGet(s.opts.Cache, entry, creds) || !s.retained(s.opts.Cache, class, entry) {
+		return nil
+	}
+
+	// Check credentials for expiry.
+	fiveMinutesFromNow := time.Now().UTC().Add(5 * time.Minute)
+	expiration, err := time.Parse(time.RFC3339, creds.Expiration)
+
+	// Invalid expiration?
+	if err != nil {
+		slog.Warn("invalid Expiration time in credentials cache", "cacheKey", cacheKey, "expiration", creds.Expiration)
+		creds = nil
+
+		// Expires in five minutes or less?
+	} else if fiveMinutesFromNow.After(expiration) {
+		creds = nil
+	}
+
+	// Validate creds by executing ping
+	if creds != nil && s.opts.ValidateAws != nil {
+		if err := s.opts.ValidateAws(ctx, creds); err != nil {
+			creds = nil
+		}
+	}
+
+	// Clear the cache if the creds expired.
+	if creds == nil {
+		cacheDeleteCreds(s.opts.Cache, entry)
+	}
+	return creds
+}
@@ -0,0 +1,126 @@
+package jit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/duplocloud/duplo-jit/duplocloud"
+	"github.com/duplocloud/duplo-jit/duplocloud/duplotest"
+)
+
+func TestSession_GetAwsCredentials(t *testing.T) {
+	s := duplotest.NewServer(duplotest.Config{Admin: true, DuploOpsEnabled: true, Tenants: testTenants})
+	defer s.Close()
+	cache := NewMemoryCache()
+	ctx := context.Background()
+
+	tests := []struct {
+		role, tenant  string
+		wantKey       string
+		wantAccessKey string
+		wantTenant    string
+	}{
+		{RoleAdmin, "", "127.0.0.1,admin", "ASIADUPLOTESTADMIN", ""},
+		{RoleDuploOps, "", "127.0.0.1,duplo-ops", "ASIADUPLOTESTDUPLO-OPS", ""},
+		{RoleTenant, "DUPLOSERVICES-DEV01", "127.0.0.1,tenant,dev01", "ASIADUPLOTESTDEV01", "dev01"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.role, func(t *testing.T) {
+			// The first session gets the credentials from Duplo, and the second from the cache.
+			for _, fromCache := range []bool{false, true} {
+				session := newTestSession(t, s, Options{Token: duplotest.DefaultToken, Cache: cache})
+				result, err := session.GetAwsCredentials(ctx, tt.role, tt.tenant)
+				if err != nil {
+					t.Fatalf("GetAwsCredentials() error: %v", err)
+				}
+				if result.CacheKey != tt.wantKey || result.TenantName != tt.wantTenant || result.FromCache != fromCache {
+					t.Errorf("unexpected result: %+v", result)
+				}
+				if creds := result.Credentials; creds.AccessKeyId != tt.wantAccessKey || creds.Version != 1 || creds.Expiration == "" {
+					t.Errorf("unexpected credentials: %+v", creds)
+				}
+			}
+		})
+	}
+}
+
+func TestSession_GetAwsCredentials_Invalid(t *testing.T) {
+	s := duplotest.NewServer(duplotest.Config{Tenants: testTenants})
+	defer s.Close()
+	cache := NewMemoryCache()
+	session := newTestSession(t, s, Options{
+		Token:       duplotest.DefaultToken,
+		Cache:       cache,
+		ValidateAws: func(context.Context, *AwsCredentials) error { return errors.New("invalid") },
+	})
+	ctx := context.Background()
+
+	// Tenant credentials require a tenant.
+	if _, err := session.GetAwsCredentials(ctx, RoleTenant, ""); err == nil {
+		t.Error("expected an error without a tenant")
+	}
+
+	// Admin credentials require admin access.
+	if _, err := session.GetAwsCredentials(ctx, RoleAdmin, ""); !errors.Is(err, duplocloud.ErrForbidden) {
+		t.Errorf("expected forbidden, got %v", err)
+	}
+
+	// Invalid cached credentials are replaced.
+	cachePut(cache, awsEntry("127.0.0.1,tenant,dev01"), &AwsCredentials{
+		Version:    1,
+		Expiration: time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+	})
+	result, err := session.GetAwsCredentials(ctx, RoleTenant, "dev01")
+	if err != nil || result.FromCache || result.Credentials.AccessKeyId != "ASIADUPLOTESTDEV01" {
+		t.Errorf("GetAwsCredentials() = %+v, %v", result, err)
+	}
+}
+
+func TestConvertAwsCreds(t *testing.T) {
+	creds := ConvertAwsCreds(&duplocloud.AwsJitCredentials{AccessKeyID: "key", Validity: 600})
+	expiration, err := time.Parse(time.RFC3339, creds.Expiration)
+	if err != nil || time.Until(expiration) > 10*time.Minute || time.Until(expiration) < 9*time.Minute {
+		t.Errorf("unexpected expiration: %s", creds.Expiration)
+	}
+
+	// The default validity is one hour.
+	creds = ConvertAwsCreds(&duplocloud.AwsJitCredentials{AccessKeyID: "key"})
+	if expiration, _ = time.Parse(time.RFC3339, creds.Expiration); time.Until(expiration) < 59*time.Minute {
+		t.Errorf("unexpected default expiration: %s", creds.Expiration)
+	}
+}
+
+func TestSession_GetAwsCredentials_Reason(t *testing.T) {
+	s := duplotest.NewServer(duplotest.Config{Admin: true, Tenants: testTenants})
+	defer s.Close()
+	cache := NewMemoryCache()
+
+	// The reason is sent to Duplo, and kept with the cached credentials.
+	session := newTestSession(t, s, Options{Token: duplotest.DefaultToken, Cache: cache})
+	result, err := session.GetAwsCredentials(duplocloud.WithJitReason(context.Background(), "INC-1234: first"), RoleAdmin, "")
+	if err != nil || result.Reason != "INC-1234: first" {
+		t.Fatalf("GetAwsCredentials() = %+v, %v", result, err)
+	}
+	if reasons := s.Reasons("v3/admin/aws/jitAccess/admin"); len(reasons) != 1 || reasons[0] != "INC-1234: first" {
+		t.Errorf("unexpected reasons: %q", reasons)
+	}
+
+	// Cached credentials have the reason they were issued for.
+	session = newTestSession(t, s, Options{Token: duplotest.DefaultToken, Cache: cache})
+	result, err = session.GetAwsCredentials(duplocloud.WithJitReason(context.Background(), "INC-5678: second"), RoleAdmin, "")
+	if err != nil || !result.FromCache || result.Reason != "INC-1234: first" {
+		t.Errorf("GetAwsCredentials() = %+v, %v", result, err)
+	}
+
+	// Expired credentials are removed along with their reason.
+	cachePut(cache, awsEntry(result.CacheKey), &AwsCredentials{Version: 1, Expiration: time.Now().UTC().Format(time.RFC3339)})
+	result, err = session.GetAwsCredentials(context.Background(), RoleAdmin, "")
+	if err != nil || result.FromCache || result.Reason != "" {
+		t.Errorf("GetAwsCredentials() = %+v, %v", result, err)
+	}
+	if info := cacheGetInfo(cache, awsEntry(result.CacheKey)); info.Reason != "" {
+		t.Errorf("expected no reason, got %+v", info)
+	}
+}
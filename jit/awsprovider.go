@@ -0,0 +1,67 @@
+package jit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// AwsCredentialsSource is the Source of the aws.Credentials retrieved by an AwsCredentialsProvider.
+const AwsCredentialsSource = "DuploJitProvider"
+
+// AwsCredentialsProvider is an aws.CredentialsProvider for the JIT credentials of a role.
+//
+// Every Retrieve gets new credentials from Duplo, bypassing the session's cache of AWS credentials.
+// Wrap the provider with aws.NewCredentialsCache to reuse the credentials until they expire.
+type AwsCredentialsProvider struct {
+	session *Session
+	role    string
+	tenant  string
+}
+
+var _ aws.CredentialsProvider = (*AwsCredentialsProvider)(nil)
+
+// AwsCredentialsProvider returns a provider of AWS credentials for a role: RoleAdmin, RoleDuploOps, or
+// RoleTenant with the ID or name of the tenant.
+//
+//	cfg, err := config.LoadDefaultConfig(ctx,
+//		config.WithCredentialsProvider(aws.NewCredentialsCache(session.AwsCredentialsProvider(jit.RoleTenant, "dev"))))
+func (s *Session) AwsCredentialsProvider(role string, tenant string) *AwsCredentialsProvider {
+	return &AwsCredentialsProvider{session: s, role: role, tenant: tenant}
+}
+
+// Retrieve gets new AWS credentials from Duplo.
+func (p *AwsCredentialsProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	var tenantID string
+	switch p.role {
+	case RoleAdmin, RoleDuploOps:
+	case RoleTenant:
+		if p.tenant == "" {
+			return aws.Credentials{}, fmt.Errorf("a tenant ID or name is required for %s credentials", p.role)
+		}
+		tenant, err := p.session.Tenant(ctx, p.tenant)
+		if err != nil {
+			return aws.Credentials{}, err
+		}
+		tenantID = tenant.TenantID
+	default:
+		return aws.Credentials{}, fmt.Errorf("unknown role '%s'", p.role)
+	}
+
+	issued := time.Now()
+	creds, err := p.session.awsJitCredentials(ctx, p.role, tenantID)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+
+	return aws.Credentials{
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		SessionToken:    creds.SessionToken,
+		Source:          AwsCredentialsSource,
+		CanExpire:       true,
+		Expires:         awsExpiration(creds, issued),
+	}, nil
+}
@@ -0,0 +1,90 @@
+package jit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/duplocloud/duplo-jit/duplocloud"
+	"github.com/duplocloud/duplo-jit/duplocloud/duplotest"
+)
+
+func TestAwsCredentialsProvider(t *testing.T) {
+	s := duplotest.NewServer(duplotest.Config{Admin: true, Tenants: testTenants, Validity: 900})
+	defer s.Close()
+	session := newTestSession(t, s, Options{Token: duplotest.DefaultToken, Cache: NewMemoryCache()})
+	ctx := context.Background()
+
+	tests := []struct {
+		role, tenant  string
+		path          string
+		wantAccessKey string
+	}{
+		{RoleAdmin, "", "v3/admin/aws/jitAccess/admin", "ASIADUPLOTESTADMIN"},
+		{RoleTenant, "dev01", "subscriptions/" + testTenants[1].TenantID + "/GetAwsConsoleTokenUrl", "ASIADUPLOTESTDEV01"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.role, func(t *testing.T) {
+			provider := aws.NewCredentialsCache(session.AwsCredentialsProvider(tt.role, tt.tenant))
+
+			// The credentials are retrieved once, and reused until they expire.
+			for i := 0; i < 2; i++ {
+				creds, err := provider.Retrieve(ctx)
+				if err != nil {
+					t.Fatalf("Retrieve() error: %v", err)
+				}
+				if creds.AccessKeyID != tt.wantAccessKey || creds.SessionToken == "" || creds.Source != AwsCredentialsSource {
+					t.Errorf("unexpected credentials: %+v", creds)
+				}
+				if remaining := time.Until(creds.Expires); !creds.CanExpire || remaining > 15*time.Minute || remaining < 14*time.Minute {
+					t.Errorf("unexpected expiry: %v, %v", creds.CanExpire, creds.Expires)
+				}
+			}
+			if got := s.Calls(tt.path); got != 1 {
+				t.Errorf("expected 1 call, got %d", got)
+			}
+		})
+	}
+}
+
+func TestAwsCredentialsProvider_Refresh(t *testing.T) {
+	s := duplotest.NewServer(duplotest.Config{Admin: true, Tenants: testTenants, Validity: 60})
+	defer s.Close()
+	session := newTestSession(t, s, Options{Token: duplotest.DefaultToken})
+
+	// Credentials within the expiry window of the cache are retrieved again.
+	provider := aws.NewCredentialsCache(session.AwsCredentialsProvider(RoleAdmin, ""), func(o *aws.CredentialsCacheOptions) {
+		o.ExpiryWindow = 2 * time.Minute
+	})
+	for i := 0; i < 2; i++ {
+		if _, err := provider.Retrieve(context.Background()); err != nil {
+			t.Fatalf("Retrieve() error: %v", err)
+		}
+	}
+	if got := s.Calls("v3/admin/aws/jitAccess/admin"); got != 2 {
+		t.Errorf("expected 2 calls, got %d", got)
+	}
+}
+
+func TestAwsCredentialsProvider_Errors(t *testing.T) {
+	s := duplotest.NewServer(duplotest.Config{Tenants: testTenants})
+	defer s.Close()
+	session := newTestSession(t, s, Options{Token: duplotest.DefaultToken})
+	ctx := context.Background()
+
+	if _, err := session.AwsCredentialsProvider(RoleAdmin, "").Retrieve(ctx); !errors.Is(err, duplocloud.ErrForbidden) {
+		t.Errorf("expected forbidden, got %v", err)
+	}
+	if _, err := session.AwsCredentialsProvider(RoleTenant, "").Retrieve(ctx); err == nil {
+		t.Error("expected an error without a tenant")
+	}
+	var notFound *TenantNotFoundError
+	if _, err := session.AwsCredentialsProvider(RoleTenant, "missing").Retrieve(ctx); !errors.As(err, &notFound) {
+		t.Errorf("expected tenant not found, got %v", err)
+	}
+	if _, err := session.AwsCredentialsProvider("other", "").Retrieve(ctx); err == nil {
+		t.Error("expected an error for an unknown role")
+	}
+}
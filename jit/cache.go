@@ -0,0 +1,195 @@
+package jit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Cache stores credentials between sessions.  Entries are JSON documents, named after the host and
+// the kind of credentials, such as "example.duplocloud.net,admin,aws-creds.json".
+type Cache interface {
+	// Get returns the data of an entry, or an error matching fs.ErrNotExist if there is none.
+	Get(name string) ([]byte, error)
+
+	// Put creates or replaces an entry.
+	Put(name string, data []byte) error
+
+	// Delete removes an entry, if it exists.
+	Delete(name string) error
+}
+
+// FileCache stores each entry as a file in a directory, readable only by the user.
+// It is the cache used by the duplo-jit commands.
+type FileCache struct {
+	Dir string
+}
+
+// NewFileCache returns a file cache, creating its directory if needed.
+func NewFileCache(dir string) (*FileCache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileCache{Dir: dir}, nil
+}
+
+func (c *FileCache) Get(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(c.Dir, name))
+}
+
+func (c *FileCache) Put(name string, data []byte) error {
+	return os.WriteFile(filepath.Join(c.Dir, name), data, 0600)
+}
+
+func (c *FileCache) Delete(name string) error {
+	err := os.Remove(filepath.Join(c.Dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// MemoryCache stores entries in memory, for the lifetime of the process.
+type MemoryCache struct {
+	mu      sync.Mutex
+	entries map[string][]byte
+}
+
+// NewMemoryCache returns an empty memory cache.
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{entries: map[string][]byte{}}
+}
+
+func (c *MemoryCache) Get(name string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, ok := c.entries[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+	}
+	return bytes.Clone(data), nil
+}
+
+func (c *MemoryCache) Put(name string, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[name] = bytes.Clone(data)
+	return nil
+}
+
+func (c *MemoryCache) Delete(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, name)
+	return nil
+}
+
+// Names of cache entries.
+func tokenEntry(cacheKey string) string   { return cacheKey + ",duplo-creds.json" }
+func tenantsEntry(cacheKey string) string { return cacheKey + ",tenants.json" }
+func awsEntry(cacheKey string) string     { return cacheKey + ",aws-creds.json" }
+func k8sEntry(cacheKey string) string     { return cacheKey + ",k8s-creds.json" }
+
+// infoEntry returns the name of the entry that describes the credentials of another entry.
+func infoEntry(entry string) string { return strings.TrimSuffix(entry, ".json") + ",info.json" }
+
+// credsInfo describes cached credentials.
+type credsInfo struct {
+	// Reason is the reason given when the credentials were issued, if any.
+	Reason string `json:"Reason,omitempty"`
+
+	// CachedAt is when the credentials were cached, in RFC3339 format.
+	CachedAt string `json:"CachedAt,omitempty"`
+
+	// Until, if set, is the end of the elevation that holds the credentials in the cache, in RFC3339 format.
+	Until string `json:"Until,omitempty"`
+
+	// TokenHash identifies the Duplo token the credentials were issued with, when they are wiped on logout.
+	TokenHash string `json:"TokenHash,omitempty"`
+}
+
+// cacheGet reads JSON from the cache and unmarshals it into the target, returning true on success.
+func cacheGet(cache Cache, name string, target interface{}) bool {
+	if cache == nil {
+		return false
+	}
+
+	data, err := cache.Get(name)
+	if err == nil {
+		err = json.Unmarshal(data, target)
+		if err == nil {
+			return true
+		}
+
+		slog.Warn("invalid JSON in cache", "entry", name, "error", err)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		slog.Warn("unable to read from cache", "entry", name, "error", err)
+	}
+
+	return false
+}
+
+// cachePut writes the source to the cache as JSON, ignoring failures.
+func cachePut(cache Cache, name string, source interface{}) {
+	if cache == nil {
+		return
+	}
+
+	data, err := marshalJSON(source)
+	if err == nil {
+		err = cache.Put(name, data)
+	}
+	if err != nil {
+		slog.Warn("unable to write to cache", "entry", name, "error", err)
+	}
+}
+
+// cacheDelete removes an entry from the cache, ignoring failures.
+func cacheDelete(cache Cache, name string) {
+	if cache == nil {
+		return
+	}
+
+	if err := cache.Delete(name); err != nil {
+		slog.Warn("unable to remove from credentials cache", "entry", name, "error", err)
+	}
+}
+
+// cachePutCreds writes credentials to the cache, along with their info.
+func cachePutCreds(cache Cache, entry string, creds interface{}, info *credsInfo) {
+	cachePut(cache, entry, creds)
+	cachePut(cache, infoEntry(entry), info)
+}
+
+// cacheGetInfo reads the info of cached credentials.  Credentials cached without info have none.
+func cacheGetInfo(cache Cache, entry string) *credsInfo {
+	info := &credsInfo{}
+	cacheGet(cache, infoEntry(entry), info)
+	return info
+}
+
+// cacheDeleteCreds removes credentials from the cache, along with their info.
+func cacheDeleteCreds(cache Cache, entry string) {
+	cacheDelete(cache, entry)
+	cacheDelete(cache, infoEntry(entry))
+}
+
+// marshalJSON converts the source to JSON, without escaping the HTML characters of URLs.
+func marshalJSON(source interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(source); err != nil {
+		return nil, err
+	}
+
+	// Remove the trailing newline added by encoder.Encode.
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
@@ -0,0 +1,68 @@
+package jit
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCaches(t *testing.T) {
+	fileCache, err := NewFileCache(filepath.Join(t.TempDir(), "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	caches := map[string]Cache{"file": fileCache, "memory": NewMemoryCache()}
+
+	for name, cache := range caches {
+		t.Run(name, func(t *testing.T) {
+			if _, err := cache.Get("missing"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("expected not exist, got %v", err)
+			}
+			if err := cache.Put("entry", []byte("data")); err != nil {
+				t.Fatal(err)
+			}
+			if data, err := cache.Get("entry"); err != nil || string(data) != "data" {
+				t.Errorf("Get() = %q, %v", data, err)
+			}
+			if err := cache.Delete("entry"); err != nil {
+				t.Error(err)
+			}
+			if err := cache.Delete("entry"); err != nil {
+				t.Errorf("expected no error for a missing entry, got %v", err)
+			}
+			if _, err := cache.Get("entry"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("expected not exist, got %v", err)
+			}
+		})
+	}
+}
+
+func TestFileCache_Permissions(t *testing.T) {
+	cache, err := NewFileCache(filepath.Join(t.TempDir(), "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Put("entry", []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{cache.Dir, filepath.Join(cache.Dir, "entry")} {
+		info, err := os.Stat(path)
+		if err != nil || info.Mode().Perm()&0o077 != 0 {
+			t.Errorf("%s: expected to be private, got %v, %v", path, info.Mode(), err)
+		}
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	data, err := marshalJSON(&AwsCredentials{ConsoleUrl: "https://example.com/?a=1&b=2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `"ConsoleUrl":"https://example.com/?a=1&b=2"`; !strings.Contains(string(data), want) || data[len(data)-1] == '\n' {
+		t.Errorf("unexpected JSON: %s", data)
+	}
+}
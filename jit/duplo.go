@@ -0,0 +1,374 @@
+package jit
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/duplocloud/duplo-jit/duplocloud"
+)
+
+// DuploCreds is a Duplo token, as output by "duplo-jit duplo" and cached between logins.
+type DuploCreds struct {
+	Version    int    `json:"Version"`
+	DuploToken string `json:"DuploToken,omitempty"`
+	NeedOTP    bool   `json:"NeedOTP"`
+
+	// The scope of the token: from an admin login.
+	Admin bool `json:"Admin,omitempty"`
+
+	// When the token was issued and when it expires, if known.
+	IssuedAt   string `json:"IssuedAt,omitempty"`
+	Expiration string `json:"Expiration,omitempty"`
+
+	// FromCache is true if the token was read from the cache.
+	FromCache bool `json:"-"`
+}
+
+// HasScope returns true if the token can be used for admin (or, if false, tenant) requests.
+// An admin token satisfies tenant requests.
+func (creds *DuploCreds) HasScope(admin bool) bool {
+	return !admin || creds.Admin
+}
+
+// ExpiresIn returns how long until the token expires, or false if the expiry is unknown.
+func (creds *DuploCreds) ExpiresIn(now time.Time) (time.Duration, bool) {
+	if creds.Expiration == "" {
+		return 0, false
+	}
+	expiration, err := time.Parse(time.RFC3339, creds.Expiration)
+	if err != nil {
+		return 0, false
+	}
+	return expiration.Sub(now), true
+}
+
+// setTokenTimes records when the token was issued and when it expires, where the token exposes it.
+// Otherwise, loggedIn is used as the issued time, unless it is zero.
+func (creds *DuploCreds) setTokenTimes(loggedIn time.Time) {
+	issued, expires := jwtTimes(creds.DuploToken)
+	if issued.IsZero() {
+		issued = loggedIn.UTC()
+	}
+	if !issued.IsZero() {
+		creds.IssuedAt = issued.Format(time.RFC3339)
+	}
+	if !expires.IsZero() {
+		creds.Expiration = expires.Format(time.RFC3339)
+	}
+}
+
+// jwtTimes returns the issued and expiry times of a JWT, or zero times for opaque tokens.
+func jwtTimes(token string) (issued, expires time.Time) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return
+	}
+
+	var claims struct {
+		IssuedAt  int64 `json:"iat"`
+		ExpiresAt int64 `json:"exp"`
+	}
+	if json.Unmarshal(payload, &claims) != nil {
+		return
+	}
+	if claims.IssuedAt > 0 {
+		issued = time.Unix(claims.IssuedAt, 0).UTC()
+	}
+	if claims.ExpiresAt > 0 {
+		expires = time.Unix(claims.ExpiresAt, 0).UTC()
+	}
+	return
+}
+
+// Token is a Duplo token obtained interactively, with the OTP code entered during the login, if any.
+type Token struct {
+	Token string
+	OTP   string
+}
+
+// Authenticator obtains Duplo tokens interactively, for example by sending the user to the portal
+// in a browser.
+type Authenticator interface {
+	Authenticate(ctx context.Context, host string, admin bool) (*Token, error)
+}
+
+// AuthenticatorFunc adapts a function to the Authenticator interface.
+type AuthenticatorFunc func(ctx context.Context, host string, admin bool) (*Token, error)
+
+func (f AuthenticatorFunc) Authenticate(ctx context.Context, host string, admin bool) (*Token, error) {
+	return f(ctx, host, admin)
+}
+
+// Client returns a Duplo client for admin (or, if false, tenant) requests, with the token it uses.
+//
+// Options.Token is tried first.  Otherwise, the cached token is validated and used, or a new token
+// is obtained from the Authenticator and cached.
+func (s *Session) Client(ctx context.Context, admin bool) (*duplocloud.Client, *DuploCreds, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Reuse a client with the requested scope.
+	if c := s.clients[admin]; c != nil {
+		return c.client, c.creds, nil
+	}
+	if c := s.clients[true]; c != nil && !admin {
+		return c.client, c.creds, nil
+	}
+
+	client, creds, err := s.login(ctx, admin)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.clients[admin] = &sessionClient{client: client, creds: creds}
+	return client, creds, nil
+}
+
+// Renew logs in interactively, even if the cached token is still valid, and caches the new token.
+// The new token keeps the admin scope of the cached token.
+func (s *Session) Renew(ctx context.Context, admin bool) (*DuploCreds, error) {
+	if s.opts.Authenticator == nil {
+		return nil, ErrInteractiveDisabled
+	}
+
+	cached := &DuploCreds{}
+	if cacheGet(s.opts.TokenCache, tokenEntry(s.cacheKey), cached) && cached.Admin {
+		admin = true
+	}
+
+	client, creds, err := s.loginInteractive(ctx, admin)
+	if err != nil {
+		return nil, err
+	}
+	s.cacheCreds(s.opts.TokenCache, ClassDuploToken, tokenEntry(s.cacheKey), creds, &credsInfo{})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clients[admin] = &sessionClient{client: client, creds: creds}
+	return creds, nil
+}
+
+// login retrieves a Duplo client with the given scope, from the token, the cache or an interactive login.
+func (s *Session) login(ctx context.Context, admin bool) (*duplocloud.Client, *DuploCreds, error) {
+	token := s.opts.Token
+	entry := tokenEntry(s.cacheKey)
+
+	// Try non-interactive auth first.
+	if token != "" {
+		cacheDeleteCreds(s.opts.TokenCache, entry) // never cache explicitly passed creds
+		client, otp, err := s.clientWithOtp(ctx, token, admin)
+		if err != nil {
+			return nil, nil, fmt.Errorf("authentication failure: failed to collect system features: %w", err)
+		}
+
+		// The client is usable, so we can return our result.
+		if client != nil {
+			creds := &DuploCreds{Version: 1, DuploToken: token, NeedOTP: otp != "", Admin: admin}
+			creds.setTokenTimes(time.Time{})
+			return client, creds, nil
+		}
+
+		// Otherwise, an OTP code is needed: continue with interactive auth.
+		if s.opts.Authenticator == nil {
+			return nil, nil, ErrOTPRequired
+		}
+	}
+
+	// Non-interactive auth was not available or not sufficient.
+	if s.opts.Authenticator == nil {
+		return nil, nil, ErrInteractiveDisabled
+	}
+
+	// Next, we load and validate Duplo credentials from the cache.
+	if token == "" {
+		creds := s.cachedToken(ctx)
+		if creds != nil && !creds.HasScope(admin) {
+			slog.Info("cached Duplo token is not an admin token, logging in again", "host", s.cacheKey)
+		} else if creds != nil {
+			// Admin requests need an OTP code, even with a token from a login with one.
+			client, _, err := s.clientWithOtp(ctx, creds.DuploToken, admin)
+			if err == nil && client != nil {
+				creds.FromCache = true
+				if s.opts.OnCachedToken != nil {
+					s.opts.OnCachedToken(creds)
+				}
+				return client, creds, nil
+			}
+		}
+
+		// Clear invalid cached credentials.
+		cacheDeleteCreds(s.opts.TokenCache, entry)
+	}
+
+	// Cached credentials were not available or not sufficient.
+	// So, finally, we try to retrieve and validate Duplo credentials interactively.
+	client, creds, err := s.loginInteractive(ctx, admin)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Write the creds to the cache, unless we started out with a non-interactive token
+	if token == "" {
+		s.cacheToken(creds)
+	}
+	return client, creds, nil
+}
+
+// loginInteractive retrieves and validates Duplo credentials from the Authenticator.
+func (s *Session) loginInteractive(ctx context.Context, admin bool) (*duplocloud.Client, *DuploCreds, error) {
+
+	// Get the token.
+	result, err := s.opts.Authenticator.Authenticate(ctx, s.opts.Host, admin)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get token from interactive session (timed out or canceled): %w", err)
+	}
+	if result == nil || result.Token == "" {
+		return nil, nil, errors.New("authentication failure: failed to get token interactively")
+	}
+
+	// Get the client.
+	client, needsOtp, err := s.clientAndOtpFlag(ctx, result.Token, result.OTP, admin)
+	if err != nil {
+		return nil, nil, fmt.Errorf("authentication failure: failed to collect system features: %w", err)
+	}
+	if needsOtp {
+		return nil, nil, errors.New("authentication failure: the login did not provide an OTP code for admin access")
+	}
+
+	// Build credentials.
+	creds := &DuploCreds{
+		Version:    1,
+		DuploToken: result.Token,
+		NeedOTP:    result.OTP != "",
+		Admin:      admin,
+	}
+	creds.setTokenTimes(time.Now())
+	return client, creds, nil
+}
+
+// clientAndOtpFlag returns a duplo client if and only if the token is valid and OTP is not needed.
+// If the token could not be validated, the error explains why.
+func (s *Session) clientAndOtpFlag(ctx context.Context, token, otp string, admin bool) (*duplocloud.Client, bool, error) {
+	client, err := s.opts.NewClient(s.opts.APIHost, token, otp)
+	if err != nil {
+		return nil, false, err
+	}
+	features, cerr := client.FeaturesSystemContext(ctx) // this API call is doubling as a system "ping"
+
+	// Is the token invalid?
+	if cerr != nil {
+		return nil, false, cerr
+	}
+
+	// Do we need to retrieve a OTP?
+	if admin && features.IsOtpNeeded && otp == "" {
+		return nil, true, nil
+	}
+
+	// Otherwise, the client is usable.
+	return client, false, nil
+}
+
+// clientWithOtp returns a duplo client for a valid token, with an OTP code if one is needed, and the code.
+// The client is nil if a code is needed but none is available.
+func (s *Session) clientWithOtp(ctx context.Context, token string, admin bool) (*duplocloud.Client, string, error) {
+	client, needsOtp, err := s.clientAndOtpFlag(ctx, token, "", admin)
+	if err != nil || !needsOtp {
+		return client, "", err
+	}
+
+	// If OTP is needed, get a code.
+	var otp string
+	if s.opts.OTP != nil {
+		if otp, err = s.opts.OTP(ctx); err != nil {
+			return nil, "", err
+		}
+	}
+	if otp == "" {
+		return nil, "", nil
+	}
+
+	client, _, err = s.clientAndOtpFlag(ctx, token, otp, admin)
+	return client, otp, err
+}
+
+// cachedToken reads the Duplo token from the cache, and validates it.  Invalid tokens are removed.
+func (s *Session) cachedToken(ctx context.Context) *DuploCreds {
+	entry := tokenEntry(s.cacheKey)
+	creds := &DuploCreds{}
+	if !cacheGet(s.opts.TokenCache, entry, creds) || !s.retained(s.opts.TokenCache, ClassDuploToken, entry) {
+		return nil
+	}
+
+	// Check credentials for a known expiry.
+	if remaining, ok := creds.ExpiresIn(time.Now()); ok && remaining <= 0 {
+		slog.Info("cached Duplo token has expired", "cacheKey", s.cacheKey, "expiration", creds.Expiration)
+		cacheDeleteCreds(s.opts.TokenCache, entry)
+		return nil
+	}
+
+	// Check credentials for expiry - by trying to retrieve system features, then by using them.
+	if err := s.pingToken(ctx, creds); err != nil {
+		slog.Debug("cached Duplo token is invalid", "cacheKey", s.cacheKey, "error", err)
+		cacheDeleteCreds(s.opts.TokenCache, entry)
+		return nil
+	}
+
+	return creds
+}
+
+// pingToken checks that a token is valid, by using it to read the system features and the features of a tenant.
+func (s *Session) pingToken(ctx context.Context, creds *DuploCreds) error {
+	client, err := s.opts.NewClient(s.opts.APIHost, creds.DuploToken, "")
+	if err != nil {
+		return err
+	}
+
+	features, ferr := client.FeaturesSystemContext(ctx)
+	if ferr != nil {
+		return ferr
+	}
+	creds.NeedOTP = features.IsOtpNeeded
+
+	tenants, terr := client.ListTenantsForUserContext(ctx)
+	if terr != nil {
+		return terr
+	}
+	if len(*tenants) == 0 {
+		return errors.New("user has no tenants")
+	}
+
+	tenant := (*tenants)[0]
+	if _, terr = client.GetTenantFeaturesContext(ctx, tenant.TenantID); terr != nil {
+		return terr
+	}
+
+	return nil
+}
+
+// cacheToken writes Duplo credentials to the cache.  An admin token is never replaced by a tenant
+// token, as it can happen when admin and tenant logins run at the same time.
+func (s *Session) cacheToken(creds *DuploCreds) {
+	entry := tokenEntry(s.cacheKey)
+	cached := &DuploCreds{}
+	if !creds.Admin && cacheGet(s.opts.TokenCache, entry, cached) && cached.Admin {
+		if cached.DuploToken != creds.DuploToken {
+			slog.Info("keeping cached admin Duplo token", "cacheKey", s.cacheKey)
+			return
+		}
+
+		// The same token, such as one handed off by an admin login, keeps its admin scope.
+		creds.Admin = true
+	}
+
+	s.cacheCreds(s.opts.TokenCache, ClassDuploToken, entry, creds, &credsInfo{})
+}
@@ -0,0 +1,284 @@
+package jit
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/duplocloud/duplo-jit/duplocloud"
+	"github.com/duplocloud/duplo-jit/duplocloud/duplotest"
+)
+
+// newTestSession returns a session for a fake portal, which logs in with the given authenticator.
+func newTestSession(t *testing.T, s *duplotest.Server, opts Options) *Session {
+	t.Helper()
+	opts.Host = s.URL
+	opts.NewClient = func(host, token, otp string) (*duplocloud.Client, error) {
+		client, err := duplocloud.NewClientWithOtp(host, token, otp)
+		if err == nil {
+			client.HTTPClient = s.Client()
+			client.Retry.MaxAttempts = 1
+		}
+		return client, err
+	}
+	session, err := New(opts)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	return session
+}
+
+// testHostKey returns the cache key of a fake portal.
+func testHostKey(t *testing.T, s *duplotest.Server) string {
+	t.Helper()
+	hostKey, err := HostKey(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hostKey
+}
+
+// testAuthenticator logs in as the fake portal would, counting the logins.
+func testAuthenticator(otp string, logins *int) Authenticator {
+	return AuthenticatorFunc(func(ctx context.Context, host string, admin bool) (*Token, error) {
+		*logins++
+		return &Token{Token: duplotest.DefaultToken, OTP: otp}, nil
+	})
+}
+
+func TestDuploCreds_HasScope(t *testing.T) {
+	tests := []struct {
+		name  string
+		creds DuploCreds
+		admin bool
+		want  bool
+	}{
+		{"tenant token for tenant", DuploCreds{}, false, true},
+		{"tenant token for admin", DuploCreds{}, true, false},
+		{"admin token for tenant", DuploCreds{Admin: true}, false, true},
+		{"admin token for admin", DuploCreds{Admin: true}, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.creds.HasScope(tt.admin); got != tt.want {
+				t.Errorf("HasScope(%v) = %v, want %v", tt.admin, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSession_CacheTokenKeepsAdminToken(t *testing.T) {
+	cache := NewMemoryCache()
+	session, err := New(Options{Host: "https://test.example.com", TokenCache: cache})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session.cacheToken(&DuploCreds{Version: 1, DuploToken: "admin-token", Admin: true})
+	session.cacheToken(&DuploCreds{Version: 1, DuploToken: "tenant-token"})
+
+	cached := &DuploCreds{}
+	if !cacheGet(cache, "test.example.com,duplo-creds.json", cached) || cached.DuploToken != "admin-token" {
+		t.Errorf("expected admin token to be kept, got %+v", cached)
+	}
+
+	// A newer admin token replaces the tenant token.
+	session.cacheToken(&DuploCreds{Version: 1, DuploToken: "new-admin-token", Admin: true})
+	if !cacheGet(cache, "test.example.com,duplo-creds.json", cached) || cached.DuploToken != "new-admin-token" {
+		t.Errorf("expected new admin token, got %+v", cached)
+	}
+
+	// The admin token, handed off to a tenant login, keeps its admin scope.
+	session.cacheToken(&DuploCreds{Version: 1, DuploToken: "new-admin-token"})
+	if !cacheGet(cache, "test.example.com,duplo-creds.json", cached) || !cached.Admin {
+		t.Errorf("expected the admin scope to be kept, got %+v", cached)
+	}
+}
+
+func TestSession_CachedAdminTokenForTenant(t *testing.T) {
+	s := duplotest.NewServer(duplotest.Config{Admin: true, OTP: "123456", Tenants: testTenants})
+	defer s.Close()
+	cache := NewMemoryCache()
+	logins := 0
+	cachePut(cache, tokenEntry(testHostKey(t, s)), &DuploCreds{Version: 1, DuploToken: duplotest.DefaultToken, Admin: true})
+
+	// Both tenant and admin requests use the cached token, without another login.  Admin requests
+	// still need an OTP code.
+	var otps int
+	otp := func(context.Context) (string, error) { otps++; return "123456", nil }
+	for _, admin := range []bool{false, true} {
+		session := newTestSession(t, s, Options{Authenticator: testAuthenticator("123456", &logins), TokenCache: cache, OTP: otp})
+		client, creds, err := session.Client(context.Background(), admin)
+		if err != nil || client == nil || creds.DuploToken != duplotest.DefaultToken || !creds.Admin || !creds.FromCache {
+			t.Errorf("admin=%v: expected cached admin token, got %+v, %v", admin, creds, err)
+		}
+	}
+	if logins != 0 || otps != 1 {
+		t.Errorf("expected no login and 1 OTP code, got %d and %d", logins, otps)
+	}
+}
+
+func TestSession_ClientInteractive(t *testing.T) {
+	s := duplotest.NewServer(duplotest.Config{Admin: true, OTP: "123456", Tenants: testTenants})
+	defer s.Close()
+	cache := NewMemoryCache()
+	logins := 0
+	var cachedTokens int
+
+	// The first session logs in and caches the token, and the second uses the cached token.
+	for i := 0; i < 2; i++ {
+		session := newTestSession(t, s, Options{
+			Authenticator: testAuthenticator("123456", &logins),
+			TokenCache:    cache,
+			OTP:           func(context.Context) (string, error) { return "123456", nil },
+			OnCachedToken: func(*DuploCreds) { cachedTokens++ },
+		})
+		_, creds, err := session.Client(context.Background(), true)
+		if err != nil || !creds.Admin || creds.IssuedAt == "" {
+			t.Fatalf("Client() = %+v, %v", creds, err)
+		}
+
+		// The client is reused by the session.
+		if _, again, _ := session.Client(context.Background(), false); again != creds {
+			t.Errorf("expected the admin client to be reused for tenant requests")
+		}
+	}
+	if logins != 1 || cachedTokens != 1 {
+		t.Errorf("expected 1 login and 1 cached token, got %d and %d", logins, cachedTokens)
+	}
+}
+
+func TestSession_ClientToken(t *testing.T) {
+	s := duplotest.NewServer(duplotest.Config{Admin: true, OTP: "123456", Tenants: testTenants})
+	defer s.Close()
+	ctx := context.Background()
+
+	// Without an OTP code, admin access fails.
+	session := newTestSession(t, s, Options{Token: duplotest.DefaultToken})
+	if _, _, err := session.Client(ctx, true); !errors.Is(err, ErrOTPRequired) {
+		t.Errorf("expected ErrOTPRequired, got %v", err)
+	}
+
+	// With one, the token is used as it is, with the requested scope, and never cached.
+	cache := NewMemoryCache()
+	cachePut(cache, tokenEntry(session.cacheKey), &DuploCreds{Version: 1, DuploToken: "cached-token"})
+	session = newTestSession(t, s, Options{
+		Token:      duplotest.DefaultToken,
+		OTP:        func(context.Context) (string, error) { return "123456", nil },
+		TokenCache: cache,
+	})
+	_, creds, err := session.Client(ctx, true)
+	if err != nil || creds.DuploToken != duplotest.DefaultToken || !creds.NeedOTP || !creds.Admin || creds.FromCache {
+		t.Errorf("Client() = %+v, %v", creds, err)
+	}
+	if _, err := cache.Get(tokenEntry(session.cacheKey)); err == nil {
+		t.Error("expected the cached token to be removed")
+	}
+
+	// An invalid token is an error.
+	session = newTestSession(t, s, Options{Token: "wrong-token"})
+	if _, _, err := session.Client(ctx, false); !errors.Is(err, duplocloud.ErrNotAuthenticated) {
+		t.Errorf("expected not authenticated, got %v", err)
+	}
+}
+
+func TestSession_ClientInteractiveDisabled(t *testing.T) {
+	s := duplotest.NewServer(duplotest.Config{Tenants: testTenants})
+	defer s.Close()
+
+	session := newTestSession(t, s, Options{})
+	if _, _, err := session.Client(context.Background(), false); !errors.Is(err, ErrInteractiveDisabled) {
+		t.Errorf("expected ErrInteractiveDisabled, got %v", err)
+	}
+	if s.TotalCalls() != 0 {
+		t.Errorf("expected no calls, got %d", s.TotalCalls())
+	}
+}
+
+func TestSession_Renew(t *testing.T) {
+	s := duplotest.NewServer(duplotest.Config{Admin: true, Tenants: testTenants})
+	defer s.Close()
+	cache := NewMemoryCache()
+	logins := 0
+	session := newTestSession(t, s, Options{Authenticator: testAuthenticator("", &logins), TokenCache: cache})
+	cachePut(cache, tokenEntry(session.cacheKey), &DuploCreds{Version: 1, DuploToken: "old-token", Admin: true})
+
+	// The renewed token keeps the admin scope of the cached token.
+	creds, err := session.Renew(context.Background(), false)
+	if err != nil || !creds.Admin || creds.DuploToken != duplotest.DefaultToken {
+		t.Fatalf("Renew() = %+v, %v", creds, err)
+	}
+	cached := &DuploCreds{}
+	if !cacheGet(cache, tokenEntry(session.cacheKey), cached) || cached.DuploToken != duplotest.DefaultToken {
+		t.Errorf("expected the new token to be cached, got %+v", cached)
+	}
+	if logins != 1 {
+		t.Errorf("expected 1 login, got %d", logins)
+	}
+}
+
+// testJWT returns an unsigned JWT with the given claims.
+func testJWT(claims string) string {
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString([]byte(`{"alg":"none"}`)) + "." + enc.EncodeToString([]byte(claims)) + ".sig"
+}
+
+func TestDuploCreds_SetTokenTimes(t *testing.T) {
+	loggedIn := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name           string
+		token          string
+		loggedIn       time.Time
+		wantIssuedAt   string
+		wantExpiration string
+	}{
+		{"opaque token", "opaque-token", loggedIn, "2024-05-01T12:00:00Z", ""},
+		{"opaque explicit token", "opaque-token", time.Time{}, "", ""},
+		{"jwt", testJWT(`{"iat":1714564800,"exp":1714608000}`), loggedIn, "2024-05-01T12:00:00Z", "2024-05-02T00:00:00Z"},
+		{"jwt without iat", testJWT(`{"exp":1714608000}`), time.Time{}, "", "2024-05-02T00:00:00Z"},
+		{"invalid jwt", "a.%%%.c", loggedIn, "2024-05-01T12:00:00Z", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			creds := &DuploCreds{DuploToken: tt.token}
+			creds.setTokenTimes(tt.loggedIn)
+			if creds.IssuedAt != tt.wantIssuedAt || creds.Expiration != tt.wantExpiration {
+				t.Errorf("got IssuedAt=%q Expiration=%q, want %q %q", creds.IssuedAt, creds.Expiration, tt.wantIssuedAt, tt.wantExpiration)
+			}
+		})
+	}
+}
+
+func TestDuploCreds_ExpiresIn(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	if _, ok := (&DuploCreds{}).ExpiresIn(now); ok {
+		t.Error("expected unknown expiry")
+	}
+	remaining, ok := (&DuploCreds{Expiration: "2024-05-01T12:10:00Z"}).ExpiresIn(now)
+	if !ok || remaining != 10*time.Minute {
+		t.Errorf("ExpiresIn() = %v, %v, want 10m, true", remaining, ok)
+	}
+}
+
+func TestSession_CachedTokenExpired(t *testing.T) {
+	s := duplotest.NewServer(duplotest.Config{Tenants: testTenants})
+	defer s.Close()
+	cache := NewMemoryCache()
+	session := newTestSession(t, s, Options{TokenCache: cache})
+
+	expired := time.Now().UTC().Add(-time.Minute).Format(time.RFC3339)
+	cachePut(cache, tokenEntry(session.cacheKey), &DuploCreds{Version: 1, DuploToken: duplotest.DefaultToken, Expiration: expired})
+
+	if creds := session.cachedToken(context.Background()); creds != nil {
+		t.Errorf("expected expired token to be ignored, got %+v", creds)
+	}
+	if _, err := cache.Get(tokenEntry(session.cacheKey)); err == nil {
+		t.Error("expected expired token to be removed")
+	}
+	if s.TotalCalls() != 0 {
+		t.Errorf("expected no calls, got %d", s.TotalCalls())
+	}
+}
@@ -0,0 +1,142 @@
+// Package jit gets just-in-time AWS and Kubernetes credentials from a DuploCloud portal, for programs
+// that embed duplo-jit instead of running its commands.
+//
+// Unlike the commands, the package never exits the process: every failure is returned as an error.
+// Where credentials are cached, and how Duplo tokens are obtained interactively, are chosen by the
+// caller through Options.
+//
+// The reason for credentials, set on the context with duplocloud.WithJitReason, is sent to the portal
+// when credentials are issued, and kept with the cached credentials.
+package jit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/duplocloud/duplo-jit/duplocloud"
+	clientauthv1beta1 "k8s.io/client-go/pkg/apis/clientauthentication/v1beta1"
+)
+
+// Roles of AWS credentials.
+const (
+	RoleAdmin    = "admin"
+	RoleDuploOps = "duplo-ops"
+	RoleTenant   = "tenant"
+)
+
+var (
+	// ErrInteractiveDisabled is returned when a Duplo token is needed, but none was given, and
+	// the session has no Authenticator.
+	ErrInteractiveDisabled = errors.New("no Duplo token was given, and interactive login is disabled")
+
+	// ErrOTPRequired is returned when the portal requires an OTP code for admin access, but none is
+	// available, and the session has no Authenticator.
+	ErrOTPRequired = errors.New("the portal requires an OTP code for admin access")
+)
+
+// Options configures a Session.
+type Options struct {
+	// Host is the base URL of the portal.
+	Host string
+
+	// APIHost is the base URL of the Duplo API, if it differs from Host.
+	APIHost string
+
+	// Token is a Duplo API token.  It is never cached.  Without it, a token is obtained from the
+	// Authenticator.
+	Token string
+
+	// OTP returns the OTP code used when the portal requires one for admin access, or an empty
+	// string if none is available.  It is only called when a code is needed.
+	OTP func(ctx context.Context) (string, error)
+
+	// Authenticator obtains Duplo tokens interactively.  If nil, interactive logins are disabled,
+	// and cached tokens are not used.
+	Authenticator Authenticator
+
+	// Cache stores AWS and Kubernetes credentials, and the tenants accessible to the user.
+	// If nil, nothing is cached.
+	Cache Cache
+
+	// TokenCache stores the Duplo tokens obtained from the Authenticator.  It may be the same as
+	// Cache.  If nil, every session logs in again.
+	TokenCache Cache
+
+	// Retention is the retention policy of each class of cached credentials, such as ClassAdmin.  Classes
+	// without one are cached until they expire.
+	Retention map[string]Retention
+
+	// CacheKey prefixes the names of cached entries.  It defaults to the host name of Host.
+	CacheKey string
+
+	// NewClient creates the Duplo API clients.  It defaults to duplocloud.NewClientWithOtp.
+	NewClient func(host, token, otp string) (*duplocloud.Client, error)
+
+	// ValidateAws and ValidateK8s check cached credentials that have not expired, for example by
+	// calling AWS or Kubernetes.  If nil, unexpired cached credentials are used as they are.
+	ValidateAws func(ctx context.Context, creds *AwsCredentials) error
+	ValidateK8s func(ctx context.Context, creds *clientauthv1beta1.ExecCredential, tenantName string) error
+
+	// OnCachedToken, if set, is called when a cached Duplo token is used, for example to warn that
+	// it is about to expire.
+	OnCachedToken func(creds *DuploCreds)
+}
+
+// Session gets credentials from a DuploCloud portal.  The Duplo clients it logs in with are reused
+// by later calls.  A Session is safe for concurrent use.
+type Session struct {
+	opts     Options
+	cacheKey string
+
+	mu      sync.Mutex
+	clients map[bool]*sessionClient
+}
+
+// sessionClient is a Duplo client, with the token it uses.
+type sessionClient struct {
+	client *duplocloud.Client
+	creds  *DuploCreds
+}
+
+// New creates a session for the given options.
+func New(opts Options) (*Session, error) {
+	if opts.Host == "" {
+		return nil, errors.New("jit: a host is required")
+	}
+	opts.Host = strings.TrimSuffix(opts.Host, "/")
+	if opts.APIHost == "" {
+		opts.APIHost = opts.Host
+	}
+	opts.APIHost = strings.TrimSuffix(opts.APIHost, "/")
+	if opts.NewClient == nil {
+		opts.NewClient = duplocloud.NewClientWithOtp
+	}
+
+	cacheKey := opts.CacheKey
+	if cacheKey == "" {
+		var err error
+		if cacheKey, err = HostKey(opts.Host); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Session{opts: opts, cacheKey: cacheKey, clients: map[bool]*sessionClient{}}, nil
+}
+
+// Host returns the base URL of the portal.
+func (s *Session) Host() string {
+	return s.opts.Host
+}
+
+// HostKey returns the host name of a portal URL, which identifies the portal in caches and logs.
+func HostKey(host string) (string, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse host: %w", err)
+	}
+	return u.Hostname(), nil
+}
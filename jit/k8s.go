@@ -0,0 +1,155 @@
+package jit
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/duplocloud/duplo-jit/duplocloud"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientauthv1beta1 "k8s.io/client-go/pkg/apis/clientauthentication/v1beta1"
+)
+
+// K8sResult holds Kubernetes credentials, and where they came from.
+type K8sResult struct {
+	Credentials *clientauthv1beta1.ExecCredential
+	CacheKey    string
+	TenantName  string
+	FromCache   bool
+
+	// Reason is the reason given when the credentials were issued, if any.
+	Reason string
+}
+
+func ConvertK8sCreds(creds *duplocloud.DuploPlanK8ClusterConfig) (*clientauthv1beta1.ExecCredential, error) {
+	// Populate cluster info.
+	cluster := clientauthv1beta1.Cluster{Server: creds.ApiServer}
+
+	// Populate CA certificate data.
+	if creds.CertificateAuthorityDataBase64 != "" {
+		data, err := base64.StdEncoding.DecodeString(creds.CertificateAuthorityDataBase64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to base64 decode CA certificate data: %w", err)
+		}
+		cluster.CertificateAuthorityData = data
+	} else {
+		cluster.InsecureSkipTLSVerify = true
+	}
+
+	// Populate token.
+	status := clientauthv1beta1.ExecCredentialStatus{Token: creds.Token}
+
+	// Populate expiration time.
+	var expiration time.Time
+	if creds.LastTokenRefreshTime != nil {
+		expiration = *creds.LastTokenRefreshTime
+
+		// Default expiration time: 55 minutes
+	} else {
+		expiration = time.Now().Add(time.Duration(60*55) * time.Second)
+	}
+	status.ExpirationTimestamp = &metav1.Time{Time: expiration}
+
+	return &clientauthv1beta1.ExecCredential{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "ExecCredential",
+			APIVersion: "client.authentication.k8s.io/v1beta1",
+		},
+		Spec: clientauthv1beta1.ExecCredentialSpec{
+			Cluster: &cluster,
+		},
+		Status: &status,
+	}, nil
+}
+
+// GetPlanK8sCredentials gets admin Kubernetes credentials for the cluster of a plan, from the cache or from Duplo.
+func (s *Session) GetPlanK8sCredentials(ctx context.Context, planID string) (*K8sResult, error) {
+	result := &K8sResult{CacheKey: strings.Join([]string{s.cacheKey, "plan", planID}, ",")}
+
+	return s.getK8sCredentials(ctx, result, true, func(client *duplocloud.Client) (*duplocloud.DuploPlanK8ClusterConfig, duplocloud.ClientError) {
+		return client.AdminGetK8sJitAccessContext(ctx, planID)
+	})
+}
+
+// GetTenantK8sCredentials gets Kubernetes credentials for the tenant with the given ID or name, from the
+// cache or from Duplo.
+func (s *Session) GetTenantK8sCredentials(ctx context.Context, tenant string) (*K8sResult, error) {
+	t, err := s.Tenant(ctx, tenant)
+	if err != nil {
+		return nil, err
+	}
+	result := &K8sResult{
+		CacheKey:   strings.Join([]string{s.cacheKey, "tenant", t.AccountName}, ","),
+		TenantName: t.AccountName,
+	}
+
+	return s.getK8sCredentials(ctx, result, false, func(client *duplocloud.Client) (*duplocloud.DuploPlanK8ClusterConfig, duplocloud.ClientError) {
+		return client.TenantGetK8sJitAccessContext(ctx, t.TenantID)
+	})
+}
+
+// getK8sCredentials completes the result with cached credentials, or with credentials from Duplo.
+func (s *Session) getK8sCredentials(ctx context.Context, result *K8sResult, admin bool,
+	get func(client *duplocloud.Client) (*duplocloud.DuploPlanK8ClusterConfig, duplocloud.ClientError)) (*K8sResult, error) {
+
+	// Try to find credentials from the cache.
+	if result.Credentials = s.cachedK8sCreds(ctx, k8sClass(admin), result.CacheKey, result.TenantName); result.Credentials != nil {
+		result.FromCache = true
+		result.Reason = cacheGetInfo(s.opts.Cache, k8sEntry(result.CacheKey)).Reason
+		return result, nil
+	}
+
+	// Otherwise, get the credentials from Duplo.
+	client, _, err := s.Client(ctx, admin)
+	if err != nil {
+		return nil, err
+	}
+	creds, cerr := get(client)
+	if cerr != nil {
+		return nil, fmt.Errorf("failed to get Kubernetes credentials: %w", cerr)
+	}
+	if result.Credentials, err = ConvertK8sCreds(creds); err != nil {
+		return nil, err
+	}
+
+	result.Reason = duplocloud.JitReason(ctx)
+	s.cacheCreds(s.opts.Cache, k8sClass(admin), k8sEntry(result.CacheKey), result.Credentials, &credsInfo{Reason: result.Reason})
+	return result, nil
+}
+
+// cachedK8sCreds tries to read prior K8s creds of a class from the cache.  Expired or invalid creds, and
+// creds that the retention policy no longer keeps, are removed.
+func (s *Session) cachedK8sCreds(ctx context.Context, class string, cacheKey string, tenantName string) *clientauthv1beta1.ExecCredential {
+	entry := k8sEntry(cacheKey)
+	creds := &clientauthv1beta1.ExecCredential{}
+	if !cacheGet(s.opts.Cache, entry, creds) || !s.retained(s.opts.Cache, class, entry) {
+		return nil
+	}
+
+	// Expires in five minutes or less?
+	if k8sCredsExpiring(creds) {
+		creds = nil
+	}
+
+	// Validate creds by executing ping
+	if creds != nil && s.opts.ValidateK8s != nil {
+		if err := s.opts.ValidateK8s(ctx, creds, tenantName); err != nil {
+			creds = nil
+		}
+	}
+
+	// Clear the cache if the creds expired or invalid
+	if creds == nil {
+		cacheDeleteCreds(s.opts.Cache, entry)
+	}
+	return creds
+}
+
+// k8sCredsExpiring returns true if the creds expire in five minutes or less.
+func k8sCredsExpiring(creds *clientauthv1beta1.ExecCredential) bool {
+	fiveMinutesFromNow := time.Now().UTC().Add(5 * time.Minute)
+	return creds.Status == nil || creds.Status.ExpirationTimestamp == nil || fiveMinutesFromNow.After(creds.Status.ExpirationTimestamp.Time)
+}
@@ -0,0 +1,71 @@
+package jit
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/duplocloud/duplo-jit/duplocloud"
+	"github.com/duplocloud/duplo-jit/duplocloud/duplotest"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientauthv1beta1 "k8s.io/client-go/pkg/apis/clientauthentication/v1beta1"
+)
+
+func TestSession_GetK8sCredentials(t *testing.T) {
+	s := duplotest.NewServer(duplotest.Config{Admin: true, Tenants: testTenants})
+	defer s.Close()
+	cache := NewMemoryCache()
+	ctx := context.Background()
+	session := newTestSession(t, s, Options{Token: duplotest.DefaultToken, Cache: cache})
+
+	result, err := session.GetPlanK8sCredentials(ctx, "nonprod")
+	if err != nil || result.CacheKey != "127.0.0.1,plan,nonprod" || result.FromCache {
+		t.Fatalf("GetPlanK8sCredentials() = %+v, %v", result, err)
+	}
+	if result.Credentials.Status.Token != "duplotest-k8s-nonprod" {
+		t.Errorf("unexpected credentials: %+v", result.Credentials.Status)
+	}
+
+	result, err = session.GetTenantK8sCredentials(ctx, "dev02")
+	if err != nil || result.CacheKey != "127.0.0.1,tenant,dev02" || result.TenantName != "dev02" || result.FromCache {
+		t.Fatalf("GetTenantK8sCredentials() = %+v, %v", result, err)
+	}
+
+	// Unexpired credentials are used from the cache, once validated.
+	creds := result.Credentials
+	creds.Status.ExpirationTimestamp = &metav1.Time{Time: time.Now().Add(time.Hour)}
+	cachePut(cache, k8sEntry(result.CacheKey), creds)
+	var validated string
+	session = newTestSession(t, s, Options{
+		Token: duplotest.DefaultToken,
+		Cache: cache,
+		ValidateK8s: func(ctx context.Context, creds *clientauthv1beta1.ExecCredential, tenantName string) error {
+			validated = tenantName
+			return nil
+		},
+	})
+	result, err = session.GetTenantK8sCredentials(ctx, "dev02")
+	if err != nil || !result.FromCache || validated != "dev02" {
+		t.Errorf("GetTenantK8sCredentials() = %+v, %v, validated %q", result, err, validated)
+	}
+}
+
+func TestConvertK8sCreds(t *testing.T) {
+	creds, err := ConvertK8sCreds(&duplocloud.DuploPlanK8ClusterConfig{
+		ApiServer:                      "https://k8s.example.com",
+		Token:                          "token",
+		CertificateAuthorityDataBase64: base64.StdEncoding.EncodeToString([]byte("ca")),
+	})
+	if err != nil || string(creds.Spec.Cluster.CertificateAuthorityData) != "ca" || creds.Spec.Cluster.InsecureSkipTLSVerify {
+		t.Errorf("ConvertK8sCreds() = %+v, %v", creds, err)
+	}
+	if creds.Status.ExpirationTimestamp == nil {
+		t.Error("expected a default expiration")
+	}
+
+	if _, err := ConvertK8sCreds(&duplocloud.DuploPlanK8ClusterConfig{CertificateAuthorityDataBase64: "%%%"}); err == nil {
+		t.Error("expected an error for invalid CA data")
+	}
+}
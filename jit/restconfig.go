@@ -0,0 +1,144 @@
+package jit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	clientauthv1beta1 "k8s.io/client-go/pkg/apis/clientauthentication/v1beta1"
+	rest "k8s.io/client-go/rest"
+)
+
+// PlanRestConfig returns a client-go rest.Config for the cluster of a plan, with admin credentials.
+// See TenantRestConfig.
+func (s *Session) PlanRestConfig(ctx context.Context, planID string) (*rest.Config, error) {
+	return s.restConfig(ctx, func(ctx context.Context) (*K8sResult, error) {
+		return s.GetPlanK8sCredentials(ctx, planID)
+	})
+}
+
+// TenantRestConfig returns a client-go rest.Config for the cluster of the tenant with the given ID or name.
+//
+// Its transport sets the bearer token of every request, and gets a new token from the cache or from Duplo
+// five minutes before the token expires.  A request rejected with 401 Unauthorized is retried once, with a
+// new token from Duplo.
+//
+//	config, err := session.TenantRestConfig(ctx, "dev")
+//	clientset, err := kubernetes.NewForConfig(config)
+func (s *Session) TenantRestConfig(ctx context.Context, tenant string) (*rest.Config, error) {
+	return s.restConfig(ctx, func(ctx context.Context) (*K8sResult, error) {
+		return s.GetTenantK8sCredentials(ctx, tenant)
+	})
+}
+
+// restConfig returns a rest.Config for the cluster of the credentials, which keeps their token fresh.
+func (s *Session) restConfig(ctx context.Context, get func(ctx context.Context) (*K8sResult, error)) (*rest.Config, error) {
+	tokens := &k8sTokenSource{session: s, get: get}
+	if err := tokens.refresh(ctx, false); err != nil {
+		return nil, err
+	}
+	creds := tokens.creds
+
+	config := &rest.Config{
+		Host: creds.Spec.Cluster.Server,
+		WrapTransport: func(rt http.RoundTripper) http.RoundTripper {
+			return &k8sTransport{tokens: tokens, base: rt}
+		},
+	}
+	if len(creds.Spec.Cluster.CertificateAuthorityData) != 0 {
+		config.TLSClientConfig.CAData = creds.Spec.Cluster.CertificateAuthorityData
+	} else {
+		config.TLSClientConfig.Insecure = creds.Spec.Cluster.InsecureSkipTLSVerify
+	}
+	return config, nil
+}
+
+// k8sTokenSource holds the current Kubernetes credentials of a rest.Config.
+type k8sTokenSource struct {
+	session *Session
+	get     func(ctx context.Context) (*K8sResult, error)
+
+	mu       sync.Mutex
+	creds    *clientauthv1beta1.ExecCredential
+	cacheKey string
+}
+
+// token returns the current token, or a new one if it expires soon or is the rejected one.
+func (ts *k8sTokenSource) token(ctx context.Context, rejected string) (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	renew := rejected != "" && ts.creds.Status.Token == rejected
+	if renew || k8sCredsExpiring(ts.creds) {
+		if err := ts.refresh(ctx, renew); err != nil {
+			return "", err
+		}
+	}
+	return ts.creds.Status.Token, nil
+}
+
+// refresh gets credentials from the cache or from Duplo.  Cached credentials are removed first when the
+// token was rejected.  The caller must hold the lock, once the token source is shared.
+func (ts *k8sTokenSource) refresh(ctx context.Context, rejected bool) error {
+	if rejected {
+		cacheDeleteCreds(ts.session.opts.Cache, k8sEntry(ts.cacheKey))
+	}
+
+	result, err := ts.get(ctx)
+	if err != nil {
+		return err
+	}
+	if result.Credentials.Spec.Cluster == nil || result.Credentials.Status == nil {
+		return fmt.Errorf("invalid Kubernetes credentials for %s", result.CacheKey)
+	}
+	ts.creds, ts.cacheKey = result.Credentials, result.CacheKey
+	return nil
+}
+
+// k8sTransport sets the bearer token of Kubernetes API requests.
+type k8sTransport struct {
+	tokens *k8sTokenSource
+	base   http.RoundTripper
+}
+
+func (t *k8sTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.tokens.token(req.Context(), "")
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(withBearerToken(req, token))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	// Retry once with a new token, unless the body cannot be sent again.
+	body := req.Body
+	if body != nil && body != http.NoBody {
+		if req.GetBody == nil {
+			return resp, nil
+		}
+		if body, err = req.GetBody(); err != nil {
+			return resp, nil
+		}
+	}
+	if token, err = t.tokens.token(req.Context(), token); err != nil {
+		slog.Warn("cannot renew Kubernetes credentials", "error", err)
+		return resp, nil
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	retry := withBearerToken(req, token)
+	retry.Body = body
+	return t.base.RoundTrip(retry)
+}
+
+// withBearerToken returns a copy of the request with the bearer token.
+func withBearerToken(req *http.Request, token string) *http.Request {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
@@ -0,0 +1,166 @@
+package jit
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/duplocloud/duplo-jit/duplocloud"
+	"github.com/duplocloud/duplo-jit/duplocloud/duplotest"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	rest "k8s.io/client-go/rest"
+)
+
+// testK8sServer is a fake Kubernetes API server, which rejects the given tokens.
+type testK8sServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	rejected map[string]bool
+	requests []string
+}
+
+func newTestK8sServer(rejected ...string) *testK8sServer {
+	k := &testK8sServer{rejected: map[string]bool{}}
+	for _, token := range rejected {
+		k.rejected[token] = true
+	}
+	k.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		k.requests = append(k.requests, token+":"+string(body))
+		if k.rejected[token] {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	return k
+}
+
+func (k *testK8sServer) caData() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: k.Certificate().Raw})
+}
+
+// newRestConfigSession returns a session for a fake portal, whose Kubernetes API server is k.
+func newRestConfigSession(t *testing.T, k *testK8sServer, cache Cache) (*duplotest.Server, *Session) {
+	t.Helper()
+	s := duplotest.NewServer(duplotest.Config{Admin: true, Tenants: testTenants, K8sServer: k.URL, K8sCAData: k.caData()})
+	t.Cleanup(s.Close)
+	return s, newTestSession(t, s, Options{Token: duplotest.DefaultToken, Cache: cache})
+}
+
+// testRestRequest sends a request with the client of the config.
+func testRestRequest(t *testing.T, config *rest.Config, body string) int {
+	t.Helper()
+	client, err := rest.HTTPClientFor(config)
+	if err != nil {
+		t.Fatalf("HTTPClientFor() error: %v", err)
+	}
+	resp, err := client.Post(config.Host+"/api", "text/plain", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Post() error: %v", err)
+	}
+	_ = resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestSession_PlanRestConfig(t *testing.T) {
+	k := newTestK8sServer()
+	defer k.Close()
+	s, session := newRestConfigSession(t, k, nil)
+
+	config, err := session.PlanRestConfig(context.Background(), "nonprod")
+	if err != nil {
+		t.Fatalf("PlanRestConfig() error: %v", err)
+	}
+	if config.Host != k.URL || string(config.CAData) != string(k.caData()) || config.Insecure || config.BearerToken != "" {
+		t.Errorf("unexpected config: %+v", config)
+	}
+
+	// The credentials of the fake portal expire at once, so every request gets a new token.
+	for i := 0; i < 2; i++ {
+		if status := testRestRequest(t, config, "ping"); status != http.StatusOK {
+			t.Errorf("expected 200, got %d", status)
+		}
+	}
+	if got := s.Calls("v3/admin/plans/nonprod/k8sConfig"); got != 3 {
+		t.Errorf("expected 3 calls, got %d", got)
+	}
+	if got := strings.Join(k.requests, " "); got != "duplotest-k8s-nonprod:ping duplotest-k8s-nonprod:ping" {
+		t.Errorf("unexpected requests: %s", got)
+	}
+}
+
+func TestSession_RestConfigRetry(t *testing.T) {
+	k := newTestK8sServer("stale-token")
+	defer k.Close()
+	cache := NewMemoryCache()
+	s, session := newRestConfigSession(t, k, cache)
+
+	// Cache unexpired credentials, whose token the cluster rejects.
+	creds, err := ConvertK8sCreds(&duplocloud.DuploPlanK8ClusterConfig{
+		ApiServer:                      k.URL,
+		Token:                          "stale-token",
+		CertificateAuthorityDataBase64: base64.StdEncoding.EncodeToString(k.caData()),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds.Status.ExpirationTimestamp = &metav1.Time{Time: time.Now().Add(time.Hour)}
+	cachePut(cache, k8sEntry("127.0.0.1,plan,nonprod"), creds)
+
+	config, err := session.PlanRestConfig(context.Background(), "nonprod")
+	if err != nil {
+		t.Fatalf("PlanRestConfig() error: %v", err)
+	}
+
+	// The rejected request is sent again, with a token from Duplo.
+	if status := testRestRequest(t, config, "ping"); status != http.StatusOK {
+		t.Errorf("expected 200, got %d", status)
+	}
+	if got := strings.Join(k.requests, " "); got != "stale-token:ping duplotest-k8s-nonprod:ping" {
+		t.Errorf("unexpected requests: %s", got)
+	}
+	if got := s.Calls("v3/admin/plans/nonprod/k8sConfig"); got != 1 {
+		t.Errorf("expected 1 call, got %d", got)
+	}
+
+	// A token rejected again is not retried twice.
+	k.mu.Lock()
+	k.rejected["duplotest-k8s-nonprod"] = true
+	k.requests = nil
+	k.mu.Unlock()
+	if status := testRestRequest(t, config, "ping"); status != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", status)
+	}
+	if len(k.requests) != 2 {
+		t.Errorf("expected 2 requests, got %v", k.requests)
+	}
+}
+
+func TestSession_TenantRestConfig(t *testing.T) {
+	k := newTestK8sServer()
+	defer k.Close()
+	_, session := newRestConfigSession(t, k, nil)
+
+	if _, err := session.TenantRestConfig(context.Background(), "missing"); err == nil {
+		t.Error("expected an error for a missing tenant")
+	}
+	config, err := session.TenantRestConfig(context.Background(), "dev01")
+	if err != nil {
+		t.Fatalf("TenantRestConfig() error: %v", err)
+	}
+	if status := testRestRequest(t, config, ""); status != http.StatusOK {
+		t.Errorf("expected 200, got %d", status)
+	}
+}
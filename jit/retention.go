@@ -0,0 +1,155 @@
+package jit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"time"
+)
+
+// Classes of credentials, each with its own retention policy.
+const (
+	ClassAdmin      = "admin"
+	ClassDuploOps   = "duplo-ops"
+	ClassPlanK8s    = "plan-k8s"
+	ClassTenantAws  = "tenant-aws"
+	ClassTenantK8s  = "tenant-k8s"
+	ClassDuploToken = "duplo-token"
+)
+
+// Classes lists the classes of credentials.
+var Classes = []string{ClassAdmin, ClassDuploOps, ClassPlanK8s, ClassTenantAws, ClassTenantK8s, ClassDuploToken}
+
+// Retention is how long a class of credentials stays in the cache.  The zero value keeps them until
+// they expire.
+type Retention struct {
+	// MaxAge, if positive, is the longest time the credentials stay in the cache.
+	MaxAge time.Duration
+
+	// NoCache keeps the credentials out of the cache.
+	NoCache bool
+
+	// WipeOnLogout keeps the credentials only as long as the Duplo token they were issued with:
+	// logging out, or logging in again, removes them.  It does not apply to ClassDuploToken.
+	WipeOnLogout bool
+}
+
+// awsClass returns the class of AWS credentials for a role.
+func awsClass(role string) string {
+	if role == RoleTenant {
+		return ClassTenantAws
+	}
+	return role
+}
+
+// k8sClass returns the class of Kubernetes credentials for a plan (admin) or a tenant.
+func k8sClass(admin bool) string {
+	if admin {
+		return ClassPlanK8s
+	}
+	return ClassTenantK8s
+}
+
+// cacheCreds writes credentials of a class to the cache, unless its retention policy keeps them out.
+func (s *Session) cacheCreds(cache Cache, class string, entry string, creds interface{}, info *credsInfo) {
+	retention := s.opts.Retention[class]
+	if retention.NoCache {
+		cacheDeleteCreds(cache, entry)
+		return
+	}
+
+	info.CachedAt = time.Now().UTC().Format(time.RFC3339)
+	if retention.WipeOnLogout && class != ClassDuploToken {
+		info.TokenHash = s.tokenHash(class != ClassTenantAws && class != ClassTenantK8s)
+	}
+	cachePutCreds(cache, entry, creds, info)
+}
+
+// retained checks cached credentials of a class against its retention policy, or against the window of
+// the elevation that cached them.  Credentials that are no longer retained are removed.
+func (s *Session) retained(cache Cache, class string, entry string) bool {
+	info := cacheGetInfo(cache, entry)
+
+	// The window of an elevation replaces the retention policy.
+	if info.Until != "" {
+		return !removeEndedElevation(cache, entry)
+	}
+	if reason := s.retentionEnded(class, info, time.Now()); reason != "" {
+		slog.Info("removing cached credentials", "entry", entry, "reason", reason)
+		cacheDeleteCreds(cache, entry)
+		return false
+	}
+	return true
+}
+
+// removeEndedElevation removes cached credentials held by an elevation whose window has passed, such as
+// one whose process was killed before it could end the elevation.  It returns true if they were removed.
+func removeEndedElevation(cache Cache, entry string) bool {
+	if reason := elevationEnded(cacheGetInfo(cache, entry), time.Now()); reason != "" {
+		slog.Info("removing cached credentials", "entry", entry, "reason", reason)
+		cacheDeleteCreds(cache, entry)
+		return true
+	}
+	return false
+}
+
+// elevationEnded returns why the window of the elevation that cached credentials has passed, or an empty
+// string if it has not, or if the credentials were not cached by an elevation.
+func elevationEnded(info *credsInfo, now time.Time) string {
+	if info.Until == "" {
+		return ""
+	}
+	if until, err := time.Parse(time.RFC3339, info.Until); err != nil || !now.Before(until) {
+		return "elevation ended"
+	}
+	return ""
+}
+
+// retentionEnded returns why cached credentials are no longer retained by the retention policy of their
+// class, or an empty string.
+func (s *Session) retentionEnded(class string, info *credsInfo, now time.Time) string {
+	retention := s.opts.Retention[class]
+	if retention.NoCache {
+		return "not cached by the retention policy"
+	}
+	if retention.MaxAge > 0 {
+		if cachedAt, err := time.Parse(time.RFC3339, info.CachedAt); err != nil || now.Sub(cachedAt) >= retention.MaxAge {
+			return "maximum age reached"
+		}
+	}
+	if retention.WipeOnLogout && class != ClassDuploToken {
+		if info.TokenHash == "" || info.TokenHash != s.tokenHash(class != ClassTenantAws && class != ClassTenantK8s) {
+			return "logged out"
+		}
+	}
+	return ""
+}
+
+// tokenHash identifies the Duplo token of the session without revealing it: the token of a client of the
+// session, the given token, or the cached token.  It is empty if there is none.
+func (s *Session) tokenHash(admin bool) string {
+	var token string
+	s.mu.Lock()
+	if c := s.clients[admin]; c != nil {
+		token = c.creds.DuploToken
+	} else if c := s.clients[true]; c != nil {
+		token = c.creds.DuploToken
+	}
+	s.mu.Unlock()
+
+	if token == "" {
+		token = s.opts.Token
+	}
+	if token == "" {
+		creds := &DuploCreds{}
+		if cacheGet(s.opts.TokenCache, tokenEntry(s.cacheKey), creds) {
+			token = creds.DuploToken
+		}
+	}
+	if token == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,129 @@
+package jit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/duplocloud/duplo-jit/duplocloud/duplotest"
+)
+
+func TestSession_Retention(t *testing.T) {
+	s := duplotest.NewServer(duplotest.Config{Admin: true, DuploOpsEnabled: true, Tenants: testTenants})
+	defer s.Close()
+	ctx := context.Background()
+	retention := map[string]Retention{
+		ClassAdmin:      {MaxAge: time.Hour},
+		ClassDuploOps:   {WipeOnLogout: true},
+		ClassTenantAws:  {NoCache: true},
+		ClassDuploToken: {NoCache: true},
+	}
+
+	// getAws gets AWS credentials from a new session, returning whether they came from the cache.
+	cache := NewMemoryCache()
+	var logins int
+	getAws := func(role, tenant string) bool {
+		t.Helper()
+		session := newTestSession(t, s, Options{Authenticator: testAuthenticator("", &logins), Cache: cache, TokenCache: cache, Retention: retention})
+		result, err := session.GetAwsCredentials(ctx, role, tenant)
+		if err != nil {
+			t.Fatalf("GetAwsCredentials() error: %v", err)
+		}
+		return result.FromCache
+	}
+
+	// Credentials are cached up to their maximum age.
+	if getAws(RoleAdmin, "") || !getAws(RoleAdmin, "") {
+		t.Error("expected admin credentials from the cache")
+	}
+	entry := awsEntry("127.0.0.1,admin")
+	info := cacheGetInfo(cache, entry)
+	info.CachedAt = time.Now().Add(-2 * time.Hour).UTC().Format(time.RFC3339)
+	cachePut(cache, infoEntry(entry), info)
+	if getAws(RoleAdmin, "") {
+		t.Error("expected admin credentials to be removed after their maximum age")
+	}
+
+	// Credentials without caching are always from Duplo, as is the Duplo token.
+	if getAws(RoleTenant, "dev01") || getAws(RoleTenant, "dev01") {
+		t.Error("expected tenant credentials not to be cached")
+	}
+	if cacheGet(cache, awsEntry("127.0.0.1,tenant,dev01"), &AwsCredentials{}) || cacheGet(cache, tokenEntry("127.0.0.1"), &DuploCreds{}) {
+		t.Error("expected no cached tenant credentials or Duplo token")
+	}
+
+	// Credentials wiped on logout are cached while the Duplo token is.
+	retention[ClassDuploToken] = Retention{}
+	if getAws(RoleDuploOps, "") || !getAws(RoleDuploOps, "") {
+		t.Error("expected duplo-ops credentials from the cache")
+	}
+	cacheDeleteCreds(cache, tokenEntry("127.0.0.1"))
+	if getAws(RoleDuploOps, "") {
+		t.Error("expected duplo-ops credentials to be removed on logout")
+	}
+}
+
+func TestSession_ElevateAws(t *testing.T) {
+	s := duplotest.NewServer(duplotest.Config{Admin: true, Tenants: testTenants})
+	defer s.Close()
+	ctx := context.Background()
+	cache := NewMemoryCache()
+
+	// Elevated credentials are held for their window, whatever the retention policy.
+	opts := Options{Token: duplotest.DefaultToken, Cache: cache, Retention: map[string]Retention{ClassAdmin: {NoCache: true}}}
+	session := newTestSession(t, s, opts)
+	elevated, err := session.ElevateAws(ctx, RoleAdmin, 30*time.Minute)
+	if err != nil || elevated.Until.IsZero() || time.Until(elevated.Until) > 30*time.Minute {
+		t.Fatalf("ElevateAws() = %+v, %v", elevated, err)
+	}
+	result, err := newTestSession(t, s, opts).GetAwsCredentials(ctx, RoleAdmin, "")
+	if err != nil || !result.FromCache || !result.Until.Equal(elevated.Until) {
+		t.Errorf("GetAwsCredentials() = %+v, %v", result, err)
+	}
+
+	// Ending the elevation removes them.
+	session.EndElevation(elevated)
+	if cacheGet(cache, awsEntry(elevated.CacheKey), &AwsCredentials{}) {
+		t.Error("expected elevated credentials to be removed")
+	}
+
+	// So does the end of the window.
+	if elevated, err = session.ElevateAws(ctx, RoleAdmin, time.Minute); err != nil {
+		t.Fatalf("ElevateAws() error: %v", err)
+	}
+	info := cacheGetInfo(cache, awsEntry(elevated.CacheKey))
+	info.Until = time.Now().Add(-time.Second).UTC().Format(time.RFC3339)
+	cachePut(cache, infoEntry(awsEntry(elevated.CacheKey)), info)
+	if result, err = session.GetAwsCredentials(ctx, RoleAdmin, ""); err != nil || result.FromCache {
+		t.Errorf("GetAwsCredentials() = %+v, %v", result, err)
+	}
+
+	// So does reading them after the end of a window that was never ended, such as by a killed process,
+	// even with unreadable credentials or without a retention policy.
+	for _, corrupt := range []bool{false, true} {
+		if elevated, err = session.ElevateAws(ctx, RoleAdmin, time.Minute); err != nil {
+			t.Fatalf("ElevateAws() error: %v", err)
+		}
+		entry := awsEntry(elevated.CacheKey)
+		info = cacheGetInfo(cache, entry)
+		info.Until = time.Now().Add(-time.Second).UTC().Format(time.RFC3339)
+		cachePut(cache, infoEntry(entry), info)
+		if corrupt {
+			_ = cache.Put(entry, []byte("{"))
+		}
+		if creds := newTestSession(t, s, Options{Token: duplotest.DefaultToken, Cache: cache}).cachedAwsCreds(ctx, ClassAdmin, elevated.CacheKey); creds != nil {
+			t.Errorf("cachedAwsCreds() = %+v, expected no credentials", creds)
+		}
+		if _, err := cache.Get(entry); err == nil || cacheGetInfo(cache, entry).Until != "" {
+			t.Errorf("expected the credentials of the ended elevation to be removed (corrupt: %v)", corrupt)
+		}
+	}
+
+	// Only admin and duplo-ops credentials can be elevated, and only into a cache.
+	if _, err := session.ElevateAws(ctx, RoleTenant, time.Minute); err == nil {
+		t.Error("expected an error for tenant credentials")
+	}
+	if _, err := newTestSession(t, s, Options{Token: duplotest.DefaultToken}).ElevateAws(ctx, RoleAdmin, time.Minute); err == nil {
+		t.Error("expected an error without a cache")
+	}
+}
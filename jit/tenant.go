@@ -0,0 +1,209 @@
+package jit
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/duplocloud/duplo-jit/duplocloud"
+	"github.com/google/uuid"
+)
+
+// TenantNamespacePrefix is prepended to tenant names to form their Kubernetes namespace.
+const TenantNamespacePrefix = "duploservices-"
+
+// TenantCacheTTL is how long the tenants accessible to the user are cached, for resolving tenant names and IDs.
+const TenantCacheTTL = 24 * time.Hour
+
+// maxTenantSuggestions is the number of similar tenant names suggested when a tenant is not found.
+const maxTenantSuggestions = 3
+
+// TenantCache is the cached list of tenants accessible to the user.
+type TenantCache struct {
+	Tenants    []duplocloud.UserTenant `json:"Tenants"`
+	Expiration string                  `json:"Expiration"`
+}
+
+// TenantNotFoundError is returned when no tenant accessible to the user matches the requested ID or name.
+type TenantNotFoundError struct {
+	Tenant      string
+	Suggestions []string
+}
+
+func (e *TenantNotFoundError) Error() string {
+	return fmt.Sprintf("tenant '%s' missing or not allowed", e.Tenant)
+}
+
+// Hint lists the suggested tenant names, if any.
+func (e *TenantNotFoundError) Hint() string {
+	if len(e.Suggestions) == 0 {
+		return ""
+	}
+	quoted := make([]string, len(e.Suggestions))
+	for i, name := range e.Suggestions {
+		quoted[i] = fmt.Sprintf("'%s'", name)
+	}
+	return fmt.Sprintf("did you mean %s?", strings.Join(quoted, " or "))
+}
+
+// ParseTenantID returns the canonical (lower-case, hyphenated) form of a tenant ID,
+// or false if the string is not a UUID.
+func ParseTenantID(tenantIDorName string) (string, bool) {
+	id, err := uuid.Parse(strings.TrimSpace(tenantIDorName))
+	if err != nil {
+		return "", false
+	}
+	return id.String(), true
+}
+
+// NormalizeTenantName converts a tenant name or namespace into the form used to compare tenant names.
+func NormalizeTenantName(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	return strings.TrimPrefix(name, TenantNamespacePrefix)
+}
+
+// ResolveTenant finds the tenant matching an ID or name, among the tenants accessible to the user.
+//
+// IDs may be given in any form accepted by uuid.Parse.  Names are matched case-insensitively,
+// and may be given as a Kubernetes namespace ("duploservices-NAME").
+func ResolveTenant(tenants []duplocloud.UserTenant, tenantIDorName string) (*duplocloud.UserTenant, error) {
+	// Match by ID.
+	if id, ok := ParseTenantID(tenantIDorName); ok {
+		for i := range tenants {
+			if strings.EqualFold(tenants[i].TenantID, id) {
+				return &tenants[i], nil
+			}
+		}
+		return nil, &TenantNotFoundError{Tenant: tenantIDorName}
+	}
+
+	// Match by name, preferring an exact match.
+	name := NormalizeTenantName(tenantIDorName)
+	var match *duplocloud.UserTenant
+	for i := range tenants {
+		if tenants[i].AccountName == tenantIDorName {
+			return &tenants[i], nil
+		}
+		if match == nil && NormalizeTenantName(tenants[i].AccountName) == name {
+			match = &tenants[i]
+		}
+	}
+	if match != nil {
+		return match, nil
+	}
+
+	return nil, &TenantNotFoundError{Tenant: tenantIDorName, Suggestions: suggestTenantNames(tenants, name)}
+}
+
+// Tenant finds the tenant matching an ID or name, among the tenants accessible to the user.
+//
+// The tenants cached for the host are tried first.  A Duplo client is only retrieved, and the tenants
+// listed and cached, when the cache is missing, expired or does not contain the tenant.
+func (s *Session) Tenant(ctx context.Context, tenantIDorName string) (*duplocloud.UserTenant, error) {
+	// Try to find the tenant in the cache.
+	if tenants := s.cachedTenants(); tenants != nil {
+		if tenant, err := ResolveTenant(tenants, tenantIDorName); err == nil {
+			return tenant, nil
+		}
+	}
+
+	// Otherwise, list the tenants from Duplo.
+	client, _, err := s.Client(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	tenants, cerr := client.ListTenantsForUserContext(ctx)
+	if cerr != nil {
+		return nil, fmt.Errorf("failed to resolve tenant '%s': %w", tenantIDorName, cerr)
+	}
+	s.cacheTenants(*tenants)
+
+	return ResolveTenant(*tenants, tenantIDorName)
+}
+
+// cachedTenants reads the tenants accessible to the user from the cache, or returns nil if they are
+// missing or expired.
+func (s *Session) cachedTenants() []duplocloud.UserTenant {
+	entry := tenantsEntry(s.cacheKey)
+	cached := &TenantCache{}
+	if !cacheGet(s.opts.Cache, entry, cached) {
+		return nil
+	}
+
+	// Check the tenants for expiry.
+	expiration, err := time.Parse(time.RFC3339, cached.Expiration)
+	if err != nil || time.Now().UTC().After(expiration) {
+		cacheDelete(s.opts.Cache, entry)
+		return nil
+	}
+
+	return cached.Tenants
+}
+
+// cacheTenants writes the tenants accessible to the user to the cache.
+func (s *Session) cacheTenants(tenants []duplocloud.UserTenant) {
+	cachePut(s.opts.Cache, tenantsEntry(s.cacheKey), &TenantCache{
+		Tenants:    tenants,
+		Expiration: time.Now().UTC().Add(TenantCacheTTL).Format(time.RFC3339),
+	})
+}
+
+// suggestTenantNames returns the tenant names closest to a normalized name.
+func suggestTenantNames(tenants []duplocloud.UserTenant, name string) []string {
+	type candidate struct {
+		name     string
+		distance int
+	}
+
+	// Allow roughly one typo for every three characters.
+	maxDistance := len(name) / 3
+	if maxDistance < 2 {
+		maxDistance = 2
+	}
+
+	var candidates []candidate
+	for _, tenant := range tenants {
+		other := NormalizeTenantName(tenant.AccountName)
+		distance := levenshtein(name, other)
+		if distance <= maxDistance || (name != "" && strings.Contains(other, name)) {
+			candidates = append(candidates, candidate{tenant.AccountName, distance})
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return candidates[i].name < candidates[j].name
+	})
+
+	var names []string
+	for i := 0; i < len(candidates) && i < maxTenantSuggestions; i++ {
+		names = append(names, candidates[i].name)
+	}
+	return names
+}
+
+// levenshtein returns the edit distance between two strings.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
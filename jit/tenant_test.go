@@ -0,0 +1,244 @@
+package jit
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/duplocloud/duplo-jit/duplocloud"
+	"github.com/duplocloud/duplo-jit/duplocloud/duplotest"
+)
+
+var testTenants = []duplocloud.UserTenant{
+	{TenantID: "2f6c1a0e-4b8d-4c3a-9e57-0d1f2a3b4c5d", AccountName: "default"},
+	{TenantID: "8a7b6c5d-4e3f-4a1b-8c9d-0e1f2a3b4c5d", AccountName: "dev01"},
+	{TenantID: "1c2d3e4f-5a6b-4c7d-8e9f-0a1b2c3d4e5f", AccountName: "dev02"},
+	{TenantID: "9f8e7d6c-5b4a-4d3c-8b2a-1f0e9d8c7b6a", AccountName: "production"},
+	{TenantID: "0a1b2c3d-4e5f-4a6b-9c8d-7e6f5a4b3c2d", AccountName: "Staging"},
+}
+
+func TestParseTenantID(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+		ok    bool
+	}{
+		{"canonical", "8a7b6c5d-4e3f-4a1b-8c9d-0e1f2a3b4c5d", "8a7b6c5d-4e3f-4a1b-8c9d-0e1f2a3b4c5d", true},
+		{"upper case", "8A7B6C5D-4E3F-4A1B-8C9D-0E1F2A3B4C5D", "8a7b6c5d-4e3f-4a1b-8c9d-0e1f2a3b4c5d", true},
+		{"no hyphens", "8a7b6c5d4e3f4a1b8c9d0e1f2a3b4c5d", "8a7b6c5d-4e3f-4a1b-8c9d-0e1f2a3b4c5d", true},
+		{"braces", "{8a7b6c5d-4e3f-4a1b-8c9d-0e1f2a3b4c5d}", "8a7b6c5d-4e3f-4a1b-8c9d-0e1f2a3b4c5d", true},
+		{"short name", "dev01", "", false},
+		{"long name", "a-very-long-tenant-name-of-36-chars", "", false},
+		{"32 non-hex characters", "zzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzz", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ParseTenantID(tt.input)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("ParseTenantID(%q) = %q, %v, want %q, %v", tt.input, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestResolveTenant(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		wantID string
+	}{
+		{"by ID", "1c2d3e4f-5a6b-4c7d-8e9f-0a1b2c3d4e5f", "1c2d3e4f-5a6b-4c7d-8e9f-0a1b2c3d4e5f"},
+		{"by upper case ID", "1C2D3E4F-5A6B-4C7D-8E9F-0A1B2C3D4E5F", "1c2d3e4f-5a6b-4c7d-8e9f-0a1b2c3d4e5f"},
+		{"by name", "dev01", "8a7b6c5d-4e3f-4a1b-8c9d-0e1f2a3b4c5d"},
+		{"by name, any case", "DEV01", "8a7b6c5d-4e3f-4a1b-8c9d-0e1f2a3b4c5d"},
+		{"by mixed case name", "staging", "0a1b2c3d-4e5f-4a6b-9c8d-7e6f5a4b3c2d"},
+		{"by namespace", "duploservices-dev02", "1c2d3e4f-5a6b-4c7d-8e9f-0a1b2c3d4e5f"},
+		{"by upper case namespace", "DuploServices-Production", "9f8e7d6c-5b4a-4d3c-8b2a-1f0e9d8c7b6a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tenant, err := ResolveTenant(testTenants, tt.input)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tenant.TenantID != tt.wantID {
+				t.Errorf("ResolveTenant(%q) = %s, want %s", tt.input, tenant.TenantID, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestResolveTenant_PrefersExactName(t *testing.T) {
+	tenants := []duplocloud.UserTenant{
+		{TenantID: "2f6c1a0e-4b8d-4c3a-9e57-0d1f2a3b4c5d", AccountName: "QA"},
+		{TenantID: "8a7b6c5d-4e3f-4a1b-8c9d-0e1f2a3b4c5d", AccountName: "qa"},
+	}
+
+	tenant, err := ResolveTenant(tenants, "qa")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tenant.AccountName != "qa" {
+		t.Errorf("expected the exact match 'qa', got '%s'", tenant.AccountName)
+	}
+}
+
+func TestResolveTenant_NotFound(t *testing.T) {
+	tests := []struct {
+		name            string
+		input           string
+		wantSuggestions []string
+	}{
+		{"typo", "dev1", []string{"dev01", "dev02"}},
+		{"namespace with typo", "duploservices-prodution", []string{"production"}},
+		{"substring", "prod", []string{"production"}},
+		{"nothing similar", "sandbox-tenant", nil},
+		{"unknown ID", "5e4d3c2b-1a0f-4e9d-8c7b-6a5f4e3d2c1b", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tenant, err := ResolveTenant(testTenants, tt.input)
+			if tenant != nil {
+				t.Fatalf("expected no tenant, got %v", tenant)
+			}
+
+			var notFound *TenantNotFoundError
+			if !errors.As(err, &notFound) {
+				t.Fatalf("expected a TenantNotFoundError, got %v", err)
+			}
+			if !reflect.DeepEqual(notFound.Suggestions, tt.wantSuggestions) {
+				t.Errorf("suggestions = %v, want %v", notFound.Suggestions, tt.wantSuggestions)
+			}
+		})
+	}
+}
+
+func TestTenantNotFoundHint(t *testing.T) {
+	err := &TenantNotFoundError{Tenant: "dev1", Suggestions: []string{"dev01", "dev02"}}
+
+	if got, want := err.Error(), "tenant 'dev1' missing or not allowed"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+	if got, want := err.Hint(), "did you mean 'dev01' or 'dev02'?"; got != want {
+		t.Errorf("Hint() = %q, want %q", got, want)
+	}
+	if got := (&TenantNotFoundError{Tenant: "dev1"}).Hint(); got != "" {
+		t.Errorf("expected no hint without suggestions, got %q", got)
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"dev", "", 3},
+		{"dev01", "dev01", 0},
+		{"dev1", "dev01", 1},
+		{"kitten", "sitting", 3},
+	}
+
+	for _, tt := range tests {
+		if got := levenshtein(tt.a, tt.b); got != tt.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// newTenantsSession returns a session for a fake portal that lists the test tenants.
+func newTenantsSession(t *testing.T, cache Cache) (*Session, *duplotest.Server) {
+	t.Helper()
+	s := duplotest.NewServer(duplotest.Config{Tenants: testTenants})
+	t.Cleanup(s.Close)
+	return newTestSession(t, s, Options{Token: duplotest.DefaultToken, Cache: cache}), s
+}
+
+func TestSession_Tenant_Cached(t *testing.T) {
+	cache := NewMemoryCache()
+	session, s := newTenantsSession(t, cache)
+	ctx := context.Background()
+
+	// The first call lists and caches the tenants.
+	tenant, err := session.Tenant(ctx, "DEV01")
+	if err != nil || tenant.TenantID != "8a7b6c5d-4e3f-4a1b-8c9d-0e1f2a3b4c5d" || tenant.AccountName != "dev01" {
+		t.Errorf("unexpected tenant: %+v, %v", tenant, err)
+	}
+	if got := s.Calls("admin/GetTenantsForUser"); got != 1 {
+		t.Fatalf("expected 1 listing, got %d", got)
+	}
+
+	// Later calls are answered from the cache, without a client.
+	session, s = newTenantsSession(t, cache)
+	tenant, err = session.Tenant(ctx, "1c2d3e4f-5a6b-4c7d-8e9f-0a1b2c3d4e5f")
+	if err != nil || tenant.AccountName != "dev02" {
+		t.Errorf("unexpected tenant: %+v, %v", tenant, err)
+	}
+	if s.TotalCalls() != 0 {
+		t.Errorf("expected no calls, got %d", s.TotalCalls())
+	}
+
+	// Other hosts have their own cache.
+	session, s = newTenantsSession(t, cache)
+	session.cacheKey = "other.example.com"
+	if _, err := session.Tenant(ctx, "dev01"); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Calls("admin/GetTenantsForUser"); got != 1 {
+		t.Errorf("expected 1 listing, got %d", got)
+	}
+}
+
+func TestSession_Tenant_RefreshesOnMiss(t *testing.T) {
+	cache := NewMemoryCache()
+	session, s := newTenantsSession(t, cache)
+
+	// Cache an outdated list of tenants.
+	session.cacheTenants(testTenants[:1])
+
+	tenant, err := session.Tenant(context.Background(), "production")
+	if err != nil || tenant.TenantID != "9f8e7d6c-5b4a-4d3c-8b2a-1f0e9d8c7b6a" {
+		t.Errorf("unexpected tenant: %+v, %v", tenant, err)
+	}
+	if got := s.Calls("admin/GetTenantsForUser"); got != 1 {
+		t.Errorf("expected 1 listing, got %d", got)
+	}
+	if got := session.cachedTenants(); len(got) != len(testTenants) {
+		t.Errorf("expected the cache to be refreshed, got %v", got)
+	}
+}
+
+func TestSession_Tenant_NotFound(t *testing.T) {
+	session, _ := newTenantsSession(t, nil)
+
+	_, err := session.Tenant(context.Background(), "dev1")
+	var notFound *TenantNotFoundError
+	if !errors.As(err, &notFound) || len(notFound.Suggestions) == 0 {
+		t.Errorf("expected a tenant not found error with suggestions, got %v", err)
+	}
+}
+
+func TestSession_CachedTenants_Expired(t *testing.T) {
+	cache := NewMemoryCache()
+	session, _ := newTenantsSession(t, cache)
+
+	cachePut(cache, tenantsEntry(session.cacheKey), &TenantCache{
+		Tenants:    testTenants,
+		Expiration: time.Now().UTC().Add(-time.Minute).Format(time.RFC3339),
+	})
+	if got := session.cachedTenants(); got != nil {
+		t.Errorf("expected expired tenants to be ignored, got %v", got)
+	}
+
+	session, _ = newTenantsSession(t, nil)
+	session.cacheTenants(testTenants)
+	if got := session.cachedTenants(); got != nil {
+		t.Errorf("expected no tenants with caching disabled, got %v", got)
+	}
+}